@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/qq51529210/web/jwt"
+)
+
+// JSON Web Key, RFC 7517, public members only.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// oct
+	K string `json:"k"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// A parsed key in JWKS.
+type jwksKey struct {
+	kid string
+	alg jwt.Alg
+	key interface{}
+}
+
+// Parse a JWK Set, or a single JWK.
+func parseJWKS(data []byte) ([]*jwksKey, error) {
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+	if set.Keys == nil {
+		k := new(jwk)
+		err = json.Unmarshal(data, k)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, k)
+	}
+	var keys []*jwksKey
+	for _, k := range set.Keys {
+		// Skip encryption keys.
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.Key()
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %w", k.Kid, err)
+		}
+		keys = append(keys, &jwksKey{kid: k.Kid, alg: jwt.Alg(k.Alg), key: key})
+	}
+	if len(keys) < 1 {
+		return nil, errors.New("jwks has no signing key")
+	}
+	return keys, nil
+}
+
+// Find keys which may verify a token of alg and kid.
+func findJWKS(keys []*jwksKey, alg jwt.Alg, kid string) []*jwksKey {
+	var found []*jwksKey
+	for _, k := range keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		found = append(found, k)
+	}
+	return found
+}
+
+// Decode base64url member.
+func jwkBytes(name, s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("missing %q", name)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %q: %w", name, err)
+	}
+	return b, nil
+}
+
+// Return the key of k.
+func (k *jwk) Key() (interface{}, error) {
+	switch k.Kty {
+	case "oct":
+		return jwkBytes("k", k.K)
+	case "RSA":
+		n, err := jwkBytes("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := jwkBytes("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		ee := new(big.Int).SetBytes(e)
+		if !ee.IsInt64() || ee.Int64() > 1<<31-1 {
+			return nil, errors.New(`invalid "e"`)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(ee.Int64())}, nil
+	case "EC":
+		cv, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := jwkBytes("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := jwkBytes("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: cv, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !cv.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := jwkBytes("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New(`invalid "x" length`)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+	}
+}
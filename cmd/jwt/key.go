@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/qq51529210/web/jwt"
+)
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+func (c *command) keygen(args []string) int {
+	f := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	f.SetOutput(c.stderr)
+	typ := f.String("type", "", "key type: hs, rsa, ec or ed25519")
+	bits := f.Int("bits", 0, "hs secret bits(default 256) or rsa key bits(default 2048)")
+	curve := f.String("curve", "P-256", "ec curve: P-256, P-384 or P-521")
+	out := f.String("out", "-", "output file of secret or private key PEM")
+	pub := f.String("pub", "", "output file of public key PEM")
+	if f.Parse(args) != nil {
+		return exitUsage
+	}
+	var (
+		data []byte
+		key  crypto.Signer
+		err  error
+	)
+	switch *typ {
+	case "hs":
+		if *bits == 0 {
+			*bits = 256
+		}
+		if *bits < 8 || *bits%8 != 0 {
+			return c.fail(exitUsage, fmt.Errorf("invalid hs bits %d", *bits))
+		}
+		b := make([]byte, *bits/8)
+		_, err = rand.Read(b)
+		if err != nil {
+			return c.fail(exitUsage, err)
+		}
+		data = append([]byte(base64.RawURLEncoding.EncodeToString(b)), '\n')
+	case "rsa":
+		if *bits == 0 {
+			*bits = 2048
+		}
+		key, err = rsa.GenerateKey(rand.Reader, *bits)
+	case "ec":
+		cv, ok := curves[*curve]
+		if !ok {
+			return c.fail(exitUsage, fmt.Errorf("unknown curve %q", *curve))
+		}
+		key, err = ecdsa.GenerateKey(cv, rand.Reader)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return c.fail(exitUsage, fmt.Errorf("unknown key type %q", *typ))
+	}
+	if err != nil {
+		return c.fail(exitUsage, err)
+	}
+	if key != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return c.fail(exitUsage, err)
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if *pub != "" {
+			der, err = x509.MarshalPKIXPublicKey(key.Public())
+			if err != nil {
+				return c.fail(exitUsage, err)
+			}
+			err = ioutil.WriteFile(*pub, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+			if err != nil {
+				return c.fail(exitUsage, err)
+			}
+		}
+	}
+	err = c.writeFile(*out, data, 0600)
+	if err != nil {
+		return c.fail(exitUsage, err)
+	}
+	return exitOK
+}
+
+// Read file, "-" means stdin.
+func (c *command) readFile(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(c.stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// Write file, "-" means stdout.
+func (c *command) writeFile(name string, data []byte, perm os.FileMode) error {
+	if name == "-" {
+		_, err := c.stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(name, data, perm)
+}
+
+// Read token from args[0] or stdin.
+func (c *command) readToken(args []string) (string, error) {
+	if len(args) > 1 {
+		return "", errors.New("too many arguments")
+	}
+	if len(args) == 1 && args[0] != "-" {
+		return args[0], nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(c.stdin, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Parse key file, return []byte(hs secret), *rsa.PrivateKey, *rsa.PublicKey,
+// *ecdsa.PrivateKey, *ecdsa.PublicKey, ed25519.PrivateKey or ed25519.PublicKey.
+// Content which is not PEM is a base64url hs secret, the same as the output of keygen and "k" of JWKS.
+func parseKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		s := strings.TrimRight(strings.TrimSpace(string(data)), "=")
+		if s == "" {
+			return nil, errors.New("empty hs secret")
+		}
+		secret, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hs secret, it must be base64url: %w", err)
+		}
+		return secret, nil
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+	}
+}
+
+// Return default algorithm of key.
+func defaultAlg(key interface{}) (jwt.Alg, error) {
+	switch k := key.(type) {
+	case []byte:
+		return jwt.HS256, nil
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return jwt.RS256, nil
+	case *ecdsa.PrivateKey:
+		return defaultAlg(&k.PublicKey)
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jwt.ES256, nil
+		case elliptic.P384():
+			return jwt.ES384, nil
+		case elliptic.P521():
+			return jwt.ES512, nil
+		}
+		return "", fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return jwt.EdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// Return a Generator of alg by key.
+func newGenerator(alg jwt.Alg, key interface{}) (jwt.Generator, error) {
+	switch k := key.(type) {
+	case []byte:
+		switch alg {
+		case jwt.HS256, jwt.HS384, jwt.HS512:
+			return jwt.NewHSGenerator(alg, k), nil
+		}
+	case *rsa.PrivateKey:
+		switch alg {
+		case jwt.RS256, jwt.RS384, jwt.RS512:
+			return jwt.NewRSGenerator(alg, k), nil
+		case jwt.PS256, jwt.PS384, jwt.PS512:
+			return jwt.NewPSGenerator(alg, k, nil), nil
+		}
+	case *ecdsa.PrivateKey:
+		switch alg {
+		case jwt.ES256, jwt.ES384, jwt.ES512:
+			return jwt.NewESGenerator(alg, k), nil
+		}
+	case ed25519.PrivateKey:
+		if alg == jwt.EdDSA {
+			return jwt.NewEdGenerator(k), nil
+		}
+	default:
+		return nil, fmt.Errorf("%T can not sign", key)
+	}
+	return nil, fmt.Errorf("alg %q does not match key type %T", alg, key)
+}
+
+// Return a Verifier of alg by key, private keys are accepted.
+func newVerifier(alg jwt.Alg, key interface{}) (jwt.Verifier, error) {
+	switch k := key.(type) {
+	case []byte:
+		switch alg {
+		case jwt.HS256, jwt.HS384, jwt.HS512:
+			return jwt.NewHSVerifier(alg, k), nil
+		}
+	case *rsa.PrivateKey:
+		return newVerifier(alg, &k.PublicKey)
+	case *rsa.PublicKey:
+		switch alg {
+		case jwt.RS256, jwt.RS384, jwt.RS512:
+			return jwt.NewRSVerifier(alg, k), nil
+		case jwt.PS256, jwt.PS384, jwt.PS512:
+			return jwt.NewPSVerifier(alg, k, nil), nil
+		}
+	case *ecdsa.PrivateKey:
+		return newVerifier(alg, &k.PublicKey)
+	case *ecdsa.PublicKey:
+		switch alg {
+		case jwt.ES256, jwt.ES384, jwt.ES512:
+			return jwt.NewESVerifier(alg, k), nil
+		}
+	case ed25519.PrivateKey:
+		return newVerifier(alg, k.Public())
+	case ed25519.PublicKey:
+		if alg == jwt.EdDSA {
+			return jwt.NewEdVerifier(k), nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil, fmt.Errorf("alg %q does not match key type %T", alg, key)
+}
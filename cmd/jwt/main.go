@@ -0,0 +1,91 @@
+// Command jwt mint, decode and verify JSON Web Tokens.
+//
+//	jwt keygen -type hs|rsa|ec|ed25519 [-bits n] [-curve P-256] [-out file] [-pub file]
+//	jwt sign   -alg ALG -key file [-claims file] [-header file] [-exp 1h] [-nbf 0s] [-iat]
+//	jwt decode [token]
+//	jwt verify -key file | -jwks file [-alg ALG] [-leeway 0s] [token]
+//
+// Token is read from stdin if not given, "-" as a file name means stdin.
+// A key file which is not PEM is a base64url hs secret, as written by keygen,
+// it is decoded the same as "k" of an "oct" JWK.
+//
+// Exit codes:
+//
+//	0 success
+//	1 token is invalid(malformed, bad signature, unknown key)
+//	2 usage or I/O error
+//	3 signature is valid, but claims are not(exp, nbf, iat)
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes.
+const (
+	exitOK      = 0
+	exitInvalid = 1
+	exitUsage   = 2
+	exitClaims  = 3
+)
+
+const usage = `usage: jwt <command> [flags]
+
+commands:
+  keygen  generate HS secret, RSA, EC or Ed25519 key
+  sign    sign a token from JSON claims
+  decode  decode and pretty-print a token without verifying
+  verify  verify a token against a key or JWKS, then check claims
+
+run "jwt <command> -h" for flags of a command.
+
+exit codes:
+  0 success
+  1 token is invalid
+  2 usage or I/O error
+  3 signature is valid, but claims are not
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Run command args, return exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) < 1 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	c := &command{stdin: stdin, stdout: stdout, stderr: stderr}
+	switch args[0] {
+	case "keygen":
+		return c.keygen(args[1:])
+	case "sign":
+		return c.sign(args[1:])
+	case "decode":
+		return c.decode(args[1:])
+	case "verify":
+		return c.verify(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+// Context of a command.
+type command struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Print error to stderr and return code.
+func (c *command) fail(code int, err error) int {
+	fmt.Fprintf(c.stderr, "jwt: %v\n", err)
+	return code
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qq51529210/web/jwt"
+)
+
+func test_Run(t *testing.T, code int, stdin string, args ...string) string {
+	var stdout, stderr bytes.Buffer
+	n := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	if n != code {
+		t.Fatalf("%v: exit %d, want %d: %s", args, n, code, stderr.String())
+	}
+	return stdout.String()
+}
+
+func Test_Cmd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	claims := filepath.Join(dir, "claims.json")
+	err = ioutil.WriteFile(claims, []byte(`{"sub":"test"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		typ  string
+		algs []string
+	}{
+		{"hs", []string{"HS256", "HS384", "HS512"}},
+		{"rsa", []string{"RS256", "PS384", "RS512"}},
+		{"ec", []string{"ES256"}},
+		{"ed25519", []string{"EdDSA"}},
+	} {
+		key := filepath.Join(dir, c.typ)
+		pub := key + ".pub"
+		test_Run(t, exitOK, "", "keygen", "-type", c.typ, "-out", key, "-pub", pub)
+		if c.typ == "hs" {
+			pub = key
+		}
+		for _, alg := range c.algs {
+			token := strings.TrimSpace(test_Run(t, exitOK, "", "sign", "-alg", alg, "-key", key, "-claims", claims, "-exp", "1h"))
+			out := test_Run(t, exitOK, "", "decode", token)
+			if !strings.Contains(out, `"sub": "test"`) {
+				t.Fatal(out)
+			}
+			test_Run(t, exitOK, token, "verify", "-key", pub, "-alg", alg)
+			test_Run(t, exitInvalid, token+"x", "verify", "-key", pub)
+			// Expired.
+			now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+			test_Run(t, exitClaims, "", "verify", "-q", "-key", pub, token)
+			test_Run(t, exitOK, "", "verify", "-q", "-key", pub, "-leeway", "90m", token)
+			now = time.Now
+		}
+	}
+	// Wrong key.
+	test_Run(t, exitOK, "", "keygen", "-type", "ec", "-curve", "P-384", "-out", filepath.Join(dir, "ec2"))
+	token := strings.TrimSpace(test_Run(t, exitOK, "", "sign", "-key", filepath.Join(dir, "ec2")))
+	test_Run(t, exitInvalid, "", "verify", "-key", filepath.Join(dir, "ec.pub"), token)
+	// Usage.
+	test_Run(t, exitUsage, "", "sign", "-alg", "HS256", "-key", filepath.Join(dir, "rsa"))
+	test_Run(t, exitUsage, "", "unknown")
+}
+
+func Test_Cmd_JWKS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rsa")
+	test_Run(t, exitOK, "", "keygen", "-type", "rsa", "-out", file)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parseKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &key.(*rsa.PrivateKey).PublicKey
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"oct","kid":"hs","k":"c2VjcmV0"},{"kty":"RSA","kid":"1","alg":"RS256","n":"%s","e":"%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()))
+	jwksFile := filepath.Join(dir, "jwks.json")
+	err = ioutil.WriteFile(jwksFile, []byte(jwks), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := filepath.Join(dir, "header.json")
+	err = ioutil.WriteFile(header, []byte(`{"kid":"1"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := strings.TrimSpace(test_Run(t, exitOK, "", "sign", "-key", file, "-header", header))
+	test_Run(t, exitOK, token, "verify", "-jwks", jwksFile)
+	// No key matches kid.
+	err = ioutil.WriteFile(header, []byte(`{"kid":"2"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token = strings.TrimSpace(test_Run(t, exitOK, "", "sign", "-key", file, "-header", header))
+	test_Run(t, exitInvalid, token, "verify", "-jwks", jwksFile)
+	// The hs secret file is decoded the same as "k".
+	secret := filepath.Join(dir, "hs")
+	err = ioutil.WriteFile(secret, []byte("c2VjcmV0\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(header, []byte(`{"kid":"hs"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token = strings.TrimSpace(test_Run(t, exitOK, "", "sign", "-key", secret, "-header", header))
+	test_Run(t, exitOK, token, "verify", "-jwks", jwksFile)
+	test_Run(t, exitOK, token, "verify", "-key", secret)
+	t2, err := jwt.Inspect(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwt.NewHSVerifier(jwt.HS256, []byte("secret")).Verify(token[:strings.LastIndexByte(token, '.')], t2.Signature) != nil {
+		t.Fatal("hs secret is not decoded")
+	}
+	// Not base64url.
+	err = ioutil.WriteFile(secret, []byte("secret!"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test_Run(t, exitUsage, "", "sign", "-key", secret)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/qq51529210/web/jwt"
+)
+
+// For testing.
+var now = time.Now
+
+func (c *command) sign(args []string) int {
+	f := flag.NewFlagSet("sign", flag.ContinueOnError)
+	f.SetOutput(c.stderr)
+	alg := f.String("alg", "", "algorithm, default by key type")
+	keyFile := f.String("key", "", "base64url hs secret or private key PEM file")
+	claimsFile := f.String("claims", "", `JSON claims file, "-" means stdin`)
+	headerFile := f.String("header", "", "JSON extra header file")
+	exp := f.Duration("exp", 0, `set "exp" to now + exp`)
+	nbf := f.Duration("nbf", -1, `set "nbf" to now + nbf`)
+	iat := f.Bool("iat", false, `set "iat" to now`)
+	if f.Parse(args) != nil {
+		return exitUsage
+	}
+	if *keyFile == "" {
+		return c.fail(exitUsage, errors.New("-key is required"))
+	}
+	data, err := c.readFile(*keyFile)
+	if err != nil {
+		return c.fail(exitUsage, err)
+	}
+	key, err := parseKey(data)
+	if err != nil {
+		return c.fail(exitUsage, err)
+	}
+	a := jwt.Alg(*alg)
+	if a == "" {
+		a, err = defaultAlg(key)
+		if err != nil {
+			return c.fail(exitUsage, err)
+		}
+	}
+	gen, err := newGenerator(a, key)
+	if err != nil {
+		return c.fail(exitUsage, err)
+	}
+	// Header and claims.
+	header := make(map[string]interface{})
+	if *headerFile != "" {
+		err = c.readJSON(*headerFile, &header)
+		if err != nil {
+			return c.fail(exitUsage, fmt.Errorf("header: %w", err))
+		}
+	}
+	payload := make(map[string]interface{})
+	if *claimsFile != "" {
+		err = c.readJSON(*claimsFile, &payload)
+		if err != nil {
+			return c.fail(exitUsage, fmt.Errorf("claims: %w", err))
+		}
+	}
+	t := now().Unix()
+	if *exp > 0 {
+		payload[jwt.EXP] = t + int64(*exp/time.Second)
+	}
+	if *nbf >= 0 {
+		payload[jwt.NBF] = t + int64(*nbf/time.Second)
+	}
+	if *iat {
+		payload[jwt.IAT] = t
+	}
+	token, err := gen.Generate(header, payload)
+	if err != nil {
+		return c.fail(exitUsage, err)
+	}
+	fmt.Fprintln(c.stdout, token)
+	return exitOK
+}
+
+// Read JSON object file.
+func (c *command) readJSON(name string, v *map[string]interface{}) error {
+	data, err := c.readFile(name)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+	if *v == nil {
+		return errors.New("not a JSON object")
+	}
+	return nil
+}
+
+func (c *command) decode(args []string) int {
+	f := flag.NewFlagSet("decode", flag.ContinueOnError)
+	f.SetOutput(c.stderr)
+	if f.Parse(args) != nil {
+		return exitUsage
+	}
+	token, err := c.readToken(f.Args())
+	if err != nil {
+		return c.fail(exitUsage, err)
+	}
+	t, err := jwt.Inspect(token)
+	if err != nil {
+		return c.fail(exitInvalid, err)
+	}
+	c.print(t.Header, t.Payload)
+	fmt.Fprintf(c.stdout, "signature: %s\n", base64.RawURLEncoding.EncodeToString(t.Signature))
+	c.printClaims(t.Payload, 0)
+	return exitOK
+}
+
+func (c *command) verify(args []string) int {
+	f := flag.NewFlagSet("verify", flag.ContinueOnError)
+	f.SetOutput(c.stderr)
+	alg := f.String("alg", "", "expected algorithm, default is any algorithm matching the key")
+	keyFile := f.String("key", "", "base64url hs secret, public key, private key or certificate PEM file")
+	jwksFile := f.String("jwks", "", "JWK Set or JWK file")
+	leeway := f.Duration("leeway", 0, "clock skew allowed when checking exp, nbf and iat")
+	quiet := f.Bool("q", false, "print nothing, only exit code")
+	if f.Parse(args) != nil {
+		return exitUsage
+	}
+	if (*keyFile == "") == (*jwksFile == "") {
+		return c.fail(exitUsage, errors.New("one of -key or -jwks is required"))
+	}
+	token, err := c.readToken(f.Args())
+	if err != nil {
+		return c.fail(exitUsage, err)
+	}
+	// Load keys.
+	var keys []*jwksKey
+	if *keyFile != "" {
+		data, err := c.readFile(*keyFile)
+		if err != nil {
+			return c.fail(exitUsage, err)
+		}
+		key, err := parseKey(data)
+		if err != nil {
+			return c.fail(exitUsage, err)
+		}
+		keys = append(keys, &jwksKey{key: key})
+	} else {
+		data, err := c.readFile(*jwksFile)
+		if err != nil {
+			return c.fail(exitUsage, err)
+		}
+		keys, err = parseJWKS(data)
+		if err != nil {
+			return c.fail(exitUsage, err)
+		}
+	}
+	// Choose keys by unverified header.
+	t, err := jwt.Inspect(token)
+	if err != nil {
+		return c.fail(exitInvalid, err)
+	}
+	if *alg != "" && jwt.Alg(*alg) != t.Alg {
+		return c.fail(exitInvalid, fmt.Errorf("alg %q is not expected %q", t.Alg, *alg))
+	}
+	kid, _ := t.Header["kid"].(string)
+	if *jwksFile != "" {
+		keys = findJWKS(keys, t.Alg, kid)
+		if len(keys) < 1 {
+			return c.fail(exitInvalid, fmt.Errorf("no key in jwks matches alg %q and kid %q", t.Alg, kid))
+		}
+	}
+	// Try keys.
+	var header, payload map[string]interface{}
+	for _, k := range keys {
+		var v jwt.Verifier
+		v, err = newVerifier(t.Alg, k.key)
+		if err != nil {
+			continue
+		}
+		header, payload, err = jwt.Verify(token, func(jwt.Alg) jwt.Verifier { return v })
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return c.fail(exitInvalid, err)
+	}
+	if *quiet {
+		if len(checkClaims(payload, *leeway)) > 0 {
+			return exitClaims
+		}
+		return exitOK
+	}
+	c.print(header, payload)
+	fmt.Fprintln(c.stdout, "signature: valid")
+	if c.printClaims(payload, *leeway) > 0 {
+		return exitClaims
+	}
+	return exitOK
+}
+
+// Pretty-print header and payload.
+func (c *command) print(header, payload map[string]interface{}) {
+	for _, v := range []struct {
+		name string
+		data map[string]interface{}
+	}{{"header", header}, {"payload", payload}} {
+		b, _ := json.MarshalIndent(v.data, "", "  ")
+		fmt.Fprintf(c.stdout, "%s: %s\n", v.name, b)
+	}
+}
+
+// Print time claims and problems, return count of problems.
+func (c *command) printClaims(payload map[string]interface{}, leeway time.Duration) int {
+	for _, name := range []string{jwt.IAT, jwt.NBF, jwt.EXP} {
+		if n, ok := payload[name].(float64); ok {
+			fmt.Fprintf(c.stdout, "%s: %s\n", name, time.Unix(int64(n), 0).UTC().Format(time.RFC3339))
+		}
+	}
+	problems := checkClaims(payload, leeway)
+	if len(problems) < 1 {
+		fmt.Fprintln(c.stdout, "claims: valid")
+		return 0
+	}
+	fmt.Fprintf(c.stdout, "claims: invalid, %s\n", strings.Join(problems, ", "))
+	return len(problems)
+}
+
+// Check "exp", "nbf" and "iat", return problems.
+func checkClaims(payload map[string]interface{}, leeway time.Duration) []string {
+	var problems []string
+	t := now()
+	for _, name := range []string{jwt.EXP, jwt.NBF, jwt.IAT} {
+		v, ok := payload[name]
+		if !ok {
+			continue
+		}
+		n, ok := v.(float64)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%q is not a number", name))
+			continue
+		}
+		ct := time.Unix(int64(n), 0)
+		switch name {
+		case jwt.EXP:
+			if !t.Before(ct.Add(leeway)) {
+				problems = append(problems, "expired")
+			}
+		case jwt.NBF:
+			if t.Add(leeway).Before(ct) {
+				problems = append(problems, "not valid yet")
+			}
+		case jwt.IAT:
+			if t.Add(leeway).Before(ct) {
+				problems = append(problems, "issued in the future")
+			}
+		}
+	}
+	return problems
+}
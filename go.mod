@@ -1,3 +1,3 @@
 module github.com/qq51529210/web
 
-go 1.15
+go 1.16
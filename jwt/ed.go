@@ -0,0 +1,76 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"sync"
+)
+
+var edGenPool sync.Pool
+
+func init() {
+	edGenPool.New = func() interface{} {
+		return NewEdGenerator(nil)
+	}
+}
+
+func NewEdGenerator(key ed25519.PrivateKey) *EdGenerator {
+	s := new(EdGenerator)
+	s.Init(key)
+	return s
+}
+
+func NewEdVerifier(key ed25519.PublicKey) *EdVerifier {
+	s := new(EdVerifier)
+	s.Init(key)
+	return s
+}
+
+func GenerateEdDSA(header, payload map[string]interface{}, key ed25519.PrivateKey) (string, error) {
+	g := edGenPool.Get().(*EdGenerator)
+	g.key = key
+	token, err := g.Generate(header, payload)
+	edGenPool.Put(g)
+	return token, err
+}
+
+// EdDSA with Ed25519 curve, RFC 8037.
+type EdGenerator struct {
+	enc encoder
+	key ed25519.PrivateKey
+}
+
+func (g *EdGenerator) Init(key ed25519.PrivateKey) {
+	g.key = key
+	g.enc.Init()
+}
+
+func (g *EdGenerator) Generate(header, payload map[string]interface{}) (string, error) {
+	header[ALG] = EdDSA
+	// Encode
+	err := g.enc.Enc(header, payload)
+	if err != nil {
+		return "", err
+	}
+	// Ed25519 signs the message itself, no pre-hash.
+	sign := ed25519.Sign(g.key, g.enc.token)
+	// '.' between payload and signature.
+	g.enc.token = append(g.enc.token, '.')
+	// Base64 signature.
+	g.enc.Base64(sign)
+	return string(g.enc.token), nil
+}
+
+type EdVerifier struct {
+	key ed25519.PublicKey
+}
+
+func (v *EdVerifier) Init(key ed25519.PublicKey) {
+	v.key = key
+}
+
+func (v *EdVerifier) Verify(data string, signature []byte) error {
+	if !ed25519.Verify(v.key, string2bytes(data), signature) {
+		return ErrSignature
+	}
+	return nil
+}
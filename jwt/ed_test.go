@@ -0,0 +1,35 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func Test_Ed(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := test_Generate(NewEdGenerator(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(token)
+	test_Verify(t, token, NewEdVerifier(pub))
+	test_Safe(t, NewSafeEdGenerator(key), NewSafeEdVerifier(pub))
+}
+
+func Benchmark_EdDSA_Generate(b *testing.B) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	benchmark_Generate(b, NewEdGenerator(key))
+}
+
+func Benchmark_EdDSA_Verify(b *testing.B) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	token, err := test_Generate(NewEdGenerator(key))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmark_Verify(b, token, NewEdVerifier(pub))
+}
@@ -3,55 +3,34 @@ package jwt
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"hash"
 	"math/big"
-	"math/bits"
 	"sync"
 )
 
-// Use for ES algorithm.
-const _s = bits.UintSize / 8
-
 // Use for ES algorithm.
 type bigint struct {
 	b    []byte
 	r, s big.Int
 }
 
-// Copy from:
-// go/src/math/big/nat.go func (z nat) bytes(buf []byte) (i int)
-// go/src/math/big/int.go func (x *Int) Bytes() []byte
-func (b *bigint) Encode(r, s *big.Int) []byte {
-	sz, rz := s.Bits(), r.Bits()
-	n := (len(sz) + len(rz)) * _s
-	if cap(b.b) < n {
-		b.b = make([]byte, n)
+// Encode r and s into a fixed length(2*n) big-endian buffer, as RFC 7518 3.4 required.
+func (b *bigint) Encode(r, s *big.Int, n int) []byte {
+	if cap(b.b) < n*2 {
+		b.b = make([]byte, n*2)
 	} else {
-		b.b = b.b[:n]
-	}
-	i := len(b.b)
-	for _, d := range sz {
-		for j := 0; j < _s; j++ {
-			i--
-			b.b[i] = byte(d)
-			d >>= 8
-		}
-	}
-	for i < len(b.b) && b.b[i] == 0 {
-		i++
-	}
-	for _, d := range rz {
-		for j := 0; j < _s; j++ {
-			i--
-			b.b[i] = byte(d)
-			d >>= 8
-		}
+		b.b = b.b[:n*2]
 	}
-	for i < len(b.b) && b.b[i] == 0 {
-		i++
-	}
-	return b.b[i:]
+	r.FillBytes(b.b[:n])
+	s.FillBytes(b.b[n:])
+	return b.b
+}
+
+// Return the byte size of curve's order.
+func curveBytes(c elliptic.Curve) int {
+	return (c.Params().BitSize + 7) / 8
 }
 
 func (b *bigint) Decode(buf []byte) {
@@ -68,13 +47,13 @@ var (
 
 func init() {
 	es256GenPool.New = func() interface{} {
-		return NewESGenerator(HS256, nil)
+		return NewESGenerator(ES256, nil)
 	}
 	es384GenPool.New = func() interface{} {
-		return NewESGenerator(HS384, nil)
+		return NewESGenerator(ES384, nil)
 	}
 	es512GenPool.New = func() interface{} {
-		return NewESGenerator(HS512, nil)
+		return NewESGenerator(ES512, nil)
 	}
 }
 
@@ -147,7 +126,7 @@ func (g *ESGenerator) Generate(header, payload map[string]interface{}) (string,
 	// '.' between payload and signature.
 	g.enc.token = append(g.enc.token, '.')
 	// Base64 hash signature.
-	g.enc.Base64(g.bi.Encode(r, s))
+	g.enc.Base64(g.bi.Encode(r, s, curveBytes(g.key.Curve)))
 	return string(g.enc.token), nil
 }
 
@@ -174,10 +153,13 @@ func (v *ESVerifier) Verify(data string, signature []byte) error {
 	v.hash.Reset()
 	v.hash.Write(b)
 	v.hash.Sum(v.sign[:0])
+	if len(signature) != 2*curveBytes(v.key.Curve) {
+		return ErrSignature
+	}
 	v.bi.Decode(signature)
 	// ECDSA verify.
 	if !ecdsa.Verify(v.key, v.sign, &v.bi.r, &v.bi.s) {
-		return errInvalidJWT
+		return ErrSignature
 	}
 	return nil
 }
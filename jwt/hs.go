@@ -81,11 +81,11 @@ func (v *HsVerifier) Verify(data string, signature []byte) error {
 	v.hash.Sum(v.sign[:0])
 	// Compare data with signature.
 	if len(v.sign) != len(signature) {
-		return errInvalidJWT
+		return ErrSignature
 	}
 	for i := 0; i < len(v.sign); i++ {
 		if v.sign[i] != signature[i] {
-			return errInvalidJWT
+			return ErrSignature
 		}
 	}
 	return nil
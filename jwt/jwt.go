@@ -7,17 +7,27 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"reflect"
 	"strings"
 	"sync"
 	"unsafe"
 )
 
 var (
-	errInvalidJWT  = errors.New("invalid jwt")
-	errAlgType     = errors.New("alg must be string type")
-	errAlgNotfound = errors.New("algorithm not found")
-	decoderPool    = sync.Pool{}
+	// Token is not "header.payload.signature".
+	ErrMalformed = errors.New("malformed jwt")
+	// Segment is not valid base64url.
+	ErrBase64 = errors.New("invalid base64")
+	// Segment is not valid JSON object.
+	ErrJSON = errors.New("invalid json")
+	// Header has no "alg".
+	ErrAlgNotFound = errors.New("algorithm not found")
+	// Header "alg" is not a string.
+	ErrAlgType = errors.New("alg must be string type")
+	// Verifier does not support header "alg".
+	ErrUnsupportedAlg = errors.New("unsupported algorithm")
+	// Signature does not match.
+	ErrSignature = errors.New("invalid signature")
+	decoderPool  = sync.Pool{}
 )
 
 func init() {
@@ -44,6 +54,7 @@ const (
 // Algorithm.
 type Alg string
 
+// Return hash function of alg, 0 if alg does not pre-hash(EdDSA) or unknown.
 func (alg Alg) CryptoHash() crypto.Hash {
 	switch alg {
 	case HS256:
@@ -89,17 +100,66 @@ const (
 	RS256 Alg = "RS256"
 	RS384 Alg = "RS384"
 	RS512 Alg = "RS512"
+	EdDSA Alg = "EdDSA"
 )
 
 // Zero copy reference
 func string2bytes(s string) []byte {
-	sh := (*reflect.StringHeader)(unsafe.Pointer(&s))
-	bh := reflect.SliceHeader{
-		Data: sh.Data,
-		Len:  sh.Len,
-		Cap:  sh.Len,
+	return *(*[]byte)(unsafe.Pointer(&struct {
+		string
+		int
+	}{s, len(s)}))
+}
+
+// Segment of JWT.
+type Segment int
+
+// All segments.
+const (
+	SegmentToken Segment = iota
+	SegmentHeader
+	SegmentPayload
+	SegmentSignature
+)
+
+func (s Segment) String() string {
+	switch s {
+	case SegmentHeader:
+		return "header"
+	case SegmentPayload:
+		return "payload"
+	case SegmentSignature:
+		return "signature"
+	default:
+		return "token"
 	}
-	return *(*[]byte)(unsafe.Pointer(&bh))
+}
+
+// Error is returned by Verify and Inspect, it tells which segment failed and why.
+// Reason is one of the Err* variables, Err is the underlying error(may be nil).
+// Use errors.Is(err, ErrSignature) to test the reason,
+// or errors.As(err, &e) to get the *Error or the underlying error.
+type Error struct {
+	Segment Segment
+	Reason  error
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return "jwt " + e.Segment.String() + ": " + e.Reason.Error()
+	}
+	return "jwt " + e.Segment.String() + ": " + e.Reason.Error() + ": " + e.Err.Error()
+}
+
+// Is reports whether target is e.Reason.
+func (e *Error) Is(target error) bool {
+	return e.Reason == target
+}
+
+// Unwrap return the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
 }
 
 type Generator interface {
@@ -193,12 +253,12 @@ func (d *decoder) Base64(s string) error {
 	return err
 }
 
-// Base64 decode then json decode
-func (d *decoder) Dec(s string) (map[string]interface{}, error) {
+// Base64 decode then json decode, return *Error if fail.
+func (d *decoder) Dec(s string, seg Segment) (map[string]interface{}, error) {
 	// Base64 decode
 	err := d.Base64(s)
 	if err != nil {
-		return nil, err
+		return nil, &Error{Segment: seg, Reason: ErrBase64, Err: err}
 	}
 	// Json decode
 	data := make(map[string]interface{})
@@ -206,14 +266,31 @@ func (d *decoder) Dec(s string) (map[string]interface{}, error) {
 	d.Buffer.Write(d.buff)
 	err = d.Decoder.Decode(&data)
 	if err != nil {
-		return nil, err
+		return nil, &Error{Segment: seg, Reason: ErrJSON, Err: err}
+	}
+	if data == nil {
+		return nil, &Error{Segment: seg, Reason: ErrJSON, Err: errors.New("not an object")}
 	}
 	return data, nil
 }
 
+// Return header alg, or *Error if fail.
+func headerAlg(header map[string]interface{}) (Alg, error) {
+	val, ok := header[ALG]
+	if !ok {
+		return "", &Error{Segment: SegmentHeader, Reason: ErrAlgNotFound}
+	}
+	alg, ok := val.(string)
+	if !ok {
+		return "", &Error{Segment: SegmentHeader, Reason: ErrAlgType, Err: fmt.Errorf("got %T", val)}
+	}
+	return Alg(alg), nil
+}
+
 // Verify token signature, return header and payload.
 // Function verifier return a Verifier to verify token(You may have a Verifier pool),
 // return nil means does not support token's algorihm, and function Verify will return error.
+// All errors are *Error.
 func Verify(token string, verifier func(Alg) Verifier) (map[string]interface{}, map[string]interface{}, error) {
 	i1, i2, err := SplitJWT(token)
 	if err != nil {
@@ -221,42 +298,40 @@ func Verify(token string, verifier func(Alg) Verifier) (map[string]interface{},
 	}
 	decoder := decoderPool.Get().(*decoder)
 	// Decode header
-	header, err := decoder.Dec(token[:i1])
+	header, err := decoder.Dec(token[:i1], SegmentHeader)
 	if err != nil {
 		decoderPool.Put(decoder)
 		return nil, nil, err
 	}
 	// Alg
-	val, ok := header[ALG]
-	if !ok {
-		decoderPool.Put(decoder)
-		return nil, nil, errAlgNotfound
-	}
-	alg, ok := val.(string)
-	if !ok {
+	alg, err := headerAlg(header)
+	if err != nil {
 		decoderPool.Put(decoder)
-		return nil, nil, errAlgType
+		return nil, nil, err
 	}
 	// Verifiier to verify.
-	ver := verifier(Alg(alg))
+	ver := verifier(alg)
 	if ver == nil {
 		decoderPool.Put(decoder)
-		return nil, nil, fmt.Errorf("unsupported algorithm %s", alg)
+		return nil, nil, &Error{Segment: SegmentHeader, Reason: ErrUnsupportedAlg, Err: fmt.Errorf("alg %q", alg)}
 	}
 	// Base64 decode signature.
 	err = decoder.Base64(token[i2+1:])
 	if err != nil {
 		decoderPool.Put(decoder)
-		return nil, nil, err
+		return nil, nil, &Error{Segment: SegmentSignature, Reason: ErrBase64, Err: err}
 	}
 	// Verify.
 	err = ver.Verify(token[:i2], decoder.buff)
 	if err != nil {
 		decoderPool.Put(decoder)
-		return nil, nil, err
+		if err == ErrSignature {
+			err = nil
+		}
+		return nil, nil, &Error{Segment: SegmentSignature, Reason: ErrSignature, Err: err}
 	}
 	// Decode payload
-	payload, err := decoder.Dec(token[i1+1 : i2])
+	payload, err := decoder.Dec(token[i1+1:i2], SegmentPayload)
 	if err != nil {
 		decoderPool.Put(decoder)
 		return nil, nil, err
@@ -265,17 +340,65 @@ func Verify(token string, verifier func(Alg) Verifier) (map[string]interface{},
 	return header, payload, nil
 }
 
+// Token is a decoded JWT returned by Inspect.
+type Token struct {
+	Header    map[string]interface{}
+	Payload   map[string]interface{}
+	Signature []byte
+	// Header "alg", empty if not found or not a string.
+	Alg Alg
+}
+
+// Inspect decode token's header, payload and signature WITHOUT verifying.
+//
+// UNSAFE: anyone can forge the returned data, never use it for authentication.
+// It is for debugging, logging, or choosing a key before calling Verify.
+// All errors are *Error.
+func Inspect(token string) (*Token, error) {
+	i1, i2, err := SplitJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	decoder := decoderPool.Get().(*decoder)
+	defer decoderPool.Put(decoder)
+	t := new(Token)
+	// Decode header
+	t.Header, err = decoder.Dec(token[:i1], SegmentHeader)
+	if err != nil {
+		return nil, err
+	}
+	t.Alg, _ = headerAlg(t.Header)
+	// Decode payload
+	t.Payload, err = decoder.Dec(token[i1+1:i2], SegmentPayload)
+	if err != nil {
+		return nil, err
+	}
+	// Base64 decode signature.
+	err = decoder.Base64(token[i2+1:])
+	if err != nil {
+		return nil, &Error{Segment: SegmentSignature, Reason: ErrBase64, Err: err}
+	}
+	t.Signature = append(t.Signature, decoder.buff...)
+	return t, nil
+}
+
 // Split JWT, return index of '.'.
 func SplitJWT(token string) (int, int, error) {
 	// '.' follow header.
 	i1 := strings.IndexByte(token, '.')
 	if i1 < 0 {
-		return 0, 0, errInvalidJWT
+		return 0, 0, &Error{Segment: SegmentToken, Reason: ErrMalformed, Err: errors.New("missing '.' after header")}
 	}
 	// '.' follow payload.
 	i2 := strings.IndexByte(token[i1+1:], '.') + 1
-	if i2 == 0 || i2 == len(token) {
-		return 0, 0, errInvalidJWT
+	if i2 == 0 {
+		return 0, 0, &Error{Segment: SegmentToken, Reason: ErrMalformed, Err: errors.New("missing '.' after payload")}
+	}
+	if i1+i2 == len(token)-1 {
+		return 0, 0, &Error{Segment: SegmentToken, Reason: ErrMalformed, Err: errors.New("empty signature")}
+	}
+	if strings.IndexByte(token[i1+i2+1:], '.') >= 0 {
+		return 0, 0, &Error{Segment: SegmentToken, Reason: ErrMalformed, Err: errors.New("too many '.'")}
 	}
 	return i1, i1 + i2, nil
 }
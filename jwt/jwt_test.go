@@ -1,6 +1,10 @@
 package jwt
 
-import "testing"
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
 
 func test_Generate(gen Generator) (string, error) {
 	header := make(map[string]interface{})
@@ -52,3 +56,62 @@ func benchmark_Verify(b *testing.B, t string, v Verifier) {
 		})
 	}
 }
+
+func Test_Inspect(t *testing.T) {
+	token, err := test_Generate(NewHSGenerator(HS256, []byte("inspect")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk, err := Inspect(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Alg != HS256 || tk.Payload["2"] != "2" || len(tk.Signature) != 32 {
+		t.FailNow()
+	}
+}
+
+func Test_Verify_Error(t *testing.T) {
+	key := []byte("error")
+	token, err := test_Generate(NewHSGenerator(HS256, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ver := func(a Alg) Verifier { return NewHSVerifier(HS256, key) }
+	i1, i2, err := SplitJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		token   string
+		segment Segment
+		reason  error
+	}{
+		{"abc", SegmentToken, ErrMalformed},
+		{"a.b", SegmentToken, ErrMalformed},
+		{"a.b.", SegmentToken, ErrMalformed},
+		{"!" + token[1:], SegmentHeader, ErrBase64},
+		{"bnVsbA" + token[i1:], SegmentHeader, ErrJSON},
+		{"e30" + token[i1:], SegmentHeader, ErrAlgNotFound},
+		{"eyJhbGciOjF9" + token[i1:], SegmentHeader, ErrAlgType},
+		{token[:i2+1] + "!", SegmentSignature, ErrBase64},
+		{token[:i2+1] + "AAAA", SegmentSignature, ErrSignature},
+	} {
+		_, _, err = Verify(c.token, ver)
+		var e *Error
+		if !errors.As(err, &e) || e.Segment != c.segment || !errors.Is(err, c.reason) {
+			t.Fatal(c.token, err)
+		}
+	}
+	// Underlying error.
+	_, _, err = Verify("!"+token[1:], ver)
+	var ce base64.CorruptInputError
+	if !errors.As(err, &ce) {
+		t.Fatal(err)
+	}
+	// Unsupported.
+	_, _, err = Verify(token, func(a Alg) Verifier { return nil })
+	if !errors.Is(err, ErrUnsupportedAlg) {
+		t.Fatal(err)
+	}
+}
@@ -16,13 +16,13 @@ var (
 
 func init() {
 	ps256GenPool.New = func() interface{} {
-		return NewPSGenerator(HS256, nil, nil)
+		return NewPSGenerator(PS256, nil, nil)
 	}
 	ps384GenPool.New = func() interface{} {
-		return NewPSGenerator(HS384, nil, nil)
+		return NewPSGenerator(PS384, nil, nil)
 	}
 	ps512GenPool.New = func() interface{} {
-		return NewPSGenerator(HS512, nil, nil)
+		return NewPSGenerator(PS512, nil, nil)
 	}
 }
 
@@ -16,13 +16,13 @@ var (
 
 func init() {
 	rs256GenPool.New = func() interface{} {
-		return NewRSGenerator(HS256, nil)
+		return NewRSGenerator(RS256, nil)
 	}
 	rs384GenPool.New = func() interface{} {
-		return NewRSGenerator(HS384, nil)
+		return NewRSGenerator(RS384, nil)
 	}
 	rs512GenPool.New = func() interface{} {
-		return NewRSGenerator(HS512, nil)
+		return NewRSGenerator(RS512, nil)
 	}
 }
 
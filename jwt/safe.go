@@ -0,0 +1,99 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"sync"
+)
+
+// Generators and Verifiers in this package hold a mutable hash, signature buffer and encoder,
+// they are not safe for concurrent use.
+// The "Safe" ones below can be shared between goroutines,
+// they pool Generators or Verifiers of one key, so there is no allocation after warm-up.
+
+// Concurrency-safe Generator.
+type poolGenerator struct {
+	pool sync.Pool
+}
+
+func (g *poolGenerator) Generate(header, payload map[string]interface{}) (string, error) {
+	gen := g.pool.Get().(Generator)
+	token, err := gen.Generate(header, payload)
+	g.pool.Put(gen)
+	return token, err
+}
+
+// Concurrency-safe Verifier.
+type poolVerifier struct {
+	pool sync.Pool
+}
+
+func (v *poolVerifier) Verify(data string, signature []byte) error {
+	ver := v.pool.Get().(Verifier)
+	err := ver.Verify(data, signature)
+	v.pool.Put(ver)
+	return err
+}
+
+func newPoolGenerator(fn func() Generator) Generator {
+	g := new(poolGenerator)
+	g.pool.New = func() interface{} { return fn() }
+	return g
+}
+
+func newPoolVerifier(fn func() Verifier) Verifier {
+	v := new(poolVerifier)
+	v.pool.New = func() interface{} { return fn() }
+	return v
+}
+
+// Return a concurrency-safe HS Generator.
+func NewSafeHSGenerator(alg Alg, key []byte) Generator {
+	return newPoolGenerator(func() Generator { return NewHSGenerator(alg, key) })
+}
+
+// Return a concurrency-safe HS Verifier.
+func NewSafeHSVerifier(alg Alg, key []byte) Verifier {
+	return newPoolVerifier(func() Verifier { return NewHSVerifier(alg, key) })
+}
+
+// Return a concurrency-safe RS Generator.
+func NewSafeRSGenerator(alg Alg, key *rsa.PrivateKey) Generator {
+	return newPoolGenerator(func() Generator { return NewRSGenerator(alg, key) })
+}
+
+// Return a concurrency-safe RS Verifier.
+func NewSafeRSVerifier(alg Alg, key *rsa.PublicKey) Verifier {
+	return newPoolVerifier(func() Verifier { return NewRSVerifier(alg, key) })
+}
+
+// Return a concurrency-safe PS Generator.
+func NewSafePSGenerator(alg Alg, key *rsa.PrivateKey, opt *rsa.PSSOptions) Generator {
+	return newPoolGenerator(func() Generator { return NewPSGenerator(alg, key, opt) })
+}
+
+// Return a concurrency-safe PS Verifier.
+func NewSafePSVerifier(alg Alg, key *rsa.PublicKey, opt *rsa.PSSOptions) Verifier {
+	return newPoolVerifier(func() Verifier { return NewPSVerifier(alg, key, opt) })
+}
+
+// Return a concurrency-safe ES Generator.
+func NewSafeESGenerator(alg Alg, key *ecdsa.PrivateKey) Generator {
+	return newPoolGenerator(func() Generator { return NewESGenerator(alg, key) })
+}
+
+// Return a concurrency-safe ES Verifier.
+func NewSafeESVerifier(alg Alg, key *ecdsa.PublicKey) Verifier {
+	return newPoolVerifier(func() Verifier { return NewESVerifier(alg, key) })
+}
+
+// Return a concurrency-safe EdDSA Generator.
+func NewSafeEdGenerator(key ed25519.PrivateKey) Generator {
+	return newPoolGenerator(func() Generator { return NewEdGenerator(key) })
+}
+
+// Return a concurrency-safe EdDSA Verifier.
+func NewSafeEdVerifier(key ed25519.PublicKey) Verifier {
+	return newPoolVerifier(func() Verifier { return NewEdVerifier(key) })
+}
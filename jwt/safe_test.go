@@ -0,0 +1,89 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+	"testing"
+)
+
+// Run with -race.
+func test_Safe(t *testing.T, g Generator, v Verifier) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				token, err := test_Generate(g)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, _, err = Verify(token, func(a Alg) Verifier { return v })
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func Test_Safe(t *testing.T) {
+	hsKey := []byte("safe")
+	test_Safe(t, NewSafeHSGenerator(HS256, hsKey), NewSafeHSVerifier(HS256, hsKey))
+	rsKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test_Safe(t, NewSafeRSGenerator(RS256, rsKey), NewSafeRSVerifier(RS256, &rsKey.PublicKey))
+	test_Safe(t, NewSafePSGenerator(PS256, rsKey, nil), NewSafePSVerifier(PS256, &rsKey.PublicKey, nil))
+	esKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test_Safe(t, NewSafeESGenerator(ES256, esKey), NewSafeESVerifier(ES256, &esKey.PublicKey))
+}
+
+func Test_GeneratePool_Alg(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := GenerateES384(make(map[string]interface{}), make(map[string]interface{}), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk, err := Inspect(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Alg != ES384 || len(tk.Signature) != 96 {
+		t.FailNow()
+	}
+}
+
+func Benchmark_Safe_HS256_Verify(b *testing.B) {
+	key := []byte("HS256")
+	token, err := test_Generate(NewHSGenerator(HS256, key))
+	if err != nil {
+		b.Fatal(err)
+	}
+	v := NewSafeHSVerifier(HS256, key)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			Verify(token, func(a Alg) Verifier { return v })
+		}
+	})
+}
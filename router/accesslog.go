@@ -0,0 +1,332 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogEntry 是一条访问日志
+type AccessLogEntry struct {
+	// 请求开始的时间
+	Time time.Time `json:"time"`
+	// 请求的方法
+	Method string `json:"method"`
+	// 请求的 URI ，包括查询参数
+	Path string `json:"path"`
+	// 请求的协议
+	Proto string `json:"proto"`
+	// 匹配的路由，比如 "/users/?" ，空表示没有匹配
+	Route string `json:"route,omitempty"`
+	// 响应的状态码
+	Status int `json:"status"`
+	// 响应 body 的字节数
+	Bytes int64 `json:"bytes"`
+	// 处理的耗时
+	Latency time.Duration `json:"latency"`
+	// 客户端的 IP
+	ClientIP string `json:"client_ip"`
+	// User-Agent 头
+	UserAgent string `json:"user_agent,omitempty"`
+	// Referer 头
+	Referer string `json:"referer,omitempty"`
+	// 请求 ID
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// AccessLogFormat 格式化 e ，添加到 b 后面并返回，不包括换行
+type AccessLogFormat func(b []byte, e *AccessLogEntry) []byte
+
+// clfTime 是 Common Log Format 的时间格式
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// clfString 返回 Common Log Format 的字段，空表示 "-"
+func clfString(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// CommonLogFormat 是 Common Log Format ，比如
+// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326
+func CommonLogFormat(b []byte, e *AccessLogEntry) []byte {
+	b = append(b, clfString(e.ClientIP)...)
+	b = append(b, " - - ["...)
+	b = e.Time.AppendFormat(b, clfTime)
+	b = append(b, "] \""...)
+	b = append(b, e.Method...)
+	b = append(b, ' ')
+	b = append(b, e.Path...)
+	b = append(b, ' ')
+	b = append(b, e.Proto...)
+	b = append(b, "\" "...)
+	b = strconv.AppendInt(b, int64(e.Status), 10)
+	b = append(b, ' ')
+	if e.Bytes == 0 {
+		return append(b, '-')
+	}
+	return strconv.AppendInt(b, e.Bytes, 10)
+}
+
+// CombinedLogFormat 是 Combined Log Format ，在 CommonLogFormat 后面添加 Referer 和 User-Agent
+func CombinedLogFormat(b []byte, e *AccessLogEntry) []byte {
+	b = CommonLogFormat(b, e)
+	b = append(b, ' ')
+	b = strconv.AppendQuote(b, clfString(e.Referer))
+	b = append(b, ' ')
+	return strconv.AppendQuote(b, clfString(e.UserAgent))
+}
+
+// JSONLogFormat 是 JSON Lines 格式，Latency 是纳秒
+func JSONLogFormat(b []byte, e *AccessLogEntry) []byte {
+	data, _ := json.Marshal(e)
+	return append(b, data...)
+}
+
+// AccessLogSink 保存格式化的访问日志，它会被并发调用
+type AccessLogSink interface {
+	// line 是一行日志，以换行结尾，调用返回后不能再使用
+	WriteLog(line []byte) error
+}
+
+// writerSink 把日志写到 io.Writer
+type writerSink struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewWriterSink 返回一个把日志写到 w 的 AccessLogSink
+func NewWriterSink(w io.Writer) AccessLogSink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) WriteLog(line []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.w.Write(line)
+	return err
+}
+
+// RotatingFile 是一个按照大小切割的日志文件，实现了 AccessLogSink 和 io.Writer 。
+// 文件超过 MaxSize 时，重命名为 name.1 ，原来的 name.1 重命名为 name.2 ，以此类推。
+type RotatingFile struct {
+	lock sync.Mutex
+	name string
+	// 文件的最大字节数
+	maxSize int64
+	// 保留的旧文件数
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFile 打开或者创建日志文件 name ，maxSize <=0 表示 100MB ，maxBackups <=0 表示不保留旧文件
+func NewRotatingFile(name string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{name: name, maxSize: maxSize, maxBackups: maxBackups}
+	if f.maxSize <= 0 {
+		f.maxSize = 100 << 20
+	}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open 打开日志文件
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, fi.Size()
+	return nil
+}
+
+// rotate 切割日志文件
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+	if f.maxBackups > 0 {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.name, i), fmt.Sprintf("%s.%d", f.name, i+1))
+		}
+		err = os.Rename(f.name, f.name+".1")
+	} else {
+		err = os.Remove(f.name)
+	}
+	if err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Write 写数据，文件超过最大字节数时先切割
+func (f *RotatingFile) Write(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// WriteLog 实现 AccessLogSink
+func (f *RotatingFile) WriteLog(line []byte) error {
+	_, err := f.Write(line)
+	return err
+}
+
+// Close 关闭文件
+func (f *RotatingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// AccessLogOptions 是 AccessLog 的选项
+type AccessLogOptions struct {
+	// 日志格式，nil 表示 CombinedLogFormat
+	Format AccessLogFormat
+	// 保存日志，nil 表示写到 os.Stdout
+	Sink AccessLogSink
+	// 记录的比例，范围是 (0,1) ，其他值表示全部记录，状态码 >=500 的请求总是记录
+	SampleRate float64
+	// 不记录的请求路径前缀，按照路径元素匹配，比如 "/static" 匹配 "/static" 和 "/static/a.js" ，
+	// 不匹配 "/statistics"
+	Exclude []string
+	// 客户端 IP 的头，比如 "X-Forwarded-For" 和 "X-Real-IP" ，取第一个 IP ，空表示使用 RemoteAddr 。
+	// 只有在可信的反向代理后面才应该设置。
+	ClientIPHeader string
+	// 请求 ID 的头，空表示 "X-Request-Id" 。
+	// 请求没有时生成一个，设置到请求和响应的头中。
+	RequestIDHeader string
+}
+
+// AccessLog 返回一个记录访问日志的中间件，用于 Intercept 。
+// 它使用 Context.Writer 记录状态码和字节数，日志在调用链结束后写入。
+func AccessLog(opt *AccessLogOptions) HandleFunc {
+	var o AccessLogOptions
+	if opt != nil {
+		o = *opt
+	}
+	if o.Format == nil {
+		o.Format = CombinedLogFormat
+	}
+	if o.Sink == nil {
+		o.Sink = NewWriterSink(os.Stdout)
+	}
+	if o.RequestIDHeader == "" {
+		o.RequestIDHeader = "X-Request-Id"
+	}
+	var lock sync.Mutex
+	random := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	sampled := func() bool {
+		if o.SampleRate <= 0 || o.SampleRate >= 1 || math.IsNaN(o.SampleRate) {
+			return true
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		return random.Float64() < o.SampleRate
+	}
+	bufPool := sync.Pool{New: func() interface{} { return make([]byte, 0, 256) }}
+	return func(ctx *Context) {
+		req := ctx.Request
+		p := req.URL.Path
+		for _, prefix := range o.Exclude {
+			prefix = strings.TrimSuffix(prefix, "/")
+			if p == prefix || strings.HasPrefix(p, prefix+"/") {
+				ctx.Handle()
+				return
+			}
+		}
+		e := &AccessLogEntry{
+			Time:      time.Now(),
+			Method:    req.Method,
+			Path:      req.URL.RequestURI(),
+			Proto:     req.Proto,
+			Route:     ctx.Route(),
+			ClientIP:  clientIP(req, o.ClientIPHeader),
+			UserAgent: req.UserAgent(),
+			Referer:   req.Referer(),
+			RequestID: req.Header.Get(o.RequestIDHeader),
+		}
+		if e.RequestID == "" {
+			e.RequestID = newRequestID()
+			req.Header.Set(o.RequestIDHeader, e.RequestID)
+		}
+		res := ctx.Writer()
+		res.Header().Set(o.RequestIDHeader, e.RequestID)
+		ctx.Handle()
+		e.Latency = time.Since(e.Time)
+		e.Status = res.Status()
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		e.Bytes = res.Size()
+		if e.Status < http.StatusInternalServerError && !sampled() {
+			return
+		}
+		b := bufPool.Get().([]byte)
+		b = append(o.Format(b[:0], e), '\n')
+		o.Sink.WriteLog(b)
+		bufPool.Put(b)
+	}
+}
+
+// clientIP 返回客户端的 IP
+func clientIP(req *http.Request, header string) string {
+	if header != "" {
+		s := req.Header.Get(header)
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			s = s[:i]
+		}
+		if s = strings.TrimSpace(s); s != "" {
+			return s
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// newRequestID 返回一个随机的请求 ID
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,187 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Send lines to the channel.
+type testLogSink chan string
+
+func (s testLogSink) WriteLog(line []byte) error {
+	s <- string(line)
+	return nil
+}
+
+func Test_AccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRootRouter()
+	r.Intercept(AccessLog(&AccessLogOptions{
+		Format:         JSONLogFormat,
+		Sink:           NewWriterSink(&buf),
+		Exclude:        []string{"/static"},
+		ClientIPHeader: "X-Forwarded-For",
+	}))
+	// Intercepted after AccessLog.
+	r.NotFound(func(ctx *Context) {
+		ctx.WriteHeader(http.StatusNotFound)
+	})
+	r.GET("/users/?", func(ctx *Context) {
+		ctx.WriteHeader(http.StatusCreated)
+		io.WriteString(ctx.ResponseWriter, "hello")
+	})
+	r.GET("/static/*", func(ctx *Context) {})
+	r.GET("/statistics", func(ctx *Context) {})
+	res := testServe(r, http.MethodGet, "/users/1?a=b", http.Header{
+		"User-Agent":      []string{"test"},
+		"X-Forwarded-For": []string{"1.1.1.1, 2.2.2.2"},
+	})
+	id := res.Header().Get("X-Request-Id")
+	if res.Code != http.StatusCreated || len(id) != 32 {
+		t.Fatal(res.Code, res.Header())
+	}
+	testServe(r, http.MethodGet, "/static/a.js", nil)
+	testServe(r, http.MethodGet, "/statistics", nil)
+	res = testServe(r, http.MethodGet, "/none", http.Header{"X-Request-Id": []string{"id"}})
+	if res.Header().Get("X-Request-Id") != "id" {
+		t.Fatal(res.Header())
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatal(lines)
+	}
+	var e AccessLogEntry
+	err := json.Unmarshal([]byte(lines[0]), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Method != "GET" || e.Path != "/users/1?a=b" || e.Route != "/users/?" || e.Status != 201 || e.Bytes != 5 ||
+		e.ClientIP != "1.1.1.1" || e.UserAgent != "test" || e.RequestID != id || e.Latency <= 0 {
+		t.Fatal(lines[0])
+	}
+	e = AccessLogEntry{}
+	err = json.Unmarshal([]byte(lines[1]), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != "/statistics" {
+		t.Fatal(lines[1])
+	}
+	e = AccessLogEntry{}
+	err = json.Unmarshal([]byte(lines[2]), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != "/none" || e.Route != "" || e.Status != 404 || e.RequestID != "id" || e.ClientIP != "192.0.2.1" {
+		t.Fatal(lines[2])
+	}
+}
+
+func Test_AccessLog_Sample(t *testing.T) {
+	sink := make(testLogSink, 10)
+	r := NewRootRouter()
+	r.Intercept(AccessLog(&AccessLogOptions{Sink: sink, SampleRate: 1e-9}))
+	r.GET("/ok", func(ctx *Context) {})
+	r.GET("/error", func(ctx *Context) {
+		ctx.WriteHeader(http.StatusInternalServerError)
+	})
+	for i := 0; i < 5; i++ {
+		testServe(r, http.MethodGet, "/ok", nil)
+		testServe(r, http.MethodGet, "/error", nil)
+	}
+	if len(sink) != 5 {
+		t.Fatal(len(sink))
+	}
+	if s := <-sink; !strings.Contains(s, `"GET /error HTTP/1.1" 500 - "-" "-"`) {
+		t.Fatal(s)
+	}
+}
+
+func Test_AccessLog_WS(t *testing.T) {
+	sink := make(testLogSink, 1)
+	r := NewRootRouter()
+	r.Intercept(AccessLog(&AccessLogOptions{Sink: sink, Format: CommonLogFormat}))
+	r.WS("/ws", new(testWSHandler), nil)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	conn, status := testWSDial(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if status != http.StatusSwitchingProtocols {
+		t.Fatal(status)
+	}
+	conn.Close()
+	select {
+	case s := <-sink:
+		if !strings.Contains(s, `"GET /ws HTTP/1.1" 101 -`) {
+			t.Fatal(s)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("no log")
+	}
+}
+
+func Test_CombinedLogFormat(t *testing.T) {
+	e := &AccessLogEntry{
+		Time:      time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		Method:    "GET",
+		Path:      "/apache_pb.gif",
+		Proto:     "HTTP/1.0",
+		Status:    200,
+		Bytes:     2326,
+		ClientIP:  "127.0.0.1",
+		Referer:   "http://www.example.com/start.html",
+		UserAgent: `Mozilla/4.08 "x"`,
+	}
+	s := string(CombinedLogFormat(nil, e))
+	if s != `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 \"x\""` {
+		t.Fatal(s)
+	}
+}
+
+func Test_RotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "access.log")
+	f, err := NewRotatingFile(name, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"1111\n", "2222\n", "3333\n", "4444\n", "5555\n", "6666\n", "7777\n"} {
+		err = f.WriteLog([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range map[string]string{
+		name:        "7777\n",
+		name + ".1": "5555\n6666\n",
+		name + ".2": "3333\n4444\n",
+		name + ".3": "",
+	} {
+		b, err := ioutil.ReadFile(file)
+		if data == "" {
+			if !os.IsNotExist(err) {
+				t.Fatal(file, err)
+			}
+			continue
+		}
+		if string(b) != data {
+			t.Fatal(file, string(b), err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package router
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/qq51529210/web/util"
+)
+
+// Assets 是带指纹的静态资源，它在创建时计算 fs.FS 中所有文件的哈希值，
+// 比如 "js/app.js" 的指纹文件名是 "js/app.3f9a1c2b.js" ，数据相同。
+// 它实现了 fs.FS ，可以同时打开原文件名和指纹文件名，预压缩文件也有对应的指纹文件名，
+// 比如 "js/app.3f9a1c2b.js.gz" 。
+// 隐藏文件和预压缩文件不计算指纹，创建后修改的文件，指纹不会改变。
+type Assets struct {
+	fs fs.FS
+	// URL 的前缀
+	prefix string
+	// 指纹文件名 -> 文件名
+	files map[string]string
+	// 文件名 -> 指纹文件名
+	names map[string]string
+}
+
+// NewAssets 计算 fsys 中所有文件的指纹，prefix 是 URL 的前缀，比如 "/assets"
+func NewAssets(fsys fs.FS, prefix string) (*Assets, error) {
+	var names []string
+	exists := make(map[string]bool)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && d.Name()[0] == '.' {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			names = append(names, name)
+			exists[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	a := &Assets{
+		fs:     fsys,
+		prefix: prefix,
+		files:  make(map[string]string),
+		names:  make(map[string]string),
+	}
+	for _, name := range names {
+		if a.precompressed(name, func(name string) bool { return exists[name] }) != "" {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		ext := path.Ext(name)
+		hashName := strings.TrimSuffix(name, ext) + "." + util.SHA256(data)[:8] + ext
+		a.files[hashName] = name
+		a.names[name] = hashName
+	}
+	return a, nil
+}
+
+// precompressed 如果 name 是预压缩文件，返回原文件名，exists 判断原文件是否存在
+func (a *Assets) precompressed(name string, exists func(string) bool) string {
+	for _, e := range registeredEncodings() {
+		if e.ext == "" || !strings.HasSuffix(name, e.ext) {
+			continue
+		}
+		s := strings.TrimSuffix(name, e.ext)
+		if exists(s) {
+			return s
+		}
+	}
+	return ""
+}
+
+// Open 实现 fs.FS ，打开 name 对应的文件
+func (a *Assets) Open(name string) (fs.File, error) {
+	if s, ok := a.files[name]; ok {
+		return a.fs.Open(s)
+	}
+	// 指纹文件名的预压缩文件
+	if s := a.precompressed(name, func(name string) bool { return a.files[name] != "" }); s != "" {
+		return a.fs.Open(a.files[s] + name[len(s):])
+	}
+	return a.fs.Open(name)
+}
+
+// IsFingerprinted 判断 name 是否为指纹文件名，或者它的预压缩文件
+func (a *Assets) IsFingerprinted(name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	if _, ok := a.files[name]; ok {
+		return true
+	}
+	return a.precompressed(name, func(name string) bool { return a.files[name] != "" }) != ""
+}
+
+// URL 返回 name 的指纹文件的 URL ，比如 "js/app.js" 返回 "/assets/js/app.3f9a1c2b.js" ，
+// 没有指纹的文件返回原文件的 URL
+func (a *Assets) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if s, ok := a.names[name]; ok {
+		name = s
+	}
+	return path.Join(a.prefix, name)
+}
+
+// Manifest 返回文件名到指纹文件的 URL 的映射
+func (a *Assets) Manifest() map[string]string {
+	m := make(map[string]string, len(a.names))
+	for name := range a.names {
+		m[name] = a.URL(name)
+	}
+	return m
+}
+
+// WriteManifest 把 Manifest 以 JSON 格式写到 w
+func (a *Assets) WriteManifest(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a.Manifest())
+}
+
+// FuncMap 返回模板函数，"asset" 是 URL ，比如 {{asset "js/app.js"}}
+func (a *Assets) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"asset": a.URL,
+	}
+}
@@ -0,0 +1,88 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/qq51529210/web/util"
+)
+
+func Test_Router_Assets(t *testing.T) {
+	fsys := fstest.MapFS{
+		"js/app.js":    {Data: []byte("app")},
+		"js/app.js.gz": {Data: []byte("gz")},
+		"site.css":     {Data: []byte("css")},
+		"LICENSE":      {Data: []byte("license")},
+		".env":         {Data: []byte("env")},
+	}
+	r := NewRootRouter()
+	a := r.Assets("/assets", fsys, &StaticOptions{CacheControl: "max-age=60", Precompressed: true})
+	hash := func(s string) string { return util.SHA256([]byte(s))[:8] }
+	appURL := "/assets/js/app." + hash("app") + ".js"
+	for name, url := range map[string]string{
+		"js/app.js":  appURL,
+		"/site.css":  "/assets/site." + hash("css") + ".css",
+		"LICENSE":    "/assets/LICENSE." + hash("license"),
+		"none.js":    "/assets/none.js",
+		".env":       "/assets/.env",
+		"js/app.gz":  "/assets/js/app.gz",
+		"js/app.js.": "/assets/js/app.js.",
+	} {
+		if a.URL(name) != url {
+			t.Fatal(name, a.URL(name))
+		}
+	}
+	for _, c := range []struct {
+		url, accept, body, cache string
+		status                   int
+	}{
+		{appURL, "", "app", ImmutableCacheControl, 200},
+		{appURL, "gzip", "gz", ImmutableCacheControl, 200},
+		{"/assets/js/app.js", "", "app", "max-age=60", 200},
+		{"/assets/js/app.00000000.js", "", "", "", 404},
+		{"/assets/.env", "", "", "", 404},
+	} {
+		res := testServe(r, http.MethodGet, c.url, http.Header{"Accept-Encoding": []string{c.accept}})
+		if res.Code != c.status {
+			t.Fatal(c.url, res.Code)
+		}
+		if c.status != 200 {
+			continue
+		}
+		if res.Body.String() != c.body || res.Header().Get("Cache-Control") != c.cache {
+			t.Fatal(c.url, res.Body.String(), res.Header())
+		}
+		if !strings.HasPrefix(res.Header().Get("Content-Type"), "text/javascript") {
+			t.Fatal(c.url, res.Header())
+		}
+	}
+	// Manifest.
+	var buf bytes.Buffer
+	err := a.WriteManifest(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]string
+	err = json.Unmarshal(buf.Bytes(), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 3 || m["js/app.js"] != appURL {
+		t.Fatal(m)
+	}
+	// Template.
+	tp := template.Must(template.New("").Funcs(a.FuncMap()).Parse(`<script src="{{asset "js/app.js"}}"></script>`))
+	buf.Reset()
+	err = tp.Execute(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != `<script src="`+appURL+`"></script>` {
+		t.Fatal(buf.String())
+	}
+}
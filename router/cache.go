@@ -0,0 +1,327 @@
+package router
+
+import (
+	"container/list"
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// DefaultStaticCache 是 RootRouter.Static 使用的缓存
+var DefaultStaticCache = NewStaticCache(nil)
+
+// StaticCacheOptions 是 StaticCache 的选项
+type StaticCacheOptions struct {
+	// 缓存的最大字节数，包括压缩的数据，超过时淘汰最久没有访问的文件，<=0 表示 64MB
+	MaxBytes int64
+	// 检查文件是否修改的间隔，0 表示每次请求都检查，<0 表示不检查。
+	// 间隔内的请求直接使用缓存，不会打开文件。
+	CheckInterval time.Duration
+	// 后台检查所有缓存的文件的间隔，包括预压缩文件，修改或者删除的被移除，<=0 表示不在后台检查。
+	// 和 CheckInterval <0 一起使用时，请求不会打开文件，修改在一个间隔内生效。
+	PollInterval time.Duration
+}
+
+// StaticCacheStats 是 StaticCache 的统计
+type StaticCacheStats struct {
+	// 使用缓存响应的次数
+	Hits int64
+	// 文件不在缓存中，读取文件的次数
+	Misses int64
+	// 文件修改后，重新读取文件的次数
+	Reloads int64
+	// 文件删除，或者修改前后都不能缓存，或者在后台检查到修改，被移除的次数
+	Invalidations int64
+	// 因为超过 MaxBytes 被淘汰的次数
+	Evictions int64
+	// 当前缓存的文件数
+	Entries int
+	// 当前缓存的字节数
+	Bytes int64
+}
+
+// staticCacheKey 是缓存的键，不同的 FSHandler 可以共享一个 StaticCache
+type staticCacheKey struct {
+	h    *FSHandler
+	name string
+}
+
+// staticCacheStamp 是文件的修改时间和大小，任何一个不同都表示文件已经修改
+type staticCacheStamp struct {
+	modTime time.Time
+	size    int64
+	// 文件是否存在
+	exists bool
+}
+
+// statStaticCacheStamp 返回 fsys 中文件 name 的 staticCacheStamp ，文件不存在不是错误
+func statStaticCacheStamp(fsys fs.FS, name string) (staticCacheStamp, error) {
+	fi, err := fs.Stat(fsys, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return staticCacheStamp{}, nil
+		}
+		return staticCacheStamp{}, err
+	}
+	return staticCacheStamp{modTime: fi.ModTime(), size: fi.Size(), exists: true}, nil
+}
+
+// equal 判断 a 和 b 是不是同一个版本的文件
+func (a staticCacheStamp) equal(b staticCacheStamp) bool {
+	return a.exists == b.exists && a.modTime.Equal(b.modTime) && a.size == b.size
+}
+
+// staticCacheEntry 是缓存的文件
+type staticCacheEntry struct {
+	key     staticCacheKey
+	handler *CacheHandler
+	// 文件的修改时间和大小，任何一个不同都表示文件已经修改
+	modTime time.Time
+	size    int64
+	// 预压缩文件的 staticCacheStamp ，包括不存在的，新增的也表示已经修改，读取以后不再改变
+	precompressed map[string]staticCacheStamp
+	// 占用的字节数
+	bytes int64
+	// 上一次检查的时间
+	checked time.Time
+}
+
+// StaticCache 是 FSHandler 的文件缓存，见 StaticOptions.Cache 。
+// 它在请求时比较文件和预压缩文件的修改时间和大小，修改后重新读取，文件删除后移除，见 CheckInterval 。
+// 设置 PollInterval 时，还在后台定时检查所有缓存的文件，修改或者删除的被移除，
+// 这样即使没有请求，或者 CheckInterval <0 ，修改也会生效，不再使用时需要调用 Close 。
+// 所有文件共享 MaxBytes 的内存预算，超过时淘汰最久没有访问的文件。
+type StaticCache struct {
+	opt     StaticCacheOptions
+	lock    sync.Mutex
+	entries map[staticCacheKey]*list.Element
+	lru     *list.List
+	stats   StaticCacheStats
+	// 关闭时停止后台检查
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStaticCache 返回一个 StaticCache ，opt 为 nil 表示使用默认选项
+func NewStaticCache(opt *StaticCacheOptions) *StaticCache {
+	c := &StaticCache{
+		entries: make(map[staticCacheKey]*list.Element),
+		lru:     list.New(),
+		done:    make(chan struct{}),
+	}
+	if opt != nil {
+		c.opt = *opt
+	}
+	if c.opt.MaxBytes <= 0 {
+		c.opt.MaxBytes = 64 << 20
+	}
+	if c.opt.PollInterval > 0 {
+		go c.pollLoop()
+	}
+	return c
+}
+
+// Close 停止后台检查，缓存仍然可以使用
+func (c *StaticCache) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// pollLoop 每隔 PollInterval 检查一次，直到 Close
+func (c *StaticCache) pollLoop() {
+	ticker := time.NewTicker(c.opt.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.poll()
+		}
+	}
+}
+
+// poll 检查所有缓存的文件，移除修改或者删除的
+func (c *StaticCache) poll() {
+	c.lock.Lock()
+	entries := make([]*staticCacheEntry, 0, c.lru.Len())
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*staticCacheEntry))
+	}
+	c.lock.Unlock()
+	// 检查文件时不加锁
+	for _, e := range entries {
+		stamp, err := statStaticCacheStamp(e.key.h.fs, e.key.name)
+		if err == nil && stamp.equal(staticCacheStamp{modTime: e.modTime, size: e.size, exists: true}) &&
+			!e.precompressedChanged() {
+			continue
+		}
+		c.lock.Lock()
+		// 检查时可能已经重新读取或者淘汰
+		if elem, ok := c.entries[e.key]; ok && elem.Value == e {
+			c.removeElement(elem)
+			c.stats.Invalidations++
+		}
+		c.lock.Unlock()
+	}
+}
+
+// precompressedChanged 判断预压缩文件是否修改，新增或者删除
+func (e *staticCacheEntry) precompressedChanged() bool {
+	for name, stamp := range e.precompressed {
+		s, err := statStaticCacheStamp(e.key.h.fs, name)
+		if err != nil || !s.equal(stamp) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats 返回统计
+func (c *StaticCache) Stats() StaticCacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// Clear 移除所有的缓存，不改变统计的次数
+func (c *StaticCache) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[staticCacheKey]*list.Element)
+	c.lru.Init()
+	c.stats.Entries = 0
+	c.stats.Bytes = 0
+}
+
+// fresh 返回检查间隔内的缓存，nil 表示需要打开文件检查
+func (c *StaticCache) fresh(h *FSHandler, name string) *CacheHandler {
+	if c.opt.CheckInterval == 0 {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.entries[staticCacheKey{h: h, name: name}]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*staticCacheEntry)
+	if c.opt.CheckInterval > 0 && time.Since(e.checked) >= c.opt.CheckInterval {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.Hits++
+	return e.handler
+}
+
+// load 返回文件 name 的缓存，fi 是文件的信息，文件已经修改就重新读取。
+// 返回 nil 表示文件太大，不能缓存。
+func (c *StaticCache) load(h *FSHandler, name string, fi fs.FileInfo) (*CacheHandler, error) {
+	key := staticCacheKey{h: h, name: name}
+	c.lock.Lock()
+	elem, ok := c.entries[key]
+	var old *staticCacheEntry
+	if ok {
+		old = elem.Value.(*staticCacheEntry)
+	}
+	c.lock.Unlock()
+	// 检查预压缩文件时不加锁
+	if ok && old.modTime.Equal(fi.ModTime()) && old.size == fi.Size() && !old.precompressedChanged() {
+		c.lock.Lock()
+		if elem, ok := c.entries[key]; ok && elem.Value == old {
+			old.checked = time.Now()
+			c.lru.MoveToFront(elem)
+		}
+		c.stats.Hits++
+		c.lock.Unlock()
+		return old.handler, nil
+	}
+	if fi.Size() > h.opt.CacheFileSize {
+		if ok {
+			c.remove(h, name)
+		}
+		return nil, nil
+	}
+	// 读取文件时不加锁
+	data, err := fs.ReadFile(h.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	handler, err := NewCacheHandler(name, fi.ModTime(), data)
+	if err != nil {
+		return nil, err
+	}
+	var precompressed map[string]staticCacheStamp
+	if h.opt.Precompressed {
+		// 在读取之前记录，读取时修改的，下一次检查会重新读取
+		precompressed = make(map[string]staticCacheStamp)
+		for _, e := range registeredEncodings() {
+			if e.ext == "" {
+				continue
+			}
+			precompressed[name+e.ext], err = statStaticCacheStamp(h.fs, name+e.ext)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = handler.loadPrecompressed(name, func(name string) ([]byte, error) {
+			return fs.ReadFile(h.fs, name)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	e := &staticCacheEntry{
+		key:           key,
+		handler:       handler,
+		modTime:       fi.ModTime(),
+		size:          fi.Size(),
+		precompressed: precompressed,
+		bytes:         handler.size(),
+		checked:       time.Now(),
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ok {
+		c.stats.Reloads++
+	} else {
+		c.stats.Misses++
+	}
+	// 并发的请求可能已经读取过
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	// 超过预算的文件不缓存
+	if e.bytes > c.opt.MaxBytes {
+		return handler, nil
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	c.stats.Entries++
+	c.stats.Bytes += e.bytes
+	for c.stats.Bytes > c.opt.MaxBytes {
+		c.removeElement(c.lru.Back())
+		c.stats.Evictions++
+	}
+	return handler, nil
+}
+
+// remove 移除文件 name 的缓存，比如文件已经删除
+func (c *StaticCache) remove(h *FSHandler, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.entries[staticCacheKey{h: h, name: name}]
+	if ok {
+		c.removeElement(elem)
+		c.stats.Invalidations++
+	}
+}
+
+// removeElement 移除 elem ，需要加锁
+func (c *StaticCache) removeElement(elem *list.Element) {
+	e := c.lru.Remove(elem).(*staticCacheEntry)
+	delete(c.entries, e.key)
+	c.stats.Entries--
+	c.stats.Bytes -= e.bytes
+}
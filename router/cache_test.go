@@ -0,0 +1,237 @@
+package router
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testStaticCache(t *testing.T, r RootRouter, url, body string) {
+	res := testServe(r, http.MethodGet, url, nil)
+	if res.Code != http.StatusOK || res.Body.String() != body {
+		t.Fatal(url, res.Code, res.Body.String())
+	}
+}
+
+func Test_StaticCache(t *testing.T) {
+	now := time.Now()
+	fsys := fstest.MapFS{
+		"a.txt":   {Data: []byte("a"), ModTime: now},
+		"big.txt": {Data: []byte(strings.Repeat("b", 100)), ModTime: now},
+	}
+	cache := NewStaticCache(nil)
+	r := NewRootRouter()
+	r.StaticFS("/", fsys, &StaticOptions{Cache: cache, CacheFileSize: 10})
+	testStaticCache(t, r, "/a.txt", "a")
+	testStaticCache(t, r, "/a.txt", "a")
+	testStaticCache(t, r, "/big.txt", strings.Repeat("b", 100))
+	s := cache.Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.Entries != 1 || s.Bytes != 1 {
+		t.Fatal(s)
+	}
+	// Changed.
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("aa"), ModTime: now.Add(time.Second)}
+	testStaticCache(t, r, "/a.txt", "aa")
+	s = cache.Stats()
+	if s.Reloads != 1 || s.Entries != 1 || s.Bytes != 2 {
+		t.Fatal(s)
+	}
+	// Too large after changed.
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte(strings.Repeat("a", 11)), ModTime: now}
+	testStaticCache(t, r, "/a.txt", strings.Repeat("a", 11))
+	s = cache.Stats()
+	if s.Invalidations != 1 || s.Entries != 0 || s.Bytes != 0 {
+		t.Fatal(s)
+	}
+	// Removed.
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("a"), ModTime: now}
+	testStaticCache(t, r, "/a.txt", "a")
+	delete(fsys, "a.txt")
+	res := testServe(r, http.MethodGet, "/a.txt", nil)
+	if res.Code != http.StatusNotFound {
+		t.Fatal(res.Code)
+	}
+	s = cache.Stats()
+	if s.Invalidations != 2 || s.Entries != 0 || s.Bytes != 0 {
+		t.Fatal(s)
+	}
+}
+
+func Test_StaticCache_CheckInterval(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("a")},
+	}
+	cache := NewStaticCache(&StaticCacheOptions{CheckInterval: 50 * time.Millisecond})
+	r := NewRootRouter()
+	r.StaticFS("/", fsys, &StaticOptions{Cache: cache})
+	testStaticCache(t, r, "/a.txt", "a")
+	// Not checked in the interval, even if removed.
+	delete(fsys, "a.txt")
+	testStaticCache(t, r, "/a.txt", "a")
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("b"), ModTime: time.Now()}
+	time.Sleep(60 * time.Millisecond)
+	testStaticCache(t, r, "/a.txt", "b")
+	s := cache.Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.Reloads != 1 {
+		t.Fatal(s)
+	}
+}
+
+func Test_StaticCache_Evict(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 10))
+	fsys := fstest.MapFS{
+		"a.txt": {Data: data},
+		"b.txt": {Data: data},
+		"c.txt": {Data: data},
+	}
+	// Size of an entry, includes the encoded data.
+	cache := NewStaticCache(nil)
+	r := NewRootRouter()
+	r.StaticFS("/", fsys, &StaticOptions{Cache: cache})
+	testStaticCache(t, r, "/a.txt", string(data))
+	size := cache.Stats().Bytes
+	// Two entries.
+	cache = NewStaticCache(&StaticCacheOptions{MaxBytes: size*2 + 1})
+	r = NewRootRouter()
+	r.StaticFS("/", fsys, &StaticOptions{Cache: cache})
+	testStaticCache(t, r, "/a.txt", string(data))
+	testStaticCache(t, r, "/b.txt", string(data))
+	testStaticCache(t, r, "/a.txt", string(data))
+	// Evict b.txt.
+	testStaticCache(t, r, "/c.txt", string(data))
+	testStaticCache(t, r, "/a.txt", string(data))
+	s := cache.Stats()
+	if s.Evictions != 1 || s.Entries != 2 || s.Bytes != size*2 || s.Hits != 2 || s.Misses != 3 {
+		t.Fatal(s)
+	}
+	testStaticCache(t, r, "/b.txt", string(data))
+	s = cache.Stats()
+	if s.Evictions != 2 || s.Misses != 4 {
+		t.Fatal(s)
+	}
+	// Compressed.
+	res := testServe(r, http.MethodGet, "/b.txt", http.Header{"Accept-Encoding": []string{"gzip"}})
+	if res.Code != http.StatusOK || res.Header().Get("Content-Encoding") != "gzip" || res.Header().Get("ETag") == "" {
+		t.Fatal(res.Code, res.Header())
+	}
+	cache.Clear()
+	s = cache.Stats()
+	if s.Entries != 0 || s.Bytes != 0 {
+		t.Fatal(s)
+	}
+}
+
+// fstest.MapFS which can be changed while the cache is polling.
+type testLockFS struct {
+	lock sync.Mutex
+	fs   fstest.MapFS
+}
+
+func (f *testLockFS) Open(name string) (fs.File, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.fs.Open(name)
+}
+
+func (f *testLockFS) Set(name, data string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if data == "" {
+		delete(f.fs, name)
+		return
+	}
+	f.fs[name] = &fstest.MapFile{Data: []byte(data), ModTime: time.Now()}
+}
+
+// Wait for n invalidations.
+func testStaticCacheInvalidated(t *testing.T, cache *StaticCache, n int64) {
+	for i := 0; i < 500 && cache.Stats().Invalidations < n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := cache.Stats(); s.Invalidations != n || s.Entries != 0 {
+		t.Fatal(s)
+	}
+}
+
+func Test_StaticCache_Poll(t *testing.T) {
+	data := strings.Repeat("a", 100)
+	fsys := &testLockFS{fs: fstest.MapFS{}}
+	fsys.Set("a.txt", data)
+	fsys.Set("a.txt.gz", "gz")
+	// Requests never check.
+	cache := NewStaticCache(&StaticCacheOptions{CheckInterval: -1, PollInterval: 10 * time.Millisecond})
+	defer cache.Close()
+	r := NewRootRouter()
+	r.StaticFS("/", fsys, &StaticOptions{Cache: cache, Precompressed: true})
+	gzip := http.Header{"Accept-Encoding": []string{"gzip"}}
+	testStaticCache(t, r, "/a.txt", data)
+	// Changed.
+	fsys.Set("a.txt", "b")
+	testStaticCacheInvalidated(t, cache, 1)
+	testStaticCache(t, r, "/a.txt", "b")
+	// Precompressed changed.
+	fsys.Set("a.txt", data)
+	testStaticCacheInvalidated(t, cache, 2)
+	res := testServe(r, http.MethodGet, "/a.txt", gzip)
+	if res.Body.String() != "gz" {
+		t.Fatal(res.Body.String())
+	}
+	fsys.Set("a.txt.gz", "gz2")
+	testStaticCacheInvalidated(t, cache, 3)
+	res = testServe(r, http.MethodGet, "/a.txt", gzip)
+	if res.Body.String() != "gz2" {
+		t.Fatal(res.Body.String())
+	}
+	// Precompressed added.
+	fsys.Set("a.txt.br", "br")
+	testStaticCacheInvalidated(t, cache, 4)
+	res = testServe(r, http.MethodGet, "/a.txt", http.Header{"Accept-Encoding": []string{"br"}})
+	if res.Header().Get("Content-Encoding") != "br" || res.Body.String() != "br" {
+		t.Fatal(res.Header(), res.Body.String())
+	}
+	// Removed.
+	fsys.Set("a.txt", "")
+	testStaticCacheInvalidated(t, cache, 5)
+	res = testServe(r, http.MethodGet, "/a.txt", nil)
+	if res.Code != http.StatusNotFound {
+		t.Fatal(res.Code)
+	}
+	// Stopped.
+	cache.Close()
+	fsys.Set("a.txt", data)
+	testStaticCache(t, r, "/a.txt", data)
+	fsys.Set("a.txt", "b")
+	time.Sleep(50 * time.Millisecond)
+	testStaticCache(t, r, "/a.txt", data)
+}
+
+func Test_StaticCache_Precompressed(t *testing.T) {
+	data := strings.Repeat("a", 100)
+	now := time.Now()
+	fsys := fstest.MapFS{
+		"a.txt":    {Data: []byte(data), ModTime: now},
+		"a.txt.gz": {Data: []byte("gz"), ModTime: now},
+	}
+	cache := NewStaticCache(nil)
+	r := NewRootRouter()
+	r.StaticFS("/", fsys, &StaticOptions{Cache: cache, Precompressed: true})
+	gzip := http.Header{"Accept-Encoding": []string{"gzip"}}
+	for i, s := range []string{"gz", "gz", "gz2"} {
+		if i == 2 {
+			// Only the precompressed file is changed.
+			fsys["a.txt.gz"] = &fstest.MapFile{Data: []byte("gz2"), ModTime: now.Add(time.Second)}
+		}
+		res := testServe(r, http.MethodGet, "/a.txt", gzip)
+		if res.Body.String() != s {
+			t.Fatal(res.Body.String())
+		}
+	}
+	s := cache.Stats()
+	if s.Misses != 1 || s.Hits != 1 || s.Reloads != 1 {
+		t.Fatal(s)
+	}
+}
@@ -36,6 +36,12 @@ type Context struct {
 	Param []string
 	// 用于在调用链中保存临时数据
 	TempData interface{}
+	// Error 报告的错误，用于 Root.Error 的处理函数
+	Err error
+	// 所属的 rootRouter
+	root *rootRouter
+	// 匹配的路由
+	route string
 	// 保存调用链函数
 	handleFunc []HandleFunc
 	// 当前调用的函数下标
@@ -61,6 +67,33 @@ func (ctx *Context) Abort() {
 	ctx.handleIdx = len(ctx.handleFunc)
 }
 
+// Writer 返回包装 ctx.ResponseWriter 的 Response ，并设置为 ctx.ResponseWriter ，
+// 已经是 Response 就直接返回，所以调用链中的函数共享一个 Response 。
+// 中间件替换 ctx.ResponseWriter 后，比如 ETag ，之后的调用会包装新的 http.ResponseWriter 。
+func (ctx *Context) Writer() *Response {
+	if r, ok := ctx.ResponseWriter.(*Response); ok {
+		return r
+	}
+	r := NewResponse(ctx.ResponseWriter)
+	ctx.ResponseWriter = r
+	return r
+}
+
+// Route 返回匹配的路由，比如 "/users/?" ，空表示没有匹配
+func (ctx *Context) Route() string {
+	return ctx.route
+}
+
+// Error 报告错误 err ，执行 Root.Error 设置的处理函数，然后回到当前的调用链。
+// 默认的处理函数响应 500 。
+func (ctx *Context) Error(err error) {
+	ctx.Err = err
+	handleFunc, handleIdx := ctx.handleFunc, ctx.handleIdx
+	ctx.handleFunc, ctx.handleIdx = ctx.root.error, 0
+	ctx.handle()
+	ctx.handleFunc, ctx.handleIdx = handleFunc, handleIdx
+}
+
 // BearerToken 尝试读取 Authorization 头中的 Bearer 的值，读取失败返回空字符串串.
 func (ctx *Context) BearerToken() string {
 	token := ctx.Request.Header.Get("Authorization")
@@ -105,3 +138,27 @@ func (ctx *Context) WriteHTMLBytes(statusCode int, data []byte) error {
 	_, err := ctx.ResponseWriter.Write(data)
 	return err
 }
+
+// Render 使用 Root.View 设置的 View 和它的默认布局，渲染页面 name ，数据直接写到响应 body 中。
+// 状态码 statusCode 和 Content-Type: html +utf8 在第一次写数据时才设置，
+// 在此之前的错误，比如页面不存在和模板执行失败，使用 Error 报告。
+func (ctx *Context) Render(statusCode int, name string, data interface{}) error {
+	v := ctx.root.view
+	if v == nil {
+		ctx.Error(errNoView)
+		return errNoView
+	}
+	w := &renderWriter{ctx: ctx, statusCode: statusCode}
+	err := v.Render(w, name, data)
+	if err != nil {
+		if !w.wrote {
+			ctx.Error(err)
+		}
+		return err
+	}
+	// 没有数据
+	if !w.wrote {
+		w.writeHeader()
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package router
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder 创建 Content-Encoding 的压缩器，压缩写到 w 的数据
+type Encoder func(w io.Writer) (io.WriteCloser, error)
+
+// encoding 表示一种 Content-Encoding
+type encoding struct {
+	name string
+	// 预压缩文件的扩展名，比如 ".gz" ，空表示没有
+	ext string
+	// nil 表示只使用预压缩文件
+	encoder Encoder
+}
+
+var (
+	encodingMu sync.RWMutex
+	// 按照优先级排列，br 和 zstd 默认只使用预压缩文件
+	encodings = []*encoding{
+		{name: "br", ext: ".br"},
+		{name: "zstd", ext: ".zst"},
+		{name: "gzip", ext: ".gz", encoder: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestCompression)
+		}},
+		// HTTP 的 deflate 是 zlib 格式，不是原始的 DEFLATE
+		{name: "deflate", encoder: func(w io.Writer) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, zlib.BestCompression)
+		}},
+	}
+)
+
+// RegisterEncoder 注册 Content-Encoding name 的压缩器，ext 是预压缩文件的扩展名，空表示没有，
+// encoder 为 nil 表示只使用预压缩文件。
+// 已经注册的 name 会被替换，保持原来的优先级，新的 name 优先级最低。
+// 比如可以为 br 和 zstd 注册第三方库的压缩器。
+// 它应该在创建 CacheHandler 和 FSHandler 之前调用。
+func RegisterEncoder(name, ext string, encoder Encoder) {
+	name = strings.ToLower(name)
+	encodingMu.Lock()
+	defer encodingMu.Unlock()
+	for i, e := range encodings {
+		if e.name == name {
+			encodings[i] = &encoding{name: name, ext: ext, encoder: encoder}
+			return
+		}
+	}
+	encodings = append(encodings, &encoding{name: name, ext: ext, encoder: encoder})
+}
+
+// registeredEncodings 返回注册的 encoding 的副本
+func registeredEncodings() []*encoding {
+	encodingMu.RLock()
+	defer encodingMu.RUnlock()
+	return append([]*encoding(nil), encodings...)
+}
+
+// parseAcceptEncoding 解析 Accept-Encoding ，返回 coding 的 q 值，q 值无效的被忽略
+func parseAcceptEncoding(s string) map[string]float64 {
+	accept := make(map[string]float64)
+	for _, s := range strings.Split(s, ",") {
+		params := strings.Split(s, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if len(p) < 2 || (p[0] != 'q' && p[0] != 'Q') || p[1] != '=' {
+				continue
+			}
+			f, err := strconv.ParseFloat(p[2:], 64)
+			if err != nil || f < 0 || f > 1 {
+				q = -1
+			} else {
+				q = f
+			}
+		}
+		if q >= 0 {
+			accept[name] = q
+		}
+	}
+	return accept
+}
+
+// acceptIdentity 判断 Accept-Encoding 是否接受不压缩的数据，
+// 只有 identity 的 q 值为 0 ，或者没有 identity 并且 * 的 q 值为 0 时不接受。
+func acceptIdentity(header string) bool {
+	if header == "" {
+		return true
+	}
+	accept := parseAcceptEncoding(header)
+	if q, ok := accept["identity"]; ok {
+		return q > 0
+	}
+	if q, ok := accept["*"]; ok {
+		return q > 0
+	}
+	return true
+}
+
+// negotiateEncoding 按照 Accept-Encoding 的 q 值和注册的优先级，选择 available 返回 true 的 encoding ，
+// nil 表示不压缩，比如没有可以接受的 encoding ，或者 identity 的 q 值更大。
+func negotiateEncoding(header string, available func(e *encoding) bool) *encoding {
+	if header == "" {
+		return nil
+	}
+	accept := parseAcceptEncoding(header)
+	var best *encoding
+	bestQ := 0.0
+	for _, e := range registeredEncodings() {
+		q, ok := accept[e.name]
+		if !ok {
+			q = accept["*"]
+		}
+		if q > bestQ && available(e) {
+			best, bestQ = e, q
+		}
+	}
+	if q, ok := accept["identity"]; ok && q > bestQ {
+		return nil
+	}
+	return best
+}
@@ -0,0 +1,86 @@
+package router
+
+import (
+	"io"
+	"testing"
+)
+
+func Test_parseAcceptEncoding(t *testing.T) {
+	accept := parseAcceptEncoding(" gzip ; q=0.5,br,  X-GZIP;q=0.6 , zstd;q=2, deflate;q=0 ,, *;q=0.1")
+	for name, q := range map[string]float64{"gzip": 0.6, "br": 1, "deflate": 0, "*": 0.1} {
+		if v, ok := accept[name]; !ok || v != q {
+			t.Error(name, v)
+		}
+	}
+	if _, ok := accept["zstd"]; ok || len(accept) != 4 {
+		t.Error(accept)
+	}
+}
+
+func Test_negotiateEncoding(t *testing.T) {
+	all := func(*encoding) bool { return true }
+	gzipOnly := func(e *encoding) bool { return e.name == "gzip" }
+	for _, c := range []struct {
+		header    string
+		available func(*encoding) bool
+		name      string
+	}{
+		{"", all, ""},
+		{"gzip, deflate, br", all, "br"},
+		{"gzip, deflate, br", gzipOnly, "gzip"},
+		{"gzip;q=1, br;q=0.5", all, "gzip"},
+		{"gzip;q=0, br;q=0", all, ""},
+		{"*", all, "br"},
+		{"*, br;q=0", all, "zstd"},
+		{"zlib", all, ""},
+		{"identity;q=1, gzip;q=0.5", all, ""},
+		{"identity;q=0.1, gzip;q=0.5", gzipOnly, "gzip"},
+	} {
+		e := negotiateEncoding(c.header, c.available)
+		name := ""
+		if e != nil {
+			name = e.name
+		}
+		if name != c.name {
+			t.Errorf("%q want %q got %q", c.header, c.name, name)
+		}
+	}
+}
+
+func Test_acceptIdentity(t *testing.T) {
+	for header, ok := range map[string]bool{
+		"":                      true,
+		"gzip":                  true,
+		"identity;q=0":          false,
+		"gzip, identity;q=0":    false,
+		"*;q=0":                 false,
+		"*;q=0, identity":       true,
+		"identity;q=0.1, *;q=0": true,
+	} {
+		if acceptIdentity(header) != ok {
+			t.Errorf("%q want %v", header, ok)
+		}
+	}
+}
+
+type testEncoder struct {
+	io.Writer
+}
+
+func (w *testEncoder) Close() error {
+	return nil
+}
+
+func Test_RegisterEncoder(t *testing.T) {
+	defer func(e []*encoding) {
+		encodings = e
+	}(registeredEncodings())
+	RegisterEncoder("BR", ".br", func(w io.Writer) (io.WriteCloser, error) {
+		return &testEncoder{Writer: w}, nil
+	})
+	RegisterEncoder("test", ".test", nil)
+	e := registeredEncodings()
+	if len(e) != 5 || e[0].name != "br" || e[0].encoder == nil || e[4].name != "test" {
+		t.Fatal(e)
+	}
+}
@@ -0,0 +1,143 @@
+package router
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/qq51529210/web/util"
+)
+
+// hashETag 返回 data 的强 ETag ，是 SHA256 的前 32 个十六进制字符
+func hashETag(data []byte) string {
+	return `"` + util.SHA256(data)[:32] + `"`
+}
+
+// weakETag 返回 etag 的弱 ETag ，用于压缩的数据，它们和原数据语义相同，但是字节不同
+func weakETag(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// matchETag 判断 etag 是否在 If-Match 或者 If-None-Match 的列表 s 中，weak 表示使用弱比较
+func matchETag(s, etag string, weak bool) bool {
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		// 强比较，弱 ETag 都不相等
+		if tag == etag && !strings.HasPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkETag 根据 If-Match 和 If-None-Match 检查 GET 请求，
+// 返回 http.StatusPreconditionFailed ，http.StatusNotModified ，或者 0 表示需要响应数据。
+func checkETag(req *http.Request, etag string) int {
+	if s := req.Header.Get("If-Match"); s != "" && !matchETag(s, etag, false) {
+		return http.StatusPreconditionFailed
+	}
+	if s := req.Header.Get("If-None-Match"); s != "" && matchETag(s, etag, true) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// etagWriter 缓存响应的数据。
+// Flush 或者 Content-Type 是 text/event-stream 时，不再缓存，直接写到 ResponseWriter 。
+type etagWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+	// 不缓存
+	stream bool
+}
+
+func (w *etagWriter) WriteHeader(statusCode int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = statusCode
+	if strings.HasPrefix(w.Header().Get(contentType), "text/event-stream") {
+		w.stream = true
+		w.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.stream {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+// Flush 实现 http.Flusher ，写出缓存的数据，之后不再缓存
+func (w *etagWriter) Flush() {
+	if !w.stream {
+		w.stream = true
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ETag 是一个中间件，用于 Intercept 。
+// 它缓存 GET 请求的响应，状态码是 200 时，如果处理函数没有设置 ETag 头，
+// 使用响应数据的哈希值生成强 ETag ，然后根据 If-None-Match 响应 304 ，根据 If-Match 响应 412 。
+// WebSocket 升级请求，调用了 Flush 和 text/event-stream 的响应不被缓存。
+func ETag(ctx *Context) {
+	req := ctx.Request
+	if req.Method != http.MethodGet || req.Header.Get("Upgrade") != "" {
+		ctx.Handle()
+		return
+	}
+	res := ctx.ResponseWriter
+	w := &etagWriter{ResponseWriter: res}
+	ctx.ResponseWriter = w
+	ctx.Handle()
+	ctx.ResponseWriter = res
+	if w.stream {
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	header := res.Header()
+	if w.status == http.StatusOK {
+		etag := header.Get("ETag")
+		if etag == "" {
+			etag = hashETag(w.buf.Bytes())
+			header.Set("ETag", etag)
+		}
+		if status := checkETag(req, etag); status != 0 {
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+			res.WriteHeader(status)
+			return
+		}
+		header.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	}
+	res.WriteHeader(w.status)
+	res.Write(w.buf.Bytes())
+}
@@ -0,0 +1,143 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_matchETag(t *testing.T) {
+	for _, c := range []struct {
+		list, etag string
+		weak, ok   bool
+	}{
+		{`"a"`, `"a"`, false, true},
+		{`"b", "a"`, `"a"`, false, true},
+		{`W/"a"`, `"a"`, false, false},
+		{`"a"`, `W/"a"`, false, false},
+		{`W/"a"`, `"a"`, true, true},
+		{`"a"`, `W/"a"`, true, true},
+		{`*`, `"a"`, false, true},
+		{`"b"`, `"a"`, true, false},
+	} {
+		if matchETag(c.list, c.etag, c.weak) != c.ok {
+			t.Error(c)
+		}
+	}
+}
+
+func Test_CacheHandler_ETag(t *testing.T) {
+	h, err := NewCacheHandler("a.txt", time.Time{}, []byte(string(make([]byte, 1024))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRootRouter()
+	r.GET("/a.txt", h.Handle)
+	res := testServe(r, http.MethodGet, "/a.txt", nil)
+	etag := res.Header().Get("ETag")
+	if res.Code != 200 || etag != h.etag || len(etag) != 34 {
+		t.Fatal(res.Header())
+	}
+	// Compressed variant is weak.
+	res = testServe(r, http.MethodGet, "/a.txt", http.Header{"Accept-Encoding": []string{"gzip"}})
+	if res.Code != 200 || res.Header().Get("ETag") != "W/"+etag {
+		t.Fatal(res.Header())
+	}
+	for _, c := range []struct {
+		header http.Header
+		status int
+	}{
+		{http.Header{"If-None-Match": []string{etag}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": []string{etag}, "Accept-Encoding": []string{"gzip"}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": []string{`"other"`}}, http.StatusOK},
+		{http.Header{"If-Match": []string{etag}}, http.StatusOK},
+		{http.Header{"If-Match": []string{`"other"`}}, http.StatusPreconditionFailed},
+		// Weak is never strong equal.
+		{http.Header{"If-Match": []string{"W/" + etag}, "Accept-Encoding": []string{"gzip"}}, http.StatusPreconditionFailed},
+	} {
+		res = testServe(r, http.MethodGet, "/a.txt", c.header)
+		if res.Code != c.status {
+			t.Fatal(c.header, res.Code)
+		}
+	}
+}
+
+func Test_ETag(t *testing.T) {
+	r := NewRootRouter()
+	r.Intercept(ETag)
+	body := "hello"
+	r.GET("/dynamic", func(ctx *Context) {
+		ctx.ResponseWriter.Header().Set("Content-Type", "text/plain")
+		io.WriteString(ctx.ResponseWriter, body)
+	})
+	r.GET("/tagged", func(ctx *Context) {
+		ctx.ResponseWriter.Header().Set("ETag", `"v1"`)
+		io.WriteString(ctx.ResponseWriter, body)
+	})
+	r.GET("/error", func(ctx *Context) {
+		ctx.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(ctx.ResponseWriter, body)
+	})
+	r.POST("/dynamic", func(ctx *Context) {
+		io.WriteString(ctx.ResponseWriter, body)
+	})
+	res := testServe(r, http.MethodGet, "/dynamic", nil)
+	etag := res.Header().Get("ETag")
+	if res.Code != 200 || res.Body.String() != "hello" || etag != hashETag([]byte("hello")) || res.Header().Get("Content-Length") != "5" {
+		t.Fatal(res.Code, res.Header())
+	}
+	res = testServe(r, http.MethodGet, "/dynamic", http.Header{"If-None-Match": []string{etag}})
+	if res.Code != http.StatusNotModified || res.Body.Len() != 0 || res.Header().Get("Content-Type") != "" {
+		t.Fatal(res.Code, res.Header())
+	}
+	// Changed.
+	body = "world"
+	res = testServe(r, http.MethodGet, "/dynamic", http.Header{"If-None-Match": []string{etag}})
+	if res.Code != 200 || res.Body.String() != "world" {
+		t.Fatal(res.Code)
+	}
+	res = testServe(r, http.MethodGet, "/dynamic", http.Header{"If-Match": []string{etag}})
+	if res.Code != http.StatusPreconditionFailed {
+		t.Fatal(res.Code)
+	}
+	res = testServe(r, http.MethodGet, "/tagged", http.Header{"If-None-Match": []string{`W/"v1"`}})
+	if res.Code != http.StatusNotModified || res.Header().Get("ETag") != `"v1"` {
+		t.Fatal(res.Code, res.Header())
+	}
+	// Not 200 or GET.
+	res = testServe(r, http.MethodGet, "/error", nil)
+	if res.Code != http.StatusInternalServerError || res.Body.String() != "world" || res.Header().Get("ETag") != "" {
+		t.Fatal(res.Code, res.Header())
+	}
+	res = testServe(r, http.MethodPost, "/dynamic", nil)
+	if res.Code != 200 || res.Header().Get("ETag") != "" {
+		t.Fatal(res.Code, res.Header())
+	}
+}
+
+func Test_ETag_Stream(t *testing.T) {
+	r := NewRootRouter()
+	r.Intercept(ETag)
+	r.GET("/sse", func(ctx *Context) {
+		ctx.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(ctx.ResponseWriter, "data: 1\n\n")
+	})
+	r.GET("/flush", func(ctx *Context) {
+		io.WriteString(ctx.ResponseWriter, "a")
+		ctx.ResponseWriter.(http.Flusher).Flush()
+		io.WriteString(ctx.ResponseWriter, "b")
+	})
+	for url, body := range map[string]string{
+		"/sse":   "data: 1\n\n",
+		"/flush": "ab",
+	} {
+		res := testServe(r, http.MethodGet, url, nil)
+		if res.Code != http.StatusOK || res.Body.String() != body || res.Header().Get("ETag") != "" {
+			t.Fatal(url, res.Code, res.Header(), res.Body.String())
+		}
+		if url == "/flush" && !res.Flushed {
+			t.Fatal(url)
+		}
+	}
+}
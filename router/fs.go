@@ -0,0 +1,367 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// StaticOptions 是 FSHandler 的选项
+type StaticOptions struct {
+	// 目录的默认文件，空表示 "index.html"
+	Index string
+	// 目录没有默认文件时，是否列出目录的文件
+	Browse bool
+	// 是否可以访问 "." 开头的文件和目录
+	ShowHidden bool
+	// 响应的 Cache-Control 头，空表示不设置
+	CacheControl string
+	// html 文件的 Cache-Control 头，空表示使用 CacheControl
+	HTMLCacheControl string
+	// 返回 true 表示 name 是带指纹的文件，使用 ImmutableCacheControl ，nil 表示没有，
+	// 设置了 Fallback 时 nil 表示 IsFingerprinted 。name 是文件在 fs.FS 中的路径
+	Immutable func(name string) bool
+	// 是否响应预压缩的文件，比如请求 "app.js" ，客户端接受 br ，响应 "app.js.br" ，
+	// 扩展名见 RegisterEncoder
+	Precompressed bool
+	// SPA 模式，请求的文件不存在时，响应这个文件，比如 "index.html" ，空表示响应 404 。
+	// 最后一个路径元素有扩展名的请求，比如 "/assets/app.js" ，仍然响应 404 。
+	// 如果 HTMLCacheControl 为空，设置为 "no-cache" ，如果 Immutable 为 nil ，设置为 IsFingerprinted 。
+	Fallback string
+	// 不使用 Fallback 的请求路径前缀，比如 "/api" ，响应 404
+	FallbackExclude []string
+	// 文件缓存，nil 表示不缓存，可以和其他 FSHandler 共享
+	Cache *StaticCache
+	// 不大于它的文件才被缓存，<=0 表示 1MB
+	CacheFileSize int64
+}
+
+// ImmutableCacheControl 是带指纹的文件的 Cache-Control 头
+const ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+// IsFingerprinted 判断 name 是否带指纹，比如 "app.3f2a9c1b.js" 和 "app-BRx7a_2c.js" ，
+// 即去掉扩展名后，最后一个 "." 或 "-" 之后是至少 8 个字母，数字或者 "_" ，并且包含数字。
+func IsFingerprinted(name string) bool {
+	name = path.Base(name)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	i := strings.LastIndexAny(name, ".-")
+	if i < 0 {
+		return false
+	}
+	hash := name[i+1:]
+	if len(hash) < 8 {
+		return false
+	}
+	digit := false
+	for _, c := range hash {
+		switch {
+		case c >= '0' && c <= '9':
+			digit = true
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		default:
+			return false
+		}
+	}
+	return digit
+}
+
+// FSHandler 用于处理 fs.FS 中的静态文件，比如 embed.FS 和 os.DirFS 。
+// 每次请求都会打开文件，所以新增的文件也能访问，使用 StaticOptions.Cache 时，见 StaticCache 。
+// 文件路径是最后一个路由参数，一般注册在 "*" 路由上，没有参数表示根目录。
+type FSHandler struct {
+	fs  fs.FS
+	opt StaticOptions
+}
+
+// NewFSHandler 返回一个处理 fsys 的 FSHandler ，opt 为 nil 表示使用默认选项
+func NewFSHandler(fsys fs.FS, opt *StaticOptions) *FSHandler {
+	h := &FSHandler{fs: fsys}
+	if opt != nil {
+		h.opt = *opt
+	}
+	if h.opt.Index == "" {
+		h.opt.Index = "index.html"
+	}
+	if h.opt.Fallback != "" {
+		if h.opt.HTMLCacheControl == "" {
+			h.opt.HTMLCacheControl = "no-cache"
+		}
+		// SPA 的打包文件一般带指纹
+		if h.opt.Immutable == nil {
+			h.opt.Immutable = IsFingerprinted
+		}
+	}
+	if h.opt.CacheFileSize <= 0 {
+		h.opt.CacheFileSize = 1 << 20
+	}
+	return h
+}
+
+// Handle 处理
+func (h *FSHandler) Handle(ctx *Context) {
+	name := ""
+	if len(ctx.Param) > 0 {
+		name = ctx.Param[len(ctx.Param)-1]
+	}
+	name, ok := h.name(name)
+	if !ok {
+		http.NotFound(ctx.ResponseWriter, ctx.Request)
+		return
+	}
+	if h.opt.Cache != nil {
+		if c := h.opt.Cache.fresh(h, name); c != nil {
+			h.serveCache(ctx, name, c)
+			return
+		}
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			if h.opt.Cache != nil {
+				h.opt.Cache.remove(h, name)
+			}
+			if h.fallback(ctx, name) {
+				return
+			}
+		}
+		h.error(ctx, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		h.error(ctx, err)
+		return
+	}
+	if !fi.IsDir() {
+		h.serveFile(ctx, name, f, fi)
+		return
+	}
+	// 目录需要以 "/" 结尾，页面中的相对路径才正确
+	if !strings.HasSuffix(ctx.Request.URL.Path, "/") {
+		u := *ctx.Request.URL
+		u.Path += "/"
+		http.Redirect(ctx.ResponseWriter, ctx.Request, u.RequestURI(), http.StatusMovedPermanently)
+		return
+	}
+	indexName := path.Join(name, h.opt.Index)
+	index, err := h.fs.Open(indexName)
+	if err == nil {
+		defer index.Close()
+		fi, err := index.Stat()
+		if err == nil && !fi.IsDir() {
+			h.serveFile(ctx, indexName, index, fi)
+			return
+		}
+	}
+	if !h.opt.Browse {
+		if !h.fallback(ctx, name) {
+			http.NotFound(ctx.ResponseWriter, ctx.Request)
+		}
+		return
+	}
+	h.serveDir(ctx, name)
+}
+
+// fallback 在 SPA 模式下响应 Fallback 文件，返回 false 表示不使用 Fallback
+func (h *FSHandler) fallback(ctx *Context, name string) bool {
+	if h.opt.Fallback == "" || (name != "." && path.Ext(name) != "") {
+		return false
+	}
+	p := ctx.Request.URL.Path
+	for _, prefix := range h.opt.FallbackExclude {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return false
+		}
+	}
+	f, err := h.fs.Open(h.opt.Fallback)
+	if err != nil {
+		h.error(ctx, err)
+		return true
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		h.error(ctx, err)
+		return true
+	}
+	h.serveFile(ctx, h.opt.Fallback, f, fi)
+	return true
+}
+
+// cacheControl 返回文件 name 的 Cache-Control 头
+func (h *FSHandler) cacheControl(name string) string {
+	if h.opt.Immutable != nil && h.opt.Immutable(name) {
+		return ImmutableCacheControl
+	}
+	switch path.Ext(name) {
+	case ".html", ".htm":
+		if h.opt.HTMLCacheControl != "" {
+			return h.opt.HTMLCacheControl
+		}
+	}
+	return h.opt.CacheControl
+}
+
+// name 检查并返回 fs.FS 中的文件名，路径穿越和不允许访问的隐藏文件返回 false
+func (h *FSHandler) name(name string) (string, bool) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return ".", true
+	}
+	// fs.ValidPath 不允许 "." 和 ".." 以及空的路径元素
+	if !fs.ValidPath(name) || strings.Contains(name, "\\") {
+		return "", false
+	}
+	if !h.opt.ShowHidden {
+		for _, s := range strings.Split(name, "/") {
+			if s[0] == '.' {
+				return "", false
+			}
+		}
+	}
+	return name, true
+}
+
+// error 根据 err 响应 404 ，403 或者 500
+func (h *FSHandler) error(ctx *Context, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(ctx.ResponseWriter, ctx.Request)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(ctx.ResponseWriter, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(ctx.ResponseWriter, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// serveFile 响应文件 name ，支持 Range 和 If-Modified-Since
+func (h *FSHandler) serveFile(ctx *Context, name string, f fs.File, fi fs.FileInfo) {
+	if h.opt.Cache != nil {
+		c, err := h.opt.Cache.load(h, name, fi)
+		if err != nil {
+			h.error(ctx, err)
+			return
+		}
+		if c != nil {
+			h.serveCache(ctx, name, c)
+			return
+		}
+	}
+	if s := h.cacheControl(name); s != "" {
+		ctx.ResponseWriter.Header().Set("Cache-Control", s)
+	}
+	if h.opt.Precompressed {
+		ctx.ResponseWriter.Header().Add("Vary", "Accept-Encoding")
+		if h.servePrecompressed(ctx, name) {
+			return
+		}
+		if !acceptIdentity(ctx.Request.Header.Get("Accept-Encoding")) {
+			http.Error(ctx.ResponseWriter, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+			return
+		}
+	}
+	h.serveContent(ctx, f, fi)
+}
+
+// serveCache 使用缓存响应文件 name
+func (h *FSHandler) serveCache(ctx *Context, name string, c *CacheHandler) {
+	if s := h.cacheControl(name); s != "" {
+		ctx.ResponseWriter.Header().Set("Cache-Control", s)
+	}
+	c.Handle(ctx)
+}
+
+// servePrecompressed 响应客户端接受的预压缩文件，返回 false 表示没有
+func (h *FSHandler) servePrecompressed(ctx *Context, name string) bool {
+	// 压缩的数据不能判断类型
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		return false
+	}
+	var f fs.File
+	var fi fs.FileInfo
+	e := negotiateEncoding(ctx.Request.Header.Get("Accept-Encoding"), func(e *encoding) bool {
+		if e.ext == "" {
+			return false
+		}
+		ef, err := h.fs.Open(name + e.ext)
+		if err != nil {
+			return false
+		}
+		efi, err := ef.Stat()
+		if err != nil || efi.IsDir() {
+			ef.Close()
+			return false
+		}
+		// 优先级更高的
+		if f != nil {
+			f.Close()
+		}
+		f, fi = ef, efi
+		return true
+	})
+	if e == nil {
+		if f != nil {
+			f.Close()
+		}
+		return false
+	}
+	defer f.Close()
+	header := ctx.ResponseWriter.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Encoding", e.name)
+	h.serveContent(ctx, f, fi)
+	return true
+}
+
+// serveContent 响应 f 的数据
+func (h *FSHandler) serveContent(ctx *Context, f fs.File, fi fs.FileInfo) {
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		// 不能 Seek 的文件，读到内存中
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			h.error(ctx, err)
+			return
+		}
+		rs = bytes.NewReader(data)
+	}
+	http.ServeContent(ctx.ResponseWriter, ctx.Request, fi.Name(), fi.ModTime(), rs)
+}
+
+// serveDir 列出目录的文件，不包括隐藏文件
+func (h *FSHandler) serveDir(ctx *Context, name string) {
+	entries, err := fs.ReadDir(h.fs, name)
+	if err != nil {
+		h.error(ctx, err)
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString("<pre>\n")
+	for _, e := range entries {
+		s := e.Name()
+		if !h.opt.ShowHidden && s[0] == '.' {
+			continue
+		}
+		if e.IsDir() {
+			s += "/"
+		}
+		u := url.URL{Path: s}
+		fmt.Fprintf(&buf, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(s))
+	}
+	buf.WriteString("</pre>\n")
+	ctx.ResponseWriter.Header().Set(contentType, ContentTypeHTML)
+	ctx.ResponseWriter.WriteHeader(http.StatusOK)
+	ctx.ResponseWriter.Write(buf.Bytes())
+}
@@ -0,0 +1,210 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testServe(h http.Handler, method, url string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func Test_Router_StaticFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":     {Data: []byte("index")},
+		"a.css":          {Data: []byte("css")},
+		"dir/b.txt":      {Data: []byte("b")},
+		"dir/.hide":      {Data: []byte("hide")},
+		".git/config":    {Data: []byte("config")},
+		"sub/index.html": {Data: []byte("sub index")},
+	}
+	r := NewRootRouter()
+	r.StaticFS("/static", fsys, &StaticOptions{Browse: true, CacheControl: "max-age=60"})
+	r.GET("/static2", func(ctx *Context) {})
+	for _, c := range []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/static/a.css", 200, "css"},
+		{"/static/", 200, "index"},
+		{"/static/sub/", 200, "sub index"},
+		{"/static/dir/b.txt", 200, "b"},
+		{"/static/dir/", 200, "<pre>\n<a href=\"b.txt\">b.txt</a>\n</pre>\n"},
+		{"/static/none", 404, ""},
+		{"/static/dir/.hide", 404, ""},
+		{"/static/.git/config", 404, ""},
+		{"/static/../router.go", 404, ""},
+		{"/static/dir/../a.css", 404, ""},
+		{"/static/dir\\b.txt", 404, ""},
+	} {
+		res := testServe(r, http.MethodGet, c.url, nil)
+		if res.Code != c.status {
+			t.Fatal(c.url, res.Code)
+		}
+		if c.body != "" && res.Body.String() != c.body {
+			t.Fatal(c.url, res.Body.String())
+		}
+		// Not a listing.
+		if c.status == 200 && !strings.HasPrefix(c.body, "<pre>") && res.Header().Get("Cache-Control") != "max-age=60" {
+			t.Fatal(c.url, res.Header())
+		}
+	}
+	// Redirect directory.
+	for url, location := range map[string]string{
+		"/static":        "/static/",
+		"/static/sub?id": "/static/sub/?id",
+	} {
+		res := testServe(r, http.MethodGet, url, nil)
+		if res.Code != http.StatusMovedPermanently || res.Header().Get("Location") != location {
+			t.Fatal(url, res.Code, res.Header())
+		}
+	}
+	// New file.
+	fsys["new.js"] = &fstest.MapFile{Data: []byte("new")}
+	res := testServe(r, http.MethodGet, "/static/new.js", nil)
+	if res.Code != 200 || res.Body.String() != "new" || !strings.HasPrefix(res.Header().Get("Content-Type"), "text/javascript") {
+		t.Fatal(res.Code, res.Header(), res.Body.String())
+	}
+	// Range and HEAD.
+	res = testServe(r, http.MethodGet, "/static/index.html", http.Header{"Range": []string{"bytes=1-2"}})
+	if res.Code != http.StatusPartialContent || res.Body.String() != "nd" {
+		t.Fatal(res.Code, res.Body.String())
+	}
+	res = testServe(r, http.MethodHead, "/static/a.css", nil)
+	if res.Code != 200 || res.Header().Get("Content-Length") != "3" {
+		t.Fatal(res.Code, res.Header())
+	}
+	// Browse is disabled, hidden files are allowed.
+	r = NewRootRouter()
+	r.StaticFS("/", fsys, &StaticOptions{ShowHidden: true})
+	res = testServe(r, http.MethodGet, "/dir/", nil)
+	if res.Code != 404 {
+		t.Fatal(res.Code)
+	}
+	res = testServe(r, http.MethodGet, "/.git/config", nil)
+	if res.Code != 200 || res.Body.String() != "config" {
+		t.Fatal(res.Code)
+	}
+	res = testServe(r, http.MethodGet, "/", nil)
+	if res.Code != 200 || res.Body.String() != "index" {
+		t.Fatal(res.Code)
+	}
+}
+
+func Test_Router_StaticFS_SPA(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":             {Data: []byte("shell")},
+		"assets/app.3f2a9c1b.js": {Data: []byte("app")},
+		"assets/logo.png":        {Data: []byte("logo")},
+		"docs/readme.txt":        {Data: []byte("readme")},
+	}
+	r := NewRootRouter()
+	r.StaticFS("/app", fsys, &StaticOptions{
+		CacheControl:    "max-age=60",
+		Immutable:       IsFingerprinted,
+		Fallback:        "index.html",
+		FallbackExclude: []string{"/app/api/"},
+	})
+	for _, c := range []struct {
+		url, body, cache string
+		status           int
+	}{
+		{"/app/", "shell", "no-cache", 200},
+		{"/app/settings/profile", "shell", "no-cache", 200},
+		{"/app/docs/", "shell", "no-cache", 200},
+		{"/app/assets/app.3f2a9c1b.js", "app", ImmutableCacheControl, 200},
+		{"/app/assets/logo.png", "logo", "max-age=60", 200},
+		{"/app/assets/none.js", "", "", 404},
+		{"/app/api", "", "", 404},
+		{"/app/api/users", "", "", 404},
+		{"/app/apis", "shell", "no-cache", 200},
+	} {
+		res := testServe(r, http.MethodGet, c.url, nil)
+		if res.Code != c.status {
+			t.Fatal(c.url, res.Code)
+		}
+		if c.status != 200 {
+			continue
+		}
+		if res.Body.String() != c.body || res.Header().Get("Cache-Control") != c.cache {
+			t.Fatal(c.url, res.Body.String(), res.Header())
+		}
+	}
+	// Immutable is IsFingerprinted by default.
+	r = NewRootRouter()
+	r.StaticFS("/app", fsys, &StaticOptions{CacheControl: "max-age=60", Fallback: "index.html"})
+	for url, cache := range map[string]string{
+		"/app/assets/app.3f2a9c1b.js": ImmutableCacheControl,
+		"/app/assets/logo.png":        "max-age=60",
+	} {
+		res := testServe(r, http.MethodGet, url, nil)
+		if res.Code != 200 || res.Header().Get("Cache-Control") != cache {
+			t.Fatal(url, res.Code, res.Header())
+		}
+	}
+}
+
+func Test_IsFingerprinted(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"app.3f2a9c1b.js":         true,
+		"/assets/app-BRx7a_2c.js": true,
+		"app.js":                  false,
+		"jquery-3.6.0.min.js":     false,
+		"my-component.js":         false,
+		"app.3f2a9c.js":           false,
+	} {
+		if IsFingerprinted(name) != ok {
+			t.Error(name)
+		}
+	}
+}
+
+func Test_Router_StaticFS_Precompressed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":     {Data: []byte("app")},
+		"app.js.gz":  {Data: []byte("gz")},
+		"app.js.br":  {Data: []byte("br")},
+		"index.html": {Data: []byte("index")},
+		"data":       {Data: []byte("data")},
+		"data.gz":    {Data: []byte("data gz")},
+	}
+	r := NewRootRouter()
+	r.StaticFS("/", fsys, &StaticOptions{Precompressed: true})
+	for _, c := range []struct {
+		url, accept, encoding, body string
+	}{
+		{"/app.js", "", "", "app"},
+		{"/app.js", "gzip, br", "br", "br"},
+		{"/app.js", "gzip, br;q=0.5", "gzip", "gz"},
+		{"/app.js", "deflate", "", "app"},
+		{"/", "gzip", "", "index"},
+		// Unknown type.
+		{"/data", "gzip", "", "data"},
+	} {
+		res := testServe(r, http.MethodGet, c.url, http.Header{"Accept-Encoding": []string{c.accept}})
+		if res.Code != 200 || res.Body.String() != c.body || res.Header().Get("Content-Encoding") != c.encoding {
+			t.Fatal(c, res.Code, res.Header(), res.Body.String())
+		}
+		if res.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatal(c, res.Header())
+		}
+		if c.url == "/app.js" && !strings.HasPrefix(res.Header().Get("Content-Type"), "text/javascript") {
+			t.Fatal(c, res.Header())
+		}
+	}
+	// Nothing is acceptable.
+	res := testServe(r, http.MethodGet, "/app.js", http.Header{"Accept-Encoding": []string{"deflate, identity;q=0"}})
+	if res.Code != http.StatusNotAcceptable || res.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatal(res.Code, res.Header())
+	}
+}
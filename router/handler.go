@@ -2,51 +2,22 @@ package router
 
 import (
 	"bytes"
-	"compress/flate"
-	"compress/gzip"
-	"compress/zlib"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 )
 
 // HandleFunc 表示 Router 的回调函数
 type HandleFunc func(ctx *Context)
 
-var (
-	errSeekOffset = errors.New("seek: invalid offset")
-	// Create compressor functions.
-	compressFunc = []func(io.Writer) io.WriteCloser{
-		func(w io.Writer) io.WriteCloser {
-			return gzip.NewWriter(w)
-		},
-		func(w io.Writer) io.WriteCloser {
-			return zlib.NewWriter(w)
-		},
-		func(w io.Writer) io.WriteCloser {
-			wc, _ := flate.NewWriter(w, flate.DefaultCompression)
-			return wc
-		},
-	}
-	compressName = []string{
-		"gzip",
-		"zlib",
-		"deflate",
-	}
-)
-
-const (
-	gzipCompress = iota
-	zlibCompress
-	deflateCompress
-)
+var errSeekOffset = errors.New("seek: invalid offset")
 
 // FileHandler 用于静态文件处理
 type FileHandler string
@@ -88,58 +59,89 @@ func (s *dataSeeker) Read(p []byte) (int, error) {
 	return n, nil
 }
 
-// CacheHandler 用于处理缓存数据
+// CacheHandler 用于处理缓存数据，创建时使用注册的压缩器压缩数据，
+// 根据 Accept-Encoding 响应压缩的数据。
+// 响应 ETag 头，支持 If-Match ，If-None-Match ，If-Modified-Since 和 Range 。
 type CacheHandler struct {
-	contentType    string
-	modTime        time.Time
-	data           []byte
-	compressedData [3][]byte
+	contentType string
+	modTime     time.Time
+	data        []byte
+	// data 的强 ETag ，压缩的数据使用弱 ETag
+	etag string
+	// 压缩的数据，只保存比 data 小的
+	encoded map[string][]byte
 }
 
 // Handle 处理
 func (h *CacheHandler) Handle(ctx *Context) {
-	if h.contentType != "" {
-		ctx.ResponseWriter.Header().Add("Content-Type", h.contentType)
-	}
-	for _, s := range strings.Split(ctx.Request.Header.Get("Accept-Encoding"), ",") {
-		switch s {
-		case "*", "gzip":
-			h.serveContent(ctx, gzipCompress)
-			return
-		case "zlib":
-			h.serveContent(ctx, zlibCompress)
-			return
-		case "deflate":
-			h.serveContent(ctx, deflateCompress)
-			return
-		default:
+	header := ctx.ResponseWriter.Header()
+	header.Add("Vary", "Accept-Encoding")
+	accept := ctx.Request.Header.Get("Accept-Encoding")
+	e := negotiateEncoding(accept, func(e *encoding) bool {
+		return h.encoded[e.name] != nil
+	})
+	if e == nil && !acceptIdentity(accept) {
+		http.Error(ctx.ResponseWriter, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+		return
+	}
+	header.Set("Content-Type", h.contentType)
+	header.Set("ETag", h.etag)
+	data := h.data
+	if e != nil {
+		header.Set("Content-Encoding", e.name)
+		header.Set("ETag", weakETag(h.etag))
+		data = h.encoded[e.name]
+	}
+	http.ServeContent(ctx.ResponseWriter, ctx.Request, "", h.modTime, &dataSeeker{b: data})
+}
+
+// NewCacheHandler 创建一个缓存处理器，name 用于判断 Content-Type ，
+// data 被注册的压缩器压缩，压缩失败返回错误。
+func NewCacheHandler(name string, modTime time.Time, data []byte) (*CacheHandler, error) {
+	h := &CacheHandler{
+		contentType: mime.TypeByExtension(filepath.Ext(name)),
+		modTime:     modTime,
+		data:        data,
+		etag:        hashETag(data),
+		encoded:     make(map[string][]byte),
+	}
+	if h.contentType == "" {
+		h.contentType = http.DetectContentType(data)
+	}
+	for _, e := range registeredEncodings() {
+		if e.encoder == nil {
 			continue
 		}
+		var buf bytes.Buffer
+		w, err := e.encoder(&buf)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+		h.encoded[e.name] = buf.Bytes()
 	}
-	// Handler does not has client compressions.
-	http.ServeContent(ctx.ResponseWriter, ctx.Request, "", h.modTime, &dataSeeker{b: h.data})
+	h.keepSmaller()
+	return h, nil
 }
 
-func (h *CacheHandler) serveContent(ctx *Context, n int) {
-	// Compress data if is empty.
-	if len(h.compressedData[n]) < 1 {
-		var buf bytes.Buffer
-		w := compressFunc[n](&buf)
-		w.Write(h.data)
-		w.Close()
-		h.compressedData[n] = append(h.compressedData[n], buf.Bytes()...)
-	}
-	// Response compressed data.
-	if len(h.compressedData[n]) < len(h.data) {
-		ctx.ResponseWriter.Header().Add("Content-Encoding", compressName[n])
-		http.ServeContent(ctx.ResponseWriter, ctx.Request, "", h.modTime, &dataSeeker{b: h.compressedData[n]})
-		return
+// keepSmaller 删除不比原数据小的压缩数据
+func (h *CacheHandler) keepSmaller() {
+	for name, b := range h.encoded {
+		if len(b) >= len(h.data) {
+			delete(h.encoded, name)
+		}
 	}
-	// Response origin data.
-	http.ServeContent(ctx.ResponseWriter, ctx.Request, "", h.modTime, &dataSeeker{b: h.data})
 }
 
-// NewCacheHandlerFromFile 从静态文件中创建一个缓存处理器
+// NewCacheHandlerFromFile 从静态文件中创建一个缓存处理器，
+// 如果有预压缩的文件，比如 file.gz 和 file.br ，使用它们代替压缩器的数据。
 func NewCacheHandlerFromFile(file string) (*CacheHandler, error) {
 	fileInfo, err := os.Stat(file)
 	if err != nil {
@@ -154,9 +156,39 @@ func NewCacheHandlerFromFile(file string) (*CacheHandler, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &CacheHandler{
-		contentType: mime.TypeByExtension(filepath.Ext(file)),
-		modTime:     fileInfo.ModTime(),
-		data:        data,
-	}, nil
+	h, err := NewCacheHandler(file, fileInfo.ModTime(), data)
+	if err != nil {
+		return nil, err
+	}
+	err = h.loadPrecompressed(file, ioutil.ReadFile)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// loadPrecompressed 使用 read 读取 name 的预压缩文件，比如 name.gz 和 name.br ，代替压缩器的数据
+func (h *CacheHandler) loadPrecompressed(name string, read func(string) ([]byte, error)) error {
+	for _, e := range registeredEncodings() {
+		if e.ext == "" {
+			continue
+		}
+		b, err := read(name + e.ext)
+		if err == nil {
+			h.encoded[e.name] = b
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	h.keepSmaller()
+	return nil
+}
+
+// size 返回缓存的字节数
+func (h *CacheHandler) size() int64 {
+	n := int64(len(h.data))
+	for _, b := range h.encoded {
+		n += int64(len(b))
+	}
+	return n
 }
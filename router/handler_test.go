@@ -0,0 +1,108 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CacheHandler(t *testing.T) {
+	data := []byte(strings.Repeat("hello world ", 100))
+	h, err := NewCacheHandler("a.txt", time.Now(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRootRouter()
+	r.GET("/a.txt", h.Handle)
+	// Identity.
+	res := testServe(r, http.MethodGet, "/a.txt", nil)
+	if res.Body.String() != string(data) || res.Header().Get("Content-Encoding") != "" || res.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatal(res.Header())
+	}
+	if !strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain") {
+		t.Fatal(res.Header())
+	}
+	// Gzip, br is not available.
+	res = testServe(r, http.MethodGet, "/a.txt", http.Header{"Accept-Encoding": []string{"br, gzip;q=0.8, deflate;q=0.5"}})
+	if res.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal(res.Header())
+	}
+	gr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(gr)
+	if !bytes.Equal(b, data) {
+		t.Fatal(string(b))
+	}
+	// Deflate is zlib format.
+	res = testServe(r, http.MethodGet, "/a.txt", http.Header{"Accept-Encoding": []string{"deflate"}})
+	if res.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatal(res.Header())
+	}
+	zr, err := zlib.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadAll(zr)
+	if err != nil || !bytes.Equal(b, data) {
+		t.Fatal(err, string(b))
+	}
+	// Nothing is acceptable.
+	res = testServe(r, http.MethodGet, "/a.txt", http.Header{"Accept-Encoding": []string{"zlib, identity;q=0"}})
+	if res.Code != http.StatusNotAcceptable || res.Header().Get("Vary") != "Accept-Encoding" || res.Header().Get("ETag") != "" {
+		t.Fatal(res.Code, res.Header())
+	}
+	// Small data is not compressed.
+	h, err = NewCacheHandler("b.txt", time.Now(), []byte("b"))
+	if err != nil || len(h.encoded) != 0 {
+		t.Fatal(h.encoded, err)
+	}
+	// Still negotiable.
+	r.GET("/b.txt", h.Handle)
+	res = testServe(r, http.MethodGet, "/b.txt", nil)
+	if res.Body.String() != "b" || res.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatal(res.Header())
+	}
+	res = testServe(r, http.MethodGet, "/b.txt", http.Header{"Accept-Encoding": []string{"gzip, *;q=0"}})
+	if res.Code != http.StatusNotAcceptable {
+		t.Fatal(res.Code)
+	}
+}
+
+func Test_NewCacheHandlerFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "a.js")
+	err = ioutil.WriteFile(file, []byte(strings.Repeat("var a = 1;\n", 100)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file+".br", []byte("br data"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewCacheHandlerFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRootRouter()
+	r.GET("/a.js", h.Handle)
+	res := testServe(r, http.MethodGet, "/a.js", http.Header{"Accept-Encoding": []string{"gzip, br"}})
+	if res.Header().Get("Content-Encoding") != "br" || res.Body.String() != "br data" {
+		t.Fatal(res.Header(), res.Body.String())
+	}
+	if !strings.HasPrefix(res.Header().Get("Content-Type"), "text/javascript") {
+		t.Fatal(res.Header())
+	}
+}
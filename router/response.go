@@ -0,0 +1,155 @@
+package router
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Response 包装 http.ResponseWriter ，记录状态码，是否已经写了头，body 的字节数和创建的时间。
+// 它实现了 http.Flusher ，http.Hijacker ，http.Pusher 和 io.ReaderFrom ，
+// 调用被传递给原来的 http.ResponseWriter ，不支持时 Hijack 和 Push 返回 http.ErrNotSupported ，
+// Flush 什么也不做。使用 Context.Writer 获取。
+type Response struct {
+	http.ResponseWriter
+	status   int
+	written  bool
+	size     int64
+	start    time.Time
+	hijacked bool
+	// 写头之前的回调
+	before []func(*Response)
+}
+
+// NewResponse 返回包装 w 的 Response
+func NewResponse(w http.ResponseWriter) *Response {
+	return &Response{ResponseWriter: w, start: time.Now()}
+}
+
+// Status 返回写的状态码，Hijack 以后是 101 ，0 表示还没有写
+func (r *Response) Status() int {
+	if r.status == 0 && r.hijacked {
+		return http.StatusSwitchingProtocols
+	}
+	return r.status
+}
+
+// Written 返回是否已经写了头，之后设置的头无效
+func (r *Response) Written() bool {
+	return r.written || r.hijacked
+}
+
+// Size 返回写的 body 的字节数
+func (r *Response) Size() int64 {
+	return r.size
+}
+
+// Start 返回创建的时间
+func (r *Response) Start() time.Time {
+	return r.start
+}
+
+// Hijacked 返回是否已经 Hijack
+func (r *Response) Hijacked() bool {
+	return r.hijacked
+}
+
+// Before 添加一个写头之前的回调，按照添加的顺序调用，用于延迟设置头，
+// 比如根据 Status 设置 Cache-Control 。已经写了头再添加的不会被调用。
+func (r *Response) Before(f func(r *Response)) {
+	r.before = append(r.before, f)
+}
+
+// Unwrap 返回原来的 http.ResponseWriter ，用于 http.ResponseController
+func (r *Response) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// WriteHeader 写头，只有第一次有效，1xx 的状态码除外
+func (r *Response) WriteHeader(statusCode int) {
+	if r.written || r.hijacked {
+		return
+	}
+	// 1xx 可以写多次
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		r.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	r.status = statusCode
+	r.written = true
+	for _, f := range r.before {
+		f(r)
+	}
+	r.before = nil
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write 写 body ，没有写头就先写 200
+func (r *Response) Write(b []byte) (int, error) {
+	if !r.written {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// WriteString 实现 io.StringWriter
+func (r *Response) WriteString(s string) (int, error) {
+	if !r.written {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := io.WriteString(r.ResponseWriter, s)
+	r.size += int64(n)
+	return n, err
+}
+
+// ReadFrom 实现 io.ReaderFrom ，比如 net/http 可以使用 sendfile
+func (r *Response) ReadFrom(src io.Reader) (int64, error) {
+	if !r.written {
+		r.WriteHeader(http.StatusOK)
+	}
+	var n int64
+	var err error
+	if rf, ok := r.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(r.ResponseWriter, src)
+	}
+	r.size += n
+	return n, err
+}
+
+// Flush 实现 http.Flusher ，没有写头就先写 200
+func (r *Response) Flush() {
+	if !r.written {
+		r.WriteHeader(http.StatusOK)
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack 实现 http.Hijacker ，用于 WebSocket
+func (r *Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		r.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Push 实现 http.Pusher
+func (r *Response) Push(target string, opts *http.PushOptions) error {
+	p, ok := r.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
@@ -0,0 +1,91 @@
+package router
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Response(t *testing.T) {
+	var res *Response
+	r := NewRootRouter()
+	r.Intercept(func(ctx *Context) {
+		w := ctx.Writer()
+		if w != ctx.Writer() || w.Written() || w.Status() != 0 {
+			t.Fatal(w)
+		}
+		w.Before(func(w *Response) {
+			if w.Status() == http.StatusOK {
+				w.Header().Set("Cache-Control", "max-age=60")
+			}
+		})
+		ctx.Handle()
+		res = w
+	})
+	r.GET("/ok", func(ctx *Context) {
+		io.WriteString(ctx.ResponseWriter, "hello")
+		ctx.ResponseWriter.(io.ReaderFrom).ReadFrom(strings.NewReader(" world"))
+		ctx.ResponseWriter.(http.Flusher).Flush()
+		// Ignored.
+		ctx.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+		ctx.ResponseWriter.Header().Set("X-Late", "1")
+		if err := ctx.ResponseWriter.(http.Pusher).Push("/a.js", nil); err != http.ErrNotSupported {
+			t.Fatal(err)
+		}
+	})
+	r.GET("/created", func(ctx *Context) {
+		ctx.WriteHeader(http.StatusCreated)
+	})
+	rec := testServe(r, http.MethodGet, "/ok", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello world" || !rec.Flushed {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Cache-Control") != "max-age=60" || rec.Result().Header.Get("X-Late") != "" {
+		t.Fatal(rec.Result().Header)
+	}
+	if !res.Written() || res.Status() != http.StatusOK || res.Size() != 11 || res.Start().IsZero() {
+		t.Fatal(res)
+	}
+	rec = testServe(r, http.MethodGet, "/created", nil)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Cache-Control") != "" {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	if res.Status() != http.StatusCreated || res.Size() != 0 {
+		t.Fatal(res)
+	}
+}
+
+func Test_Response_Hijack(t *testing.T) {
+	done := make(chan *Response, 1)
+	r := NewRootRouter()
+	r.GET("/", func(ctx *Context) {
+		w := ctx.Writer()
+		conn, rw, err := ctx.ResponseWriter.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi")
+		rw.Flush()
+		done <- w
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(data) != "hi" {
+		t.Fatal(string(data))
+	}
+	w := <-done
+	if !w.Hijacked() || !w.Written() || w.Status() != http.StatusSwitchingProtocols {
+		t.Fatal(w)
+	}
+}
@@ -11,6 +11,7 @@ const paramChars = holderChar + anyChar
 
 type route struct {
 	handleFunc  []HandleFunc
+	pattern     string
 	path        string
 	staticChild []*route
 	paramChild  *route
@@ -19,15 +20,15 @@ type route struct {
 
 func (r *route) Match(ctx *Context) *route {
 	_path := ctx.Request.URL.Path
+	ctx.Param = ctx.Param[:0]
 	// root
 	if len(_path) < len(r.path) || _path[:len(r.path)] != r.path {
 		return nil
 	}
 	_path = _path[len(r.path):]
 	if _path == "" {
-		return r
+		return r.exact(ctx)
 	}
-	ctx.Param = ctx.Param[:0]
 	idx := 0
 	route := r
 Loop:
@@ -38,7 +39,7 @@ Loop:
 			}
 			_path = _path[len(child.path):]
 			if _path == "" {
-				return child
+				return child.exact(ctx)
 			}
 			route = child
 			continue Loop
@@ -53,7 +54,7 @@ Loop:
 			// skip '/'
 			_path = _path[idx+1:]
 			if _path == "" {
-				return route.paramChild
+				return route.paramChild.exact(ctx)
 			}
 			route = route.paramChild
 			continue Loop
@@ -66,6 +67,15 @@ Loop:
 	}
 }
 
+// Return r if it has handlers, or "*" child which matches empty path, "/static/" matches "/static/*".
+func (r *route) exact(ctx *Context) *route {
+	if r.handleFunc == nil && r.anyChild != nil {
+		ctx.Param = append(ctx.Param, "")
+		return r.anyChild
+	}
+	return r
+}
+
 func (r *route) Add(routePath string, handle ...HandleFunc) {
 	_routePath := path.Clean(path.Join("/", routePath))
 	//
@@ -109,6 +119,7 @@ func (r *route) Add(routePath string, handle ...HandleFunc) {
 		}
 	}
 	current.handleFunc = handle
+	current.pattern = path.Clean(path.Join("/", routePath))
 }
 
 func (r *route) addStatic(routePath string) *route {
@@ -137,12 +148,14 @@ func (r *route) addStatic(routePath string) *route {
 	if diff2 == "" {
 		child := new(route)
 		child.handleFunc = r.handleFunc
+		child.pattern = r.pattern
 		child.path = r.path[len(routePath):]
 		child.staticChild = r.staticChild
 		child.paramChild = r.paramChild
 		child.anyChild = r.anyChild
 		//
 		r.handleFunc = nil
+		r.pattern = ""
 		r.path = routePath
 		r.staticChild = make([]*route, 1)
 		r.staticChild[0] = child
@@ -164,6 +177,7 @@ func (r *route) addStatic(routePath string) *route {
 	// case 4, r.path="/abc", routePath="/abd", diff1="c", diff2="d".
 	child1 := new(route)
 	child1.handleFunc = r.handleFunc
+	child1.pattern = r.pattern
 	child1.path = diff1
 	child1.staticChild = r.staticChild
 	child1.paramChild = r.paramChild
@@ -175,6 +189,7 @@ func (r *route) addStatic(routePath string) *route {
 	child2.staticChild = make([]*route, 0)
 	//
 	r.handleFunc = nil
+	r.pattern = ""
 	r.path = r.path[:len(r.path)-len(diff1)]
 	r.staticChild = make([]*route, 2)
 	r.staticChild[0] = child1
@@ -58,7 +58,34 @@ func Test_Route_Add_Match(t *testing.T) {
 	r.Add("/b/b/*")
 	c.Request.URL.Path = "/b/b/1/2"
 	test_Fail(t, r.Match(c) == nil, len(c.Param) != 1 || c.Param[0] != "1/2")
+	// "*" matches empty path.
+	c.Request.URL.Path = "/b/b/"
+	test_Fail(t, r.Match(c) == nil, len(c.Param) != 1 || c.Param[0] != "")
 	r.Add("/b/?/?/b")
 	c.Request.URL.Path = "/b/1/2/b"
 	test_Fail(t, r.Match(c) == nil, len(c.Param) != 2 || c.Param[0] != "1" || c.Param[1] != "2")
 }
+
+func Test_Route_Pattern(t *testing.T) {
+	r := new(route)
+	c := new(Context)
+	c.Request = new(http.Request)
+	c.Request.URL = new(url.URL)
+	h := func(ctx *Context) {}
+	for _, p := range []string{"/abc", "/abd", "/a/?", "/a/?/*", "users/?/"} {
+		r.Add(p, h)
+	}
+	for p, pattern := range map[string]string{
+		"/abc":     "/abc",
+		"/abd":     "/abd",
+		"/a/1":     "/a/?",
+		"/a/1/2/3": "/a/?/*",
+		"/users/1": "/users/?",
+	} {
+		c.Request.URL.Path = p
+		route := r.Match(c)
+		if route == nil || route.pattern != pattern {
+			t.Fatal(p, route)
+		}
+	}
+}
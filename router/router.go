@@ -1,13 +1,16 @@
 package router
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"sync"
+
+	"github.com/qq51529210/web/socket"
 )
 
 const (
@@ -65,9 +68,20 @@ type Router interface {
 	SubRouter(routePath string) Router
 }
 
+// WSRouter is implemented by Root and the Router returned by its SubRouter,
+// use a type assertion to get it from a Router.
+type WSRouter interface {
+	// Accept websocket connections on GET routePath, then socket.Serve them with handler and opt.
+	// Interceptors are called before upgrade, the request is not upgraded if one of them aborts.
+	// Use ContextOf in handler to get Param and TempData.
+	WS(routePath string, handler socket.Handler, opt *socket.ServeOptions)
+}
+
 type router struct {
 	intercept []HandleFunc
 	root      [_METHOD_INVALID]route
+	// Websocket connections served by WS.
+	ws *wsConns
 }
 
 func (r *router) Add(method int, routePath string, handle ...HandleFunc) {
@@ -114,6 +128,10 @@ func (r *router) TRACE(routePath string, handle ...HandleFunc) {
 	r.Add(_METHOD_TRACE, routePath, handle...)
 }
 
+func (r *router) WS(routePath string, handler socket.Handler, opt *socket.ServeOptions) {
+	r.Add(_METHOD_GET, routePath, r.wsHandle(handler, opt))
+}
+
 func (r *router) Intercept(handle ...HandleFunc) {
 	r.intercept = handle
 }
@@ -164,6 +182,10 @@ func (r *subRouter) TRACE(routePath string, handle ...HandleFunc) {
 	r.router.TRACE(path.Join(r.path, routePath), append(r.intercept, handle...)...)
 }
 
+func (r *subRouter) WS(routePath string, handler socket.Handler, opt *socket.ServeOptions) {
+	r.router.GET(path.Join(r.path, routePath), append(r.intercept, r.router.wsHandle(handler, opt))...)
+}
+
 func (r *subRouter) SubRouter(routePath string) Router {
 	return &subRouter{path: path.Join(r.path, routePath), router: r.router}
 }
@@ -175,13 +197,39 @@ type RootRouter interface {
 	// Default handler is http.ResponseWriter.WriteHeader(http.StatusNotFound).
 	NotFound(handle ...HandleFunc)
 	// Handle static files.
-	// If file is directory, add all files in the directory.
-	// If file size less than cache, use CachaHandler, otherwise use FileHandler.
+	// If file is directory, it is the same as StaticFS with os.DirFS(file).
+	// Files not larger than cache are cached in DefaultStaticCache and reloaded after they are changed,
+	// cache <= 0 means no cache.
 	Static(routePath, file string, cache int64)
 }
 
-func NewRootRouter() RootRouter {
+// Root is the RootRouter returned by NewRootRouter, with the methods added after RootRouter.
+// They are not in RootRouter, so that other implementations of RootRouter still compile.
+type Root interface {
+	RootRouter
+	WSRouter
+	// Handle errors reported by Context.Error, such as Context.Render, Context.Err is the error.
+	// Default handler is http.Error with http.StatusInternalServerError.
+	Error(handle ...HandleFunc)
+	// Set the View of Context.Render.
+	View(v *View)
+	// Handle GET and HEAD of routePath and routePath/* with FSHandler of fsys, nil opt means the default.
+	// Files are opened on every request, so new files are served.
+	StaticFS(routePath string, fsys fs.FS, opt *StaticOptions)
+	// Handle GET and HEAD of routePath and routePath/* with the Assets of fsys and return it.
+	// Fingerprinted files are served with ImmutableCacheControl, others with opt.
+	// It panics if fsys can not be read.
+	Assets(routePath string, fsys fs.FS, opt *StaticOptions) *Assets
+	// Refuse new websocket connections with 503, send a close frame of socket.CloseGoingAway
+	// to the connections served by WS, then wait for them to end.
+	// If ctx is done first, the others are closed and it returns ctx.Err().
+	// Shutdown of the web.Server which serves it calls it.
+	Shutdown(ctx context.Context) error
+}
+
+func NewRootRouter() Root {
 	r := new(rootRouter)
+	r.ws = &wsConns{conns: make(map[*socket.Conn]struct{})}
 	r.ctx.New = func() interface{} {
 		return new(Context)
 	}
@@ -190,12 +238,19 @@ func NewRootRouter() RootRouter {
 			ctx.ResponseWriter.WriteHeader(http.StatusNotFound)
 		},
 	}
+	r.error = []HandleFunc{
+		func(ctx *Context) {
+			http.Error(ctx.ResponseWriter, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		},
+	}
 	return r
 }
 
 type rootRouter struct {
 	router
 	notfound []HandleFunc
+	error    []HandleFunc
+	view     *View
 	ctx      sync.Pool
 }
 
@@ -204,6 +259,8 @@ func (r *rootRouter) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	ctx.Request = req
 	ctx.ResponseWriter = res
 	ctx.TempData = nil
+	ctx.Err = nil
+	ctx.root = r
 	ctx.handleIdx = 0
 	//
 	var route *route
@@ -226,10 +283,13 @@ func (r *rootRouter) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	} else if req.Method[1] == 'A' {
 		route = r.root[_METHOD_PATCH].Match(ctx)
 	}
-	if route == nil {
+	// Intermediate route has no handlers.
+	if route == nil || len(route.handleFunc) < 1 {
 		ctx.handleFunc = r.notfound
+		ctx.route = ""
 	} else {
 		ctx.handleFunc = route.handleFunc
+		ctx.route = route.pattern
 	}
 	ctx.handle()
 	r.ctx.Put(ctx)
@@ -241,6 +301,48 @@ func (r *rootRouter) NotFound(handle ...HandleFunc) {
 	}
 }
 
+func (r *rootRouter) Error(handle ...HandleFunc) {
+	if len(handle) != 0 {
+		r.error = handle
+	}
+}
+
+func (r *rootRouter) View(v *View) {
+	r.view = v
+}
+
+func (r *rootRouter) StaticFS(routePath string, fsys fs.FS, opt *StaticOptions) {
+	r.handleFS(routePath, NewFSHandler(fsys, opt))
+}
+
+func (r *rootRouter) Assets(routePath string, fsys fs.FS, opt *StaticOptions) *Assets {
+	a, err := NewAssets(fsys, routePath)
+	if err != nil {
+		panic(err)
+	}
+	var o StaticOptions
+	if opt != nil {
+		o = *opt
+	}
+	immutable := o.Immutable
+	o.Immutable = func(name string) bool {
+		return a.IsFingerprinted(name) || (immutable != nil && immutable(name))
+	}
+	r.handleFS(routePath, NewFSHandler(a, &o))
+	return a
+}
+
+func (r *rootRouter) handleFS(routePath string, h *FSHandler) {
+	r.GET(routePath, h.Handle)
+	r.GET(path.Join(routePath, anyChar), h.Handle)
+	r.HEAD(routePath, h.Handle)
+	r.HEAD(path.Join(routePath, anyChar), h.Handle)
+}
+
+func (r *rootRouter) Shutdown(ctx context.Context) error {
+	return r.ws.shutdown(ctx)
+}
+
 func (r *rootRouter) SubRouter(routePath string) Router {
 	return &subRouter{path: routePath, router: &r.router}
 }
@@ -250,26 +352,23 @@ func (r *rootRouter) Static(routePath, file string, cache int64) {
 	if err != nil {
 		panic(err)
 	}
-	if !fi.IsDir() {
-		// Is a file
-		if cache >= fi.Size() {
-			h, err := NewCacheHandlerFromFile(file)
-			if err != nil {
-				panic(err)
-			}
-			r.GET(routePath, h.Handle)
-		} else {
-			r.GET(routePath, FileHandler(file).Handle)
-		}
-	} else {
-		// Is a dir
-		fis, err := ioutil.ReadDir(file)
-		if err != nil {
-			panic(err)
-		}
-		// Add sub
-		for i := 0; i < len(fis); i++ {
-			r.Static(path.Join(routePath, fis[i].Name()), filepath.Join(file, fis[i].Name()), cache)
-		}
+	opt := &StaticOptions{ShowHidden: true}
+	if cache > 0 {
+		opt.Cache = DefaultStaticCache
+		opt.CacheFileSize = cache
+	}
+	if fi.IsDir() {
+		r.StaticFS(routePath, os.DirFS(file), opt)
+		return
+	}
+	// Is a file
+	h := NewFSHandler(os.DirFS(filepath.Dir(file)), opt)
+	name := filepath.Base(file)
+	handle := func(ctx *Context) {
+		// Route params are not the file name.
+		ctx.Param = append(ctx.Param[:0], name)
+		h.Handle(ctx)
 	}
+	r.GET(routePath, handle)
+	r.HEAD(routePath, handle)
 }
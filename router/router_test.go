@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -211,3 +212,26 @@ func Benchmark_My(b *testing.B) {
 // 	}
 // 	benchmarkServeHTTP(b, root, urls)
 // }
+
+func Test_Router_Static_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "readme.txt")
+	err = ioutil.WriteFile(file, []byte("readme"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRootRouter()
+	r.Static("/docs/?/readme", file, 0)
+	res := testServe(r, http.MethodGet, "/docs/v1/readme", nil)
+	if res.Code != http.StatusOK || res.Body.String() != "readme" {
+		t.Fatal(res.Code, res.Body.String())
+	}
+	res = testServe(r, http.MethodHead, "/docs/v1/readme", nil)
+	if res.Code != http.StatusOK || res.Body.Len() != 0 || res.Header().Get("Content-Length") != "6" {
+		t.Fatal(res.Code, res.Header())
+	}
+}
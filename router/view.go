@@ -0,0 +1,211 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+var errNoView = errors.New("router: no view, see Root.View")
+
+// ViewOptions 是 View 的选项
+type ViewOptions struct {
+	// 模板文件的扩展名，空表示 ".html"
+	Ext string
+	// 布局模板的目录，空表示 "layouts"
+	Layouts string
+	// 局部模板的目录，空表示 "partials"
+	Partials string
+	// 默认的布局，比如 "main" 表示 "layouts/main.html" ，空表示不使用布局
+	Layout string
+	// 模板函数，比如 Assets.FuncMap
+	Funcs template.FuncMap
+	// 开发模式，每次渲染都重新加载模板文件，否则只加载一次
+	Reload bool
+}
+
+// View 使用 html/template 渲染 fs.FS 中的模板文件。
+// 模板的名称是去掉扩展名的路径，比如 "users/show" 表示 "users/show.html" 。
+// 所有的局部模板都可以在页面和布局中使用，比如 {{template "partials/nav" .}} 。
+// 使用布局时，执行的是布局模板，页面模板的名称是 "content" ，
+// 布局中使用 {{block "content" .}}{{end}} 输出页面，页面中的 {{define}} 可以覆盖布局中的 {{block}} 。
+type View struct {
+	fs  fs.FS
+	opt ViewOptions
+	// 局部模板的文件
+	partials []string
+	// 编译好的模板，键是布局和页面
+	lock      sync.RWMutex
+	templates map[[2]string]*template.Template
+}
+
+// NewView 返回一个加载 fsys 中模板文件的 View ，opt 为 nil 表示使用默认选项。
+// 不是开发模式时，所有的页面使用默认的布局预先编译，编译失败返回错误。
+func NewView(fsys fs.FS, opt *ViewOptions) (*View, error) {
+	v := &View{
+		fs:        fsys,
+		templates: make(map[[2]string]*template.Template),
+	}
+	if opt != nil {
+		v.opt = *opt
+	}
+	if v.opt.Ext == "" {
+		v.opt.Ext = ".html"
+	}
+	if v.opt.Layouts == "" {
+		v.opt.Layouts = "layouts"
+	}
+	if v.opt.Partials == "" {
+		v.opt.Partials = "partials"
+	}
+	if v.opt.Reload {
+		return v, nil
+	}
+	pages, partials, err := v.files()
+	if err != nil {
+		return nil, err
+	}
+	v.partials = partials
+	for _, page := range pages {
+		t, err := v.parse(v.opt.Layout, page, partials)
+		if err != nil {
+			return nil, err
+		}
+		v.templates[[2]string{v.opt.Layout, page}] = t
+	}
+	return v, nil
+}
+
+// NewViewDir 返回一个加载目录 dir 中模板文件的 View
+func NewViewDir(dir string, opt *ViewOptions) (*View, error) {
+	return NewView(os.DirFS(dir), opt)
+}
+
+// files 返回页面的名称和局部模板的文件
+func (v *View) files() (pages, partials []string, err error) {
+	err = fs.WalkDir(v.fs, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(name) != v.opt.Ext {
+			return nil
+		}
+		switch {
+		case strings.HasPrefix(name, v.opt.Partials+"/"):
+			partials = append(partials, name)
+		case strings.HasPrefix(name, v.opt.Layouts+"/"):
+		default:
+			pages = append(pages, strings.TrimSuffix(name, v.opt.Ext))
+		}
+		return nil
+	})
+	return
+}
+
+// parse 编译使用布局 layout 的页面 page ，partials 是局部模板的文件
+func (v *View) parse(layout, page string, partials []string) (*template.Template, error) {
+	t := template.New("").Funcs(v.opt.Funcs)
+	parse := func(name, file string) error {
+		data, err := fs.ReadFile(v.fs, file)
+		if err != nil {
+			return fmt.Errorf("view %q: %w", page, err)
+		}
+		_, err = t.New(name).Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("view %q: %w", page, err)
+		}
+		return nil
+	}
+	for _, file := range partials {
+		err := parse(strings.TrimSuffix(file, v.opt.Ext), file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if layout == "" {
+		err := parse(page, page+v.opt.Ext)
+		if err != nil {
+			return nil, err
+		}
+		return t.Lookup(page), nil
+	}
+	// 先编译布局，页面的 {{define}} 才能覆盖布局的 {{block}}
+	layout = path.Join(v.opt.Layouts, layout)
+	err := parse(layout, layout+v.opt.Ext)
+	if err != nil {
+		return nil, err
+	}
+	err = parse("content", page+v.opt.Ext)
+	if err != nil {
+		return nil, err
+	}
+	return t.Lookup(layout), nil
+}
+
+// Template 返回使用布局 layout 的页面 name 的模板，layout 为空表示不使用布局
+func (v *View) Template(layout, name string) (*template.Template, error) {
+	name = strings.TrimPrefix(name, "/")
+	if v.opt.Reload {
+		_, partials, err := v.files()
+		if err != nil {
+			return nil, err
+		}
+		return v.parse(layout, name, partials)
+	}
+	key := [2]string{layout, name}
+	v.lock.RLock()
+	t, ok := v.templates[key]
+	v.lock.RUnlock()
+	if ok {
+		return t, nil
+	}
+	// 其他的布局
+	t, err := v.parse(layout, name, v.partials)
+	if err != nil {
+		return nil, err
+	}
+	v.lock.Lock()
+	v.templates[key] = t
+	v.lock.Unlock()
+	return t, nil
+}
+
+// Render 使用默认的布局渲染页面 name 到 w
+func (v *View) Render(w io.Writer, name string, data interface{}) error {
+	return v.RenderLayout(w, v.opt.Layout, name, data)
+}
+
+// RenderLayout 使用布局 layout 渲染页面 name 到 w ，layout 为空表示不使用布局
+func (v *View) RenderLayout(w io.Writer, layout, name string, data interface{}) error {
+	t, err := v.Template(layout, name)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, data)
+}
+
+// renderWriter 在第一次写数据时才写响应头，这样在此之前的错误可以响应其他状态码
+type renderWriter struct {
+	ctx        *Context
+	statusCode int
+	wrote      bool
+}
+
+func (w *renderWriter) writeHeader() {
+	w.wrote = true
+	w.ctx.ResponseWriter.Header().Set(contentType, ContentTypeHTML)
+	w.ctx.ResponseWriter.WriteHeader(w.statusCode)
+}
+
+func (w *renderWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.writeHeader()
+	}
+	return w.ctx.ResponseWriter.Write(b)
+}
@@ -0,0 +1,127 @@
+package router
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testViewFS() fstest.MapFS {
+	return fstest.MapFS{
+		"layouts/main.html": {Data: []byte(`<title>{{block "title" .}}default{{end}}</title>{{template "partials/nav" .}}{{block "content" .}}{{end}}`)},
+		"partials/nav.html": {Data: []byte(`<nav>{{upper .Name}}</nav>`)},
+		"index.html":        {Data: []byte(`index {{.Name}}`)},
+		"users/show.html":   {Data: []byte(`{{define "title"}}user{{end}}<p>{{.Name}}</p>`)},
+		"error.html":        {Data: []byte(`{{.Name.None}}`)},
+		"static/ignore.txt": {Data: []byte(`{{`)},
+	}
+}
+
+func Test_View(t *testing.T) {
+	fsys := testViewFS()
+	funcs := template.FuncMap{"upper": strings.ToUpper}
+	v, err := NewView(fsys, &ViewOptions{Layout: "main", Funcs: funcs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{"Name": "<a>"}
+	for name, s := range map[string]string{
+		"index":      `<title>default</title><nav>&lt;A&gt;</nav>index &lt;a&gt;`,
+		"users/show": `<title>user</title><nav>&lt;A&gt;</nav><p>&lt;a&gt;</p>`,
+	} {
+		var buf bytes.Buffer
+		err = v.Render(&buf, name, data)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if buf.String() != s {
+			t.Fatal(name, buf.String())
+		}
+	}
+	// Without layout.
+	var buf bytes.Buffer
+	err = v.RenderLayout(&buf, "", "users/show", data)
+	if err != nil || buf.String() != "<p>&lt;a&gt;</p>" {
+		t.Fatal(err, buf.String())
+	}
+	// Precompiled.
+	fsys["index.html"] = &fstest.MapFile{Data: []byte("changed")}
+	buf.Reset()
+	err = v.RenderLayout(&buf, "main", "index", data)
+	if err != nil || !strings.HasSuffix(buf.String(), "index &lt;a&gt;") {
+		t.Fatal(err, buf.String())
+	}
+	// Reload.
+	v, err = NewView(fsys, &ViewOptions{Reload: true, Funcs: funcs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	err = v.Render(&buf, "index", data)
+	if err != nil || buf.String() != "changed" {
+		t.Fatal(err, buf.String())
+	}
+	fsys["index.html"] = &fstest.MapFile{Data: []byte("reloaded")}
+	buf.Reset()
+	err = v.Render(&buf, "index", data)
+	if err != nil || buf.String() != "reloaded" {
+		t.Fatal(err, buf.String())
+	}
+	// Parse error.
+	fsys["bad.html"] = &fstest.MapFile{Data: []byte("{{")}
+	_, err = NewView(fsys, &ViewOptions{Funcs: funcs})
+	if err == nil || !strings.Contains(err.Error(), `"bad"`) {
+		t.Fatal(err)
+	}
+}
+
+func Test_Context_Render(t *testing.T) {
+	v, err := NewView(testViewFS(), &ViewOptions{
+		Layout: "main",
+		Funcs:  template.FuncMap{"upper": strings.ToUpper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRootRouter()
+	r.GET("/?", func(ctx *Context) {
+		ctx.Render(http.StatusCreated, ctx.Param[0], map[string]string{"Name": "a"})
+	})
+	// No view.
+	res := testServe(r, http.MethodGet, "/index", nil)
+	if res.Code != http.StatusInternalServerError {
+		t.Fatal(res.Code)
+	}
+	r.View(v)
+	res = testServe(r, http.MethodGet, "/index", nil)
+	if res.Code != http.StatusCreated || res.Body.String() != "<title>default</title><nav>A</nav>index a" {
+		t.Fatal(res.Code, res.Body.String())
+	}
+	if res.Header().Get("Content-Type") != ContentTypeHTML {
+		t.Fatal(res.Header())
+	}
+	// Error handler.
+	var errs []error
+	r.Error(func(ctx *Context) {
+		errs = append(errs, ctx.Err)
+		ctx.WriteHeader(http.StatusServiceUnavailable)
+	})
+	res = testServe(r, http.MethodGet, "/none", nil)
+	if res.Code != http.StatusServiceUnavailable || res.Body.Len() != 0 {
+		t.Fatal(res.Code, res.Body.String())
+	}
+	if len(errs) != 1 || errs[0] == nil {
+		t.Fatal(errs)
+	}
+	// Error after the layout is written.
+	res = testServe(r, http.MethodGet, "/error", nil)
+	if res.Code != http.StatusCreated || res.Body.String() != "<title>default</title><nav>A</nav>" {
+		t.Fatal(res.Code, res.Body.String())
+	}
+	if len(errs) != 1 {
+		t.Fatal(errs)
+	}
+}
@@ -0,0 +1,126 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qq51529210/web/socket"
+)
+
+// Contexts of the connections served by Router.WS.
+var wsContexts sync.Map
+
+// Return the Context of the upgrade request of conn, which was accepted by Router.WS.
+// Param and TempData set by interceptors are kept until the connection ends,
+// Request and ResponseWriter must not be used to respond.
+// Return nil if conn was not accepted by Router.WS, or it has ended.
+func ContextOf(conn *socket.Conn) *Context {
+	ctx, ok := wsContexts.Load(conn)
+	if !ok {
+		return nil
+	}
+	return ctx.(*Context)
+}
+
+// Connections served by WSRouter.WS of a Root, for shutdown.
+type wsConns struct {
+	sync.Mutex
+	conns map[*socket.Conn]struct{}
+	// Shutdown was called, new connections are refused.
+	closing bool
+	// Count of serving connections.
+	wg sync.WaitGroup
+}
+
+// Reports whether a new connection can be accepted, done must be called if it returns true.
+func (ws *wsConns) add() bool {
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.closing {
+		return false
+	}
+	ws.wg.Add(1)
+	return true
+}
+
+// Keep conn until remove, return false if Shutdown was called.
+func (ws *wsConns) keep(conn *socket.Conn) bool {
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.closing {
+		return false
+	}
+	ws.conns[conn] = struct{}{}
+	return true
+}
+
+// Remove conn, it has ended.
+func (ws *wsConns) remove(conn *socket.Conn) {
+	ws.Lock()
+	delete(ws.conns, conn)
+	ws.Unlock()
+	ws.wg.Done()
+}
+
+// Send a close frame of socket.CloseGoingAway to all connections,
+// then wait for them to end until ctx is done, the others are closed.
+func (ws *wsConns) shutdown(ctx context.Context) error {
+	ws.Lock()
+	ws.closing = true
+	for conn := range ws.conns {
+		// The peer may not read, do not block.
+		go conn.WriteClose(socket.CloseGoingAway, "server shutdown")
+	}
+	ws.Unlock()
+	done := make(chan struct{})
+	go func() {
+		ws.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		ws.Lock()
+		for conn := range ws.conns {
+			// Unblock the writing close frame.
+			conn.SetWriteDeadline(time.Now())
+			go conn.Close()
+		}
+		ws.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Return a HandleFunc which accepts a websocket connection, then serve it with handler.
+func (r *router) wsHandle(handler socket.Handler, opt *socket.ServeOptions) HandleFunc {
+	if handler == nil {
+		panic("websocket handler is nil")
+	}
+	if opt == nil {
+		opt = new(socket.ServeOptions)
+	}
+	return func(ctx *Context) {
+		if !r.ws.add() {
+			http.Error(ctx.ResponseWriter, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		// The error response has been written.
+		conn, err := socket.AcceptWithOptions(ctx.ResponseWriter, ctx.Request, opt.Accept)
+		if err != nil {
+			r.ws.wg.Done()
+			return
+		}
+		wsContexts.Store(conn, ctx)
+		if r.ws.keep(conn) {
+			socket.Serve(conn, handler, opt)
+		} else {
+			conn.WriteClose(socket.CloseGoingAway, "server shutdown")
+			conn.Close()
+		}
+		wsContexts.Delete(conn)
+		r.ws.remove(conn)
+	}
+}
@@ -0,0 +1,118 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qq51529210/web/socket"
+)
+
+// Echo "param tempdata text".
+type testWSHandler struct{}
+
+func (h *testWSHandler) HandleText(c *socket.Conn, b []byte) {
+	ctx := ContextOf(c)
+	c.Write(socket.CodeText, []byte(ctx.Param[0]+" "+ctx.TempData.(string)+" "+string(b)), 0)
+}
+
+func (h *testWSHandler) HandleBinary(c *socket.Conn, b []byte) {}
+
+func (h *testWSHandler) HandleClose(c *socket.Conn, b []byte) {}
+
+func (h *testWSHandler) HandlePing(c *socket.Conn, b []byte) {}
+
+func (h *testWSHandler) HandlePong(c *socket.Conn, b []byte) {}
+
+// Dial url, return the status code if it fails.
+func testWSDial(t *testing.T, url string, header http.Header) (*socket.Conn, int) {
+	conn, res, err := socket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		if res == nil {
+			t.Fatal(err)
+		}
+		return nil, res.StatusCode
+	}
+	return conn, res.StatusCode
+}
+
+func Test_Router_WS(t *testing.T) {
+	r := NewRootRouter()
+	r.Intercept(func(ctx *Context) {
+		if ctx.BearerToken() != "token" {
+			ctx.WriteHeader(http.StatusUnauthorized)
+			ctx.Abort()
+			return
+		}
+		ctx.TempData = "user"
+	})
+	api, ok := r.SubRouter("/api").(WSRouter)
+	if !ok {
+		t.Fatal("sub router is not a WSRouter")
+	}
+	api.WS("/ws/?", new(testWSHandler), nil)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/ws/room"
+	// Intercepted.
+	_, status := testWSDial(t, url, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatal(status)
+	}
+	header := http.Header{"Authorization": []string{"Bearer token"}}
+	conn, _ := testWSDial(t, url, header)
+	defer conn.Close()
+	err := conn.Write(socket.CodeText, []byte("hello"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg string
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.ReadLoop(0, func(code socket.Code, b []byte) error {
+			if code == socket.CodeText {
+				msg = string(b)
+			}
+			return nil
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// Close the connection.
+	err = r.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *socket.CloseError
+	if err = <-done; !errors.As(err, &e) || e.Code != socket.CloseGoingAway {
+		t.Fatal(err)
+	}
+	if msg != "room user hello" {
+		t.Fatal(msg)
+	}
+	// Refused.
+	_, status = testWSDial(t, url, header)
+	if status != http.StatusServiceUnavailable {
+		t.Fatal(status)
+	}
+}
+
+func Test_Router_WS_ShutdownTimeout(t *testing.T) {
+	r := NewRootRouter()
+	r.WS("/ws", new(testWSHandler), nil)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	// Never read, so never answer the close frame.
+	conn, _ := testWSDial(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := r.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
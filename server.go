@@ -1,6 +1,7 @@
 package web
 
 import (
+	"context"
 	"crypto/tls"
 	"io/ioutil"
 	"net"
@@ -12,6 +13,16 @@ type Server interface {
 	Serve() error
 }
 
+// Shutdowner 表示可以优雅关闭的服务或者 handler ，使用类型断言获取，
+// 比如 server.(web.Shutdowner).Shutdown(ctx) 。NewServer 等函数返回的 Server 实现了它，
+// 管理着被劫持的连接的 handler 也可以实现，比如 router.Root 。
+type Shutdowner interface {
+	// Shutdown 优雅地关闭服务，Serve 返回 http.ErrServerClosed ，然后等待正在处理的请求结束，
+	// ctx 结束时还没有结束的连接会被关闭。
+	// 如果 handler 实现了 Shutdowner ，同时调用它关闭被劫持的连接，比如 WebSocket 。
+	Shutdown(ctx context.Context) error
+}
+
 // NewServer 返回一个在 addr 监听，使用 handler 的 Server
 func NewServer(addr string, handler http.Handler) Server {
 	s := new(server)
@@ -81,3 +92,21 @@ func (s *server) Serve() error {
 	// 监听 http
 	return s.Server.ListenAndServe()
 }
+
+// Shutdown 实现 Shutdowner 接口
+func (s *server) Shutdown(ctx context.Context) error {
+	h, ok := s.Server.Handler.(Shutdowner)
+	if !ok {
+		return s.Server.Shutdown(ctx)
+	}
+	// http.Server 不管理被劫持的连接，同时关闭
+	hErr := make(chan error, 1)
+	go func() {
+		hErr <- h.Shutdown(ctx)
+	}()
+	err := s.Server.Shutdown(ctx)
+	if e := <-hErr; err == nil {
+		err = e
+	}
+	return err
+}
@@ -1,6 +1,7 @@
 package socket
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"io"
 	"sync"
@@ -100,7 +101,7 @@ func (b *encodeBuffer) Put64(n uint64) {
 // Append n random bytes.
 func (b *encodeBuffer) PutRandom(n int) {
 	b.grow(n)
-	random.Read(b.buf[b.len:])
+	rand.Read(b.buf[b.len : b.len+n])
 	b.len += n
 }
 
@@ -115,3 +116,40 @@ func (b *encodeBuffer) PutString(s string) {
 	b.grow(len(s))
 	b.len += copy(b.buf[b.len:], s)
 }
+
+// Append frame header without mask key, bits are fin and rsv bits.
+func (b *encodeBuffer) PutFrameHeader(bits byte, code Code, length int, mask byte) {
+	// Encode fin, rsv and code.
+	b.Put8(bits | byte(code))
+	// Encode mask and payload length.
+	if length < 126 {
+		b.Put8(mask | byte(length))
+	} else if length <= 0xffff {
+		b.Put8(mask | 126)
+		b.Put16(uint16(length))
+	} else {
+		b.Put8(mask | 127)
+		b.Put64(uint64(length))
+	}
+}
+
+// Read from r until io.EOF.
+func (b *readBuffer) ReadAll(r io.Reader) error {
+	for {
+		if b.len == len(b.buf) {
+			n := len(b.buf)
+			if n < 512 {
+				n = 512
+			}
+			b.grow(n)
+		}
+		n, err := r.Read(b.buf[b.len:])
+		b.len += n
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package socket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Name of RFC 7692 extension.
+const permessageDeflate = "permessage-deflate"
+
+// Size of the sliding window, which compress/flate always uses.
+const flateWindow = 32 << 10
+
+var (
+	// Appended to a compressed message before decompressing,
+	// the sync flush marker, then an empty final block, so that the reader ends with io.EOF.
+	flateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+	// Pool of flate readers.
+	flateReaderPool sync.Pool
+)
+
+// Options of permessage-deflate extension.
+type CompressionOptions struct {
+	// Level of compress/flate, 0 means flate.DefaultCompression.
+	Level int
+	// Messages shorter than Threshold are sent uncompressed.
+	Threshold int
+	// Server does not take over context, the server compresses every message independently.
+	ServerNoContextTakeover bool
+	// Client does not take over context, the client compresses every message independently.
+	ClientNoContextTakeover bool
+}
+
+// A parsed extension of Sec-WebSocket-Extensions.
+type extension struct {
+	name   string
+	params map[string]string
+	// Keep the order.
+	keys []string
+}
+
+func (e *extension) String() string {
+	var s strings.Builder
+	s.WriteString(e.name)
+	for _, k := range e.keys {
+		s.WriteString("; ")
+		s.WriteString(k)
+		if v := e.params[k]; v != "" {
+			s.WriteByte('=')
+			s.WriteString(v)
+		}
+	}
+	return s.String()
+}
+
+func (e *extension) set(key, value string) {
+	if e.params == nil {
+		e.params = make(map[string]string)
+	}
+	e.params[key] = value
+	e.keys = append(e.keys, key)
+}
+
+// Parse all Sec-WebSocket-Extensions header values.
+// Return error if a parameter appears twice in an extension.
+func parseExtensions(header http.Header) ([]*extension, error) {
+	var exts []*extension
+	for _, value := range header.Values("Sec-Websocket-Extensions") {
+		for _, item := range strings.Split(value, ",") {
+			parts := strings.Split(item, ";")
+			e := &extension{name: strings.TrimSpace(parts[0])}
+			if e.name == "" {
+				continue
+			}
+			for _, p := range parts[1:] {
+				p = strings.TrimSpace(p)
+				if p == "" {
+					continue
+				}
+				k, v := p, ""
+				if i := strings.IndexByte(p, '='); i >= 0 {
+					k, v = strings.TrimSpace(p[:i]), strings.Trim(strings.TrimSpace(p[i+1:]), `"`)
+				}
+				if _, ok := e.params[k]; ok {
+					return nil, fmt.Errorf("duplicate extension parameter %q", k)
+				}
+				e.set(k, v)
+			}
+			exts = append(exts, e)
+		}
+	}
+	return exts, nil
+}
+
+// Check window bits value, empty is allowed if allowEmpty.
+func checkWindowBits(v string, allowEmpty bool) bool {
+	if v == "" {
+		return allowEmpty
+	}
+	n, err := strconv.Atoi(v)
+	return err == nil && n >= 8 && n <= 15 && strconv.Itoa(n) == v
+}
+
+// Server side negotiation, return the response extension and the compression state,
+// or nil if no offer is acceptable.
+func acceptCompression(header http.Header, opt *CompressionOptions) (*extension, *compression) {
+	offers, err := parseExtensions(header)
+	if err != nil {
+		return nil, nil
+	}
+Loop:
+	for _, offer := range offers {
+		if offer.name != permessageDeflate {
+			continue
+		}
+		res := &extension{name: permessageDeflate}
+		serverNoContext, clientNoContext := opt.ServerNoContextTakeover, opt.ClientNoContextTakeover
+		for _, k := range offer.keys {
+			v := offer.params[k]
+			switch k {
+			case "server_no_context_takeover":
+				if v != "" {
+					continue Loop
+				}
+				serverNoContext = true
+			case "client_no_context_takeover":
+				if v != "" {
+					continue Loop
+				}
+				clientNoContext = true
+			case "server_max_window_bits":
+				// compress/flate can not use a smaller window.
+				if !checkWindowBits(v, false) || v != "15" {
+					continue Loop
+				}
+			case "client_max_window_bits":
+				// Any client window can be decompressed, no need to answer.
+				if !checkWindowBits(v, true) {
+					continue Loop
+				}
+			default:
+				continue Loop
+			}
+		}
+		if serverNoContext {
+			res.set("server_no_context_takeover", "")
+		}
+		if clientNoContext {
+			res.set("client_no_context_takeover", "")
+		}
+		return res, newCompression(opt, serverNoContext, clientNoContext)
+	}
+	return nil, nil
+}
+
+// Client side offer.
+func offerCompression(opt *CompressionOptions) *extension {
+	e := &extension{name: permessageDeflate}
+	if opt.ServerNoContextTakeover {
+		e.set("server_no_context_takeover", "")
+	}
+	if opt.ClientNoContextTakeover {
+		e.set("client_no_context_takeover", "")
+	}
+	return e
+}
+
+// Client side negotiation, check the server response,
+// return nil if the server did not accept the extension.
+func dialCompression(header http.Header, opt *CompressionOptions) (*compression, error) {
+	exts, err := parseExtensions(header)
+	if err != nil {
+		return nil, err
+	}
+	if len(exts) < 1 {
+		return nil, nil
+	}
+	if len(exts) > 1 || exts[0].name != permessageDeflate {
+		return nil, fmt.Errorf("unexpected extensions %q", header.Values("Sec-Websocket-Extensions"))
+	}
+	serverNoContext, clientNoContext := false, opt.ClientNoContextTakeover
+	for _, k := range exts[0].keys {
+		v := exts[0].params[k]
+		switch k {
+		case "server_no_context_takeover":
+			serverNoContext = true
+		case "client_no_context_takeover":
+			clientNoContext = true
+		case "server_max_window_bits":
+			// Any server window can be decompressed.
+			if !checkWindowBits(v, false) {
+				return nil, fmt.Errorf("invalid server_max_window_bits %q", v)
+			}
+		default:
+			// client_max_window_bits was not offered.
+			return nil, fmt.Errorf("unexpected %s parameter %q", permessageDeflate, k)
+		}
+		if (k == "server_no_context_takeover" || k == "client_no_context_takeover") && v != "" {
+			return nil, fmt.Errorf("invalid %s value %q", k, v)
+		}
+	}
+	return newCompression(opt, clientNoContext, serverNoContext), nil
+}
+
+// State of permessage-deflate of a Conn.
+type compression struct {
+	// Protects enabled and threshold.
+	mu      sync.Mutex
+	enabled bool
+	// Messages shorter than threshold are sent uncompressed.
+	threshold int
+	// Write messages, protected by Conn.mmu.
+	level int
+	// Compress every message independently.
+	writeNoContext bool
+	w              *flate.Writer
+	wbuf           bytes.Buffer
+	// Read messages, only used by the reading goroutine.
+	// The peer compresses every message independently.
+	readNoContext bool
+	// The last flateWindow bytes of decompressed messages,
+	// used as the dictionary of next message.
+	dict []byte
+}
+
+// Create compression state, writeNoContext and readNoContext are negotiated values.
+func newCompression(opt *CompressionOptions, writeNoContext, readNoContext bool) *compression {
+	c := &compression{
+		enabled:        true,
+		level:          opt.Level,
+		threshold:      opt.Threshold,
+		writeNoContext: writeNoContext,
+		readNoContext:  readNoContext,
+	}
+	if c.level == 0 {
+		c.level = flate.DefaultCompression
+	}
+	return c
+}
+
+// Return the flate writer which writes to c.wbuf, it is reset if writeNoContext.
+// Caller must hold Conn.mmu.
+func (c *compression) writer() (*flate.Writer, error) {
+	if c.w == nil {
+		w, err := flate.NewWriter(&c.wbuf, c.level)
+		if err != nil {
+			return nil, err
+		}
+		c.w = w
+	} else if c.writeNoContext {
+		c.w.Reset(&c.wbuf)
+	}
+	return c.w, nil
+}
+
+// Compress data, return compressed data, which is valid until next call.
+// Caller must hold Conn.mmu.
+func (c *compression) Compress(data []byte) ([]byte, error) {
+	c.wbuf.Reset()
+	w, err := c.writer()
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+	// Remove sync flush marker 0x00 0x00 0xff 0xff.
+	b := c.wbuf.Bytes()
+	return b[:len(b)-4], nil
+}
+
+// Return a reader which decompresses the compressed message r.
+func (c *compression) reader(r io.Reader) io.ReadCloser {
+	src := io.MultiReader(r, bytes.NewReader(flateTail))
+	if v := flateReaderPool.Get(); v != nil {
+		fr := v.(io.ReadCloser)
+		fr.(flate.Resetter).Reset(src, c.dict)
+		return fr
+	}
+	return flate.NewReaderDict(src, c.dict)
+}
+
+// Keep the last flateWindow bytes of decompressed data b, as the dictionary of next message.
+// Only used by the reading goroutine.
+func (c *compression) keep(b []byte) {
+	if c.readNoContext {
+		return
+	}
+	if len(b) >= flateWindow {
+		c.dict = append(c.dict[:0], b[len(b)-flateWindow:]...)
+		return
+	}
+	if n := len(c.dict) + len(b) - flateWindow; n > 0 {
+		c.dict = append(c.dict[:0], c.dict[n:]...)
+	}
+	c.dict = append(c.dict, b...)
+}
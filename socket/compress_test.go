@@ -0,0 +1,189 @@
+package socket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_AcceptCompression(t *testing.T) {
+	opt := new(CompressionOptions)
+	for _, c := range []struct {
+		offer, response string
+	}{
+		{"", ""},
+		{"x-unknown", ""},
+		{"permessage-deflate", "permessage-deflate"},
+		{"permessage-deflate; client_max_window_bits", "permessage-deflate"},
+		{"permessage-deflate; client_max_window_bits=10", "permessage-deflate"},
+		{"permessage-deflate; server_no_context_takeover", "permessage-deflate; server_no_context_takeover"},
+		{"permessage-deflate; client_no_context_takeover", "permessage-deflate; client_no_context_takeover"},
+		// Can not limit server window, use the second offer.
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", "permessage-deflate"},
+		{"permessage-deflate; server_max_window_bits=15", "permessage-deflate"},
+		{"permessage-deflate; client_max_window_bits=16", ""},
+		{"permessage-deflate; unknown", ""},
+		{"permessage-deflate; server_no_context_takeover; server_no_context_takeover", ""},
+	} {
+		header := make(http.Header)
+		if c.offer != "" {
+			header.Set("Sec-WebSocket-Extensions", c.offer)
+		}
+		ext, _ := acceptCompression(header, opt)
+		res := ""
+		if ext != nil {
+			res = ext.String()
+		}
+		if res != c.response {
+			t.Fatalf("offer %q, response %q, want %q", c.offer, res, c.response)
+		}
+	}
+	// Server options.
+	header := make(http.Header)
+	header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+	ext, _ := acceptCompression(header, &CompressionOptions{ServerNoContextTakeover: true, ClientNoContextTakeover: true})
+	if ext.String() != "permessage-deflate; server_no_context_takeover; client_no_context_takeover" {
+		t.Fatal(ext)
+	}
+}
+
+func Test_DialCompression(t *testing.T) {
+	opt := new(CompressionOptions)
+	for _, c := range []struct {
+		response string
+		ok       bool
+	}{
+		{"", true},
+		{"permessage-deflate", true},
+		{"permessage-deflate; server_no_context_takeover; client_no_context_takeover", true},
+		{"permessage-deflate; server_max_window_bits=9", true},
+		{"permessage-deflate; client_max_window_bits=9", false},
+		{"x-unknown", false},
+		{"permessage-deflate, permessage-deflate", false},
+	} {
+		header := make(http.Header)
+		if c.response != "" {
+			header.Set("Sec-WebSocket-Extensions", c.response)
+		}
+		_, err := dialCompression(header, opt)
+		if (err == nil) != c.ok {
+			t.Fatalf("response %q, %v", c.response, err)
+		}
+	}
+}
+
+// Send messages from client to server, return messages server received.
+func test_Compress(t *testing.T, client, server *compression, msgs []string, payload int) {
+	s, c := net.Pipe()
+	sc := newConn(s, nil, _Mask[0])
+	sc.compress = server
+	cc := newConn(c, nil, _Mask[1])
+	cc.compress = client
+	go func() {
+		for _, m := range msgs {
+			cc.Write(CodeText, []byte(m), payload)
+		}
+		cc.Close()
+	}()
+	var got []string
+	sc.ReadLoop(0, func(c Code, b []byte) error {
+		if c == CodeText {
+			got = append(got, string(b))
+		}
+		return nil
+	})
+	sc.conn.Close()
+	if len(got) != len(msgs) {
+		t.Fatalf("got %d messages, want %d", len(got), len(msgs))
+	}
+	for i := range msgs {
+		if got[i] != msgs[i] {
+			t.Fatalf("message %d: %q", i, got[i])
+		}
+	}
+}
+
+func Test_Compress(t *testing.T) {
+	opt := new(CompressionOptions)
+	msgs := []string{
+		strings.Repeat("hello world ", 100),
+		strings.Repeat("hello world ", 100),
+		"",
+		strings.Repeat("0123456789", 10000),
+		strings.Repeat("hello world ", 3),
+	}
+	// Context takeover.
+	test_Compress(t, newCompression(opt, false, false), newCompression(opt, false, false), msgs, 0)
+	// No context takeover, fragmented.
+	test_Compress(t, newCompression(opt, true, true), newCompression(opt, true, true), msgs, 100)
+	// Compressed message is smaller.
+	c := newCompression(opt, false, false)
+	d1, _ := c.Compress([]byte(msgs[0]))
+	n1 := len(d1)
+	d2, _ := c.Compress([]byte(msgs[0]))
+	if n1 >= len(msgs[0]) || len(d2) >= n1 {
+		t.Fatal(n1, len(d2))
+	}
+}
+
+func Test_Compress_Threshold(t *testing.T) {
+	s, c := net.Pipe()
+	defer s.Close()
+	cc := newConn(c, nil, _Mask[1])
+	cc.compress = newCompression(&CompressionOptions{Threshold: 10}, false, false)
+	sc := newConn(s, nil, _Mask[0])
+	sc.compress = newCompression(new(CompressionOptions), false, false)
+	go func() {
+		cc.Write(CodeText, []byte("short"), 0)
+		cc.Write(CodeText, []byte("long enough"), 0)
+		cc.WriteCompress(CodeText, []byte("short"), 0, true)
+		cc.EnableWriteCompression(false)
+		cc.Write(CodeText, []byte("long enough"), 0)
+	}()
+	var h frameHeader
+	for _, rsv := range []byte{0, rsv1Bit, rsv1Bit, 0} {
+		if err := sc.readHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		io.CopyN(ioutil.Discard, s, h.length)
+		if h.rsv != rsv {
+			t.Fatal(h.rsv)
+		}
+	}
+}
+
+func Test_AcceptWithOptions_Compression(t *testing.T) {
+	msg := bytes.Repeat([]byte("compressed "), 100)
+	ser := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		conn, err := AcceptWithOptions(res, req, &AcceptOptions{Compression: new(CompressionOptions)})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Write(CodeBinary, msg, 0)
+		conn.Close()
+	}))
+	defer ser.Close()
+	header := make(http.Header)
+	header.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits")
+	conn := test_Handshake(t, ser.URL, header)
+	defer conn.Close()
+	if !conn.CompressionNegotiated() {
+		t.FailNow()
+	}
+	var got []byte
+	conn.ReadLoop(0, func(c Code, b []byte) error {
+		if c == CodeBinary {
+			got = append(got, b...)
+		}
+		return nil
+	})
+	if !bytes.Equal(got, msg) {
+		t.Fatal(string(got))
+	}
+}
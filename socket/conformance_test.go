@@ -0,0 +1,456 @@
+package socket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Conformance test cases, after the categories of Autobahn TestSuite.
+// The fuzzing peer sends raw frames to the peer under test, which echoes data messages,
+// then it checks the frames sent back, and that the connection is closed.
+// Run with -v to see the result of every case, e.g.
+//
+//	go test -v -run Test_Conformance ./socket
+
+// Max message length of the peer under test.
+const conformanceMaxLen = 1 << 20
+
+// A raw frame sent by the fuzzing peer.
+type conformanceFrame struct {
+	fin  bool
+	rsv  byte
+	op   byte
+	data []byte
+}
+
+// Encode the frame, masked if mask.
+func (f *conformanceFrame) encode(mask bool) []byte {
+	var b encodeBuffer
+	bits := f.rsv
+	if f.fin {
+		bits |= _Fin[1]
+	}
+	m := _Mask[0]
+	if mask {
+		m = _Mask[1]
+	}
+	b.PutFrameHeader(bits, Code(f.op), len(f.data), m)
+	if !mask {
+		b.PutBytes(f.data)
+		return b.buf[:b.len]
+	}
+	var key [4]byte
+	rand.Read(key[:])
+	b.PutBytes(key[:])
+	i := b.len
+	b.PutBytes(f.data)
+	maskData(b.buf[i:b.len], key[:])
+	return b.buf[:b.len]
+}
+
+type conformanceCase struct {
+	id   string
+	desc string
+	// Frames sent by the fuzzing peer.
+	frames []*conformanceFrame
+	// Write frames in chunks of chop bytes, 0 means write every frame at once.
+	chop int
+	// Expected events of the peer under test, the last one is always a close frame.
+	// Events are "text:data", "binary:data", "pong:data" and "close:code", "close:" means no code.
+	expect []string
+}
+
+// Data frame.
+func cfData(op byte, data string) *conformanceFrame {
+	return &conformanceFrame{fin: true, op: op, data: []byte(data)}
+}
+
+// Fragment, not the last.
+func cfFrag(op byte, data string) *conformanceFrame {
+	return &conformanceFrame{op: op, data: []byte(data)}
+}
+
+// Frame with reserved bits.
+func cfRsv(rsv byte, op byte, data string) *conformanceFrame {
+	return &conformanceFrame{fin: true, rsv: rsv, op: op, data: []byte(data)}
+}
+
+// Close frame with code and reason.
+func cfClose(code uint16, reason string) *conformanceFrame {
+	b := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(b, code)
+	return &conformanceFrame{fin: true, op: byte(CodeClose), data: append(b, reason...)}
+}
+
+// Fragment data into frames of n bytes.
+func cfFragments(op byte, data string, n int) []*conformanceFrame {
+	var frames []*conformanceFrame
+	for {
+		if len(data) <= n {
+			return append(frames, cfData(op, data))
+		}
+		frames = append(frames, cfFrag(op, data[:n]))
+		data, op = data[n:], byte(codeContinuation)
+	}
+}
+
+func conformanceCases() []*conformanceCase {
+	var cases []*conformanceCase
+	add := func(id, desc string, expect []string, frames ...*conformanceFrame) *conformanceCase {
+		c := &conformanceCase{id: id, desc: desc, frames: frames, expect: expect}
+		cases = append(cases, c)
+		return c
+	}
+	list := func(s ...string) []string { return s }
+	const (
+		text   = byte(CodeText)
+		binary = byte(CodeBinary)
+		cont   = byte(codeContinuation)
+		ping   = byte(CodePing)
+		pong   = byte(CodePong)
+	)
+	// 1 Framing.
+	for i, n := range []int{0, 125, 126, 127, 128, 65535, 65536} {
+		s := strings.Repeat("*", n)
+		add(fmt.Sprintf("1.1.%d", i+1), fmt.Sprintf("text message, payload %d", n), list("text:"+s, "close:1000"), cfData(text, s))
+		add(fmt.Sprintf("1.2.%d", i+1), fmt.Sprintf("binary message, payload %d", n), list("binary:"+s, "close:1000"), cfData(binary, s))
+	}
+	add("1.1.8", "text message, payload 65536, chopped", list("text:"+strings.Repeat("*", 65536), "close:1000"),
+		cfData(text, strings.Repeat("*", 65536))).chop = 997
+	// 2 Pings.
+	add("2.1", "ping without payload", list("pong:", "close:1000"), cfData(ping, ""))
+	add("2.2", "ping with text payload", list("pong:Hello, world!", "close:1000"), cfData(ping, "Hello, world!"))
+	add("2.3", "ping with binary payload", list("pong:\x00\xff\xfe\xfd\xfc\xfb\x00\xff", "close:1000"), cfData(ping, "\x00\xff\xfe\xfd\xfc\xfb\x00\xff"))
+	add("2.4", "ping with payload 125", list("pong:"+strings.Repeat("\xfe", 125), "close:1000"), cfData(ping, strings.Repeat("\xfe", 125)))
+	add("2.5", "ping with payload 126", list("close:1002"), cfData(ping, strings.Repeat("\xfe", 126)))
+	add("2.6", "ping with payload 125, chopped", list("pong:"+strings.Repeat("\xfe", 125), "close:1000"), cfData(ping, strings.Repeat("\xfe", 125))).chop = 1
+	add("2.7", "unsolicited pong without payload", list("close:1000"), cfData(pong, ""))
+	add("2.8", "unsolicited pong with payload", list("close:1000"), cfData(pong, "unsolicited"))
+	add("2.9", "unsolicited pong, then ping", list("pong:ping", "close:1000"), cfData(pong, "pong"), cfData(ping, "ping"))
+	c := add("2.10", "10 pings", nil)
+	for i := 0; i < 10; i++ {
+		c.frames = append(c.frames, cfData(ping, fmt.Sprint(i)))
+		c.expect = append(c.expect, fmt.Sprintf("pong:%d", i))
+	}
+	c.expect = append(c.expect, "close:1000")
+	// 3 Reserved bits.
+	add("3.1", "text with RSV1, no extension", list("close:1002"), cfRsv(rsv1Bit, text, "Hello"))
+	add("3.2", "text, then text with RSV2", list("text:small", "close:1002"), cfData(text, "small"), cfRsv(rsv2Bit, text, "small"))
+	add("3.3", "text, text with RSV3, then ping", list("text:small", "close:1002"), cfData(text, "small"), cfRsv(rsv3Bit, text, "small"), cfData(ping, ""))
+	add("3.4", "binary with RSV1|RSV2|RSV3", list("close:1002"), cfRsv(rsv1Bit|rsv2Bit|rsv3Bit, binary, "\x00\xff"))
+	add("3.5", "ping with RSV1|RSV3", list("close:1002"), cfRsv(rsv1Bit|rsv3Bit, ping, "Hello"))
+	add("3.6", "close with RSV1|RSV2", list("close:1002"), &conformanceFrame{fin: true, rsv: rsv1Bit | rsv2Bit, op: byte(CodeClose)})
+	// 4 Opcodes.
+	for i, op := range []byte{3, 4, 5, 6, 7} {
+		add(fmt.Sprintf("4.1.%d", i+1), fmt.Sprintf("reserved non-control opcode %d", op), list("text:x", "close:1002"), cfData(text, "x"), cfData(op, "reserved"), cfData(ping, ""))
+	}
+	for i, op := range []byte{11, 12, 13, 14, 15} {
+		add(fmt.Sprintf("4.2.%d", i+1), fmt.Sprintf("reserved control opcode %d", op), list("text:x", "close:1002"), cfData(text, "x"), cfData(op, ""), cfData(ping, ""))
+	}
+	// 5 Fragmentation.
+	add("5.1", "fragmented ping", list("close:1002"), cfFrag(ping, "frag 1"), cfData(cont, "frag 2"))
+	add("5.2", "fragmented pong", list("close:1002"), cfFrag(pong, "frag 1"), cfData(cont, "frag 2"))
+	add("5.3", "text in 2 fragments", list("text:fragment1fragment2", "close:1000"), cfFrag(text, "fragment1"), cfData(cont, "fragment2"))
+	add("5.4", "text in 2 fragments, chopped", list("text:fragment1fragment2", "close:1000"), cfFrag(text, "fragment1"), cfData(cont, "fragment2")).chop = 1
+	add("5.5", "binary in 5 fragments", list("binary:0123456789", "close:1000"), cfFragments(binary, "0123456789", 2)...)
+	add("5.6", "text in 2 fragments, ping between", list("pong:ping", "text:fragment1fragment2", "close:1000"),
+		cfFrag(text, "fragment1"), cfData(ping, "ping"), cfData(cont, "fragment2"))
+	add("5.7", "text in 3 fragments, ping between each, chopped", list("pong:1", "pong:2", "text:abc", "close:1000"),
+		cfFrag(text, "a"), cfData(ping, "1"), cfFrag(cont, "b"), cfData(ping, "2"), cfData(cont, "c")).chop = 1
+	add("5.8", "text in empty fragments", list("text:", "close:1000"), cfFrag(text, ""), cfFrag(cont, ""), cfData(cont, ""))
+	add("5.9", "continuation without start, fin", list("close:1002"), cfData(cont, "non-continuation payload"), cfData(text, "Hello"))
+	add("5.10", "continuation without start, not fin", list("close:1002"), cfFrag(cont, "non-continuation payload"), cfData(text, "Hello"))
+	add("5.11", "text, then continuation", list("text:Hello", "close:1002"), cfData(text, "Hello"), cfData(cont, "fragment"))
+	add("5.12", "text start, then new text", list("close:1002"), cfFrag(text, "fragment1"), cfData(text, "fragment2"))
+	add("5.13", "text start, then new binary start", list("close:1002"), cfFrag(text, "fragment1"), cfFrag(binary, "fragment2"), cfData(cont, "fragment3"))
+	add("5.14", "2 fragmented messages", list("text:abcd", "binary:efgh", "close:1000"),
+		cfFrag(text, "ab"), cfData(cont, "cd"), cfFrag(binary, "ef"), cfData(cont, "gh"))
+	// 6 UTF-8.
+	valid := "Hello-µ@ßöäüàá-UTF-8!!"
+	add("6.1.1", "empty text", list("text:", "close:1000"), cfData(text, ""))
+	add("6.2.1", "valid utf8 text", list("text:"+valid, "close:1000"), cfData(text, valid))
+	add("6.2.2", "valid utf8 text, fragmented on code points", list("text:"+valid, "close:1000"),
+		cfFrag(text, "Hello-µ@ß"), cfFrag(cont, "öäüàá"), cfData(cont, "-UTF-8!!"))
+	add("6.2.3", "valid utf8 text, fragmented on every byte", list("text:"+valid, "close:1000"), cfFragments(text, valid, 1)...)
+	add("6.2.4", "valid utf8 text, chopped", list("text:"+valid, "close:1000"), cfData(text, valid)).chop = 1
+	for i, s := range []string{"\xf0\x90\x80\x80", "\xf4\x8f\xbf\xbf", "\xef\xbf\xbf", "\xef\xbb\xbf", "κόσμε"} {
+		add(fmt.Sprintf("6.3.%d", i+1), fmt.Sprintf("valid utf8 % x", s), list("text:"+s, "close:1000"), cfFragments(text, s, 1)...)
+	}
+	invalid := "\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80\x65\x64\x69\x74\x65\x64"
+	add("6.4.1", "invalid utf8 text", list("close:1007"), cfData(text, invalid))
+	add("6.4.2", "invalid utf8 text, fragmented on every byte", list("close:1007"), cfFragments(text, invalid, 1)...)
+	add("6.4.3", "invalid utf8 in the first fragment, fail fast", list("close:1007"), cfFrag(text, "\xce\xba\xe1\xbd\xb9\xf4\x90\x80\x80"))
+	for i, s := range []string{"\xc0\xaf", "\xe0\x80\xaf", "\xf8\x88\x80\x80\xaf", "\xed\xbf\xbf", "\xf4\x90\x80\x80", "\x80", "\xfe", "\xce"} {
+		add(fmt.Sprintf("6.5.%d", i+1), fmt.Sprintf("invalid utf8 % x", s), list("close:1007"), cfData(text, s))
+	}
+	add("6.6.1", "incomplete utf8 at the end of fragments", list("close:1007"), cfFrag(text, "κόσμ"), cfData(cont, "\xce"))
+	// 7 Close handling.
+	add("7.1.1", "text, then close", list("text:Hello", "close:1000"), cfData(text, "Hello"), cfClose(1000, ""))
+	add("7.1.2", "close twice", list("close:1000"), cfClose(1000, ""), cfClose(1000, ""))
+	add("7.1.3", "ping after close", list("close:1000"), cfClose(1000, ""), cfData(ping, ""))
+	add("7.1.4", "text after close", list("close:1000"), cfClose(1000, ""), cfData(text, "Hello"))
+	add("7.1.5", "close between fragments", list("close:1000"), cfFrag(text, "fragment1"), cfClose(1000, ""), cfData(cont, "fragment2"))
+	add("7.3.1", "close without payload", list("close:"), &conformanceFrame{fin: true, op: byte(CodeClose)})
+	add("7.3.2", "close with 1 byte payload", list("close:1002"), &conformanceFrame{fin: true, op: byte(CodeClose), data: []byte{0x03}})
+	add("7.3.3", "close with code", list("close:1000"), cfClose(1000, ""))
+	add("7.3.4", "close with code and reason", list("close:1000"), cfClose(1000, "Hello World!"))
+	add("7.3.5", "close with reason of 123 bytes", list("close:1000"), cfClose(1000, strings.Repeat("*", 123)))
+	add("7.3.6", "close with reason of 124 bytes", list("close:1002"), cfClose(1000, strings.Repeat("*", 124)))
+	add("7.5.1", "close with invalid utf8 reason", list("close:1007"), cfClose(1000, invalid))
+	for i, code := range []uint16{1000, 1001, 1002, 1003, 1007, 1008, 1009, 1010, 1011, 3000, 3999, 4000, 4999} {
+		add(fmt.Sprintf("7.7.%d", i+1), fmt.Sprintf("close with valid code %d", code), list(fmt.Sprintf("close:%d", code)), cfClose(code, ""))
+	}
+	for i, code := range []uint16{0, 999, 1004, 1005, 1006, 1015, 1016, 1100, 2000, 2999, 5000, 65535} {
+		add(fmt.Sprintf("7.9.%d", i+1), fmt.Sprintf("close with invalid code %d", code), list("close:1002"), cfClose(code, ""))
+	}
+	// 9 Limits.
+	for i, n := range []int{64 << 10, 256 << 10, conformanceMaxLen} {
+		s := strings.Repeat("*", n)
+		add(fmt.Sprintf("9.1.%d", i+1), fmt.Sprintf("text message, payload %d", n), list("text:"+s, "close:1000"), cfData(text, s))
+	}
+	for i, n := range []int{64, 4 << 10, 64 << 10} {
+		s := strings.Repeat("\xfe", conformanceMaxLen)
+		add(fmt.Sprintf("9.2.%d", i+1), fmt.Sprintf("binary message of %d, fragments of %d", len(s), n), list("binary:"+s, "close:1000"), cfFragments(binary, s, n)...)
+	}
+	add("9.3.1", "text message bigger than the limit", list("close:1009"), cfData(text, strings.Repeat("*", conformanceMaxLen+1)))
+	add("9.3.2", "fragments bigger than the limit", list("close:1009"), cfFragments(binary, strings.Repeat("*", conformanceMaxLen+1), 64<<10)...)
+	return cases
+}
+
+// Echo data messages.
+type conformanceEcho struct{}
+
+func (conformanceEcho) HandleText(c *Conn, b []byte)   { c.Write(CodeText, b, 0) }
+func (conformanceEcho) HandleBinary(c *Conn, b []byte) { c.Write(CodeBinary, b, 0) }
+func (conformanceEcho) HandleClose(*Conn, []byte)      {}
+func (conformanceEcho) HandlePing(*Conn, []byte)       {}
+func (conformanceEcho) HandlePong(*Conn, []byte)       {}
+
+// Run c on the raw connection of the fuzzing peer, which is the client if client.
+func (c *conformanceCase) run(conn net.Conn, rd *bufio.Reader, client bool) error {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	// Send frames, then close if not yet.
+	go func() {
+		closed := false
+		for _, f := range c.frames {
+			b := f.encode(client)
+			if c.chop > 0 {
+				for len(b) > c.chop {
+					conn.Write(b[:c.chop])
+					b = b[c.chop:]
+				}
+			}
+			conn.Write(b)
+			closed = closed || f.op == byte(CodeClose)
+		}
+		if !closed {
+			conn.Write(cfClose(1000, "").encode(client))
+		}
+	}()
+	// Receive events.
+	var events []string
+	var msg []byte
+	var msgCode Code
+	for {
+		b, err := conformanceReadFrame(rd, &msgCode, &msg, !client)
+		if err != nil {
+			return fmt.Errorf("after %s: %w", conformanceShort(events), err)
+		}
+		if b == "" {
+			continue
+		}
+		events = append(events, b)
+		if strings.HasPrefix(b, "close:") {
+			break
+		}
+	}
+	if len(events) != len(c.expect) {
+		return fmt.Errorf("events %s, want %s", conformanceShort(events), conformanceShort(c.expect))
+	}
+	for i := range events {
+		if events[i] != c.expect[i] {
+			return fmt.Errorf("events %s, want %s", conformanceShort(events), conformanceShort(c.expect))
+		}
+	}
+	// The peer must close the connection.
+	// It may be reset, if the peer closed it without reading all frames.
+	if _, err := io.Copy(ioutil.Discard, rd); err != nil && !errors.Is(err, syscall.ECONNRESET) {
+		return fmt.Errorf("connection is not closed: %w", err)
+	}
+	return nil
+}
+
+// Read a frame, return the event or "" if it is not the last fragment.
+func conformanceReadFrame(rd *bufio.Reader, msgCode *Code, msg *[]byte, masked bool) (string, error) {
+	var b [8]byte
+	_, err := io.ReadFull(rd, b[:2])
+	if err != nil {
+		return "", err
+	}
+	fin, rsv, code := b[0]&_Fin[1] != 0, b[0]&(rsv1Bit|rsv2Bit|rsv3Bit), Code(b[0]&0x0f)
+	if rsv != 0 {
+		return "", fmt.Errorf("reserved bits %x are set", rsv)
+	}
+	if (b[1]&_Mask[1] != 0) != masked {
+		return "", fmt.Errorf("frame mask bit is %v", !masked)
+	}
+	n := uint64(b[1] & 0x7f)
+	switch n {
+	case 126:
+		_, err = io.ReadFull(rd, b[:2])
+		n = uint64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		_, err = io.ReadFull(rd, b[:8])
+		n = binary.BigEndian.Uint64(b[:8])
+	}
+	if err != nil {
+		return "", err
+	}
+	var key [4]byte
+	if masked {
+		_, err = io.ReadFull(rd, key[:])
+		if err != nil {
+			return "", err
+		}
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(rd, data)
+	if err != nil {
+		return "", err
+	}
+	if masked {
+		maskData(data, key[:])
+	}
+	switch code {
+	case CodePong:
+		return "pong:" + string(data), nil
+	case CodeClose:
+		if len(data) < 2 {
+			return "close:", nil
+		}
+		return fmt.Sprintf("close:%d", binary.BigEndian.Uint16(data)), nil
+	case CodeText, CodeBinary:
+		*msgCode, *msg = code, data
+	case codeContinuation:
+		*msg = append(*msg, data...)
+	default:
+		return "", fmt.Errorf("unexpected opcode %d", code)
+	}
+	if !fin {
+		return "", nil
+	}
+	return fmt.Sprintf("%s:%s", msgCode.String(), *msg), nil
+}
+
+// Shorten long events for error messages.
+func conformanceShort(events []string) string {
+	s := make([]string, len(events))
+	for i, e := range events {
+		if len(e) > 40 {
+			e = fmt.Sprintf("%s...(%d bytes)", e[:32], len(e))
+		}
+		s[i] = fmt.Sprintf("%q", e)
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// Report passed count.
+func conformanceRun(t *testing.T, role string, run func(*testing.T, *conformanceCase)) {
+	cases := conformanceCases()
+	passed := 0
+	for _, c := range cases {
+		if t.Run(c.id+" "+c.desc, func(t *testing.T) { run(t, c) }) {
+			passed++
+		}
+	}
+	t.Logf("%s: %d/%d cases passed", role, passed, len(cases))
+}
+
+// The fuzzing peer is a raw client, Accept is under test.
+func Test_Conformance_Server(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(conformanceEcho{}, &ServeOptions{MaxMessageLength: conformanceMaxLen}))
+	defer srv.Close()
+	conformanceRun(t, "server", func(t *testing.T, c *conformanceCase) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		key := GenSecWebSocketKey()
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", key)
+		req.Write(conn)
+		rd := bufio.NewReader(conn)
+		res, err := http.ReadResponse(rd, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-WebSocket-Accept") != GenSecWebSocketAccept(key) {
+			t.Fatal(res.Status)
+		}
+		err = c.run(conn, rd, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// The fuzzing peer is a raw server, Dialer is under test.
+func Test_Conformance_Client(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conformanceRun(t, "client", func(t *testing.T, c *conformanceCase) {
+		// Client under test.
+		go func() {
+			conn, _, err := new(Dialer).Dial("ws://"+l.Addr().String()+"/", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.ReadLoop(conformanceMaxLen, func(code Code, b []byte) error {
+				if code == CodeText || code == CodeBinary {
+					return conn.Write(code, b, 0)
+				}
+				return nil
+			})
+			conn.Close()
+		}()
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		rd := bufio.NewReader(conn)
+		req, err := http.ReadRequest(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		fmt.Fprintf(&b, "Sec-WebSocket-Accept: %s\r\n\r\n", GenSecWebSocketAccept(req.Header.Get("Sec-Websocket-Key")))
+		conn.Write(b.Bytes())
+		err = c.run(conn, rd, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -2,8 +2,13 @@ package socket
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 type Code byte
@@ -37,8 +42,89 @@ const (
 var (
 	_Fin  = [2]byte{0x00, 0x80}
 	_Mask = [2]byte{0x00, 0x80}
+	// Write after a close frame was sent.
+	ErrCloseSent  = errors.New("websocket: close frame was sent")
+	errNoDeadline = errors.New("websocket: connection does not support deadline")
 )
 
+// Bits of the first header byte.
+const (
+	rsv1Bit = 0x40
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+)
+
+// Max payload length of control frames.
+const maxControlPayload = 125
+
+func (c Code) isControl() bool {
+	return c >= CodeClose
+}
+
+func (c Code) isValid() bool {
+	switch c {
+	case codeContinuation, CodeText, CodeBinary, CodeClose, CodePing, CodePong:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status code of a close frame, RFC 6455 7.4.
+type CloseCode uint16
+
+const (
+	CloseNormalClosure      CloseCode = 1000
+	CloseGoingAway          CloseCode = 1001
+	CloseProtocolError      CloseCode = 1002
+	CloseUnsupportedData    CloseCode = 1003
+	CloseNoStatusReceived   CloseCode = 1005
+	CloseAbnormalClosure    CloseCode = 1006
+	CloseInvalidPayload     CloseCode = 1007
+	ClosePolicyViolation    CloseCode = 1008
+	CloseMessageTooBig      CloseCode = 1009
+	CloseMandatoryExtension CloseCode = 1010
+	CloseInternalError      CloseCode = 1011
+)
+
+// Reports whether code can be sent in a close frame.
+func (c CloseCode) isValid() bool {
+	switch {
+	case c >= 1000 && c <= 1003, c >= 1007 && c <= 1014:
+		return true
+	case c >= 3000 && c <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseError is returned by ReadLoop when a close frame is received,
+// the close handshake has been answered.
+// Code is CloseAbnormalClosure if heartbeat closed the connection, see StartHeartbeat.
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: close %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Reason)
+}
+
+// ProtocolError is returned by ReadLoop when the peer's data is not acceptable,
+// a close frame with Code has been sent to the peer.
+type ProtocolError struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("websocket: %s(%d)", e.Reason, e.Code)
+}
+
 // Mask data with key
 func maskData(data, key []byte) {
 	for i := 0; i < len(data); i++ {
@@ -48,15 +134,122 @@ func maskData(data, key []byte) {
 
 type Conn struct {
 	conn io.ReadWriteCloser
+	// Read from r, it may be a bufio.Reader of conn, which has buffered data.
+	r    io.Reader
 	mask byte
+	// Serializes data messages, control frames may be sent between fragments.
+	mmu sync.Mutex
+	// Serializes frames.
+	wmu sync.Mutex
+	// Close frame was sent.
+	closeSent bool
+	// Deadline of waiting for a frame, 0 means no deadline.
+	readTimeout time.Duration
+	// Deadline of writing a frame, 0 means no deadline.
+	writeTimeout time.Duration
+	// Negotiated permessage-deflate, nil means not negotiated.
+	compress *compression
+	// Outbound queue, nil means StartQueue was not called.
+	queue *sendQueue
+	// Max message length of NextReader, <=0 means no limit.
+	readLimit int64
+	// Reader of the current message.
+	reader io.Reader
+	// Sticky error of reading.
+	readErr error
+	// Negotiated subprotocol.
+	subprotocol string
+	// Counters of Stats.
+	stats *connStats
+	// Nil means StartHeartbeat was not called.
+	heartbeat *heartbeat
 }
 
-// Write code type data.
-// If data length bigger than payload, it will be split into multiple frames.
+// Use for setting deadlines, net.Conn implements it.
+type deadlineConn interface {
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// Set the read deadline of the underlying connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	d, ok := c.conn.(deadlineConn)
+	if !ok {
+		return errNoDeadline
+	}
+	return d.SetReadDeadline(t)
+}
+
+// Set the write deadline of the underlying connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	d, ok := c.conn.(deadlineConn)
+	if !ok {
+		return errNoDeadline
+	}
+	return d.SetWriteDeadline(t)
+}
+
+// ReadLoop set the read deadline to now+d before reading every frame, 0 means no deadline.
+func (c *Conn) SetReadTimeout(d time.Duration) {
+	c.readTimeout = d
+}
+
+// Set the write deadline to now+d before writing every frame, 0 means no deadline.
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	c.wmu.Lock()
+	c.writeTimeout = d
+	c.wmu.Unlock()
+}
+
+// Create a Conn, r is the reader of conn, nil means conn.
+func newConn(conn io.ReadWriteCloser, r io.Reader, mask byte) *Conn {
+	if r == nil {
+		r = conn
+	}
+	c := &Conn{conn: conn, mask: mask, stats: new(connStats)}
+	c.r = &countReader{r: r, n: &c.stats.bytesIn}
+	return c
+}
+
+// Write code type data, it is safe for concurrent use.
+// If data length bigger than payload, it will be split into multiple frames,
+// payload <= 0 means never split.
+// If permessage-deflate was negotiated and enabled, data which is not shorter than threshold is compressed.
 func (c *Conn) Write(code Code, data []byte, payload int) error {
-	if len(data) <= payload {
+	compress := false
+	if c.compress != nil && !code.isControl() {
+		c.compress.mu.Lock()
+		compress = c.compress.enabled && len(data) >= c.compress.threshold
+		c.compress.mu.Unlock()
+	}
+	return c.WriteCompress(code, data, payload, compress)
+}
+
+// Same as Write, but compress data or not by compress, regardless of the connection settings.
+// It is ignored if permessage-deflate was not negotiated, or code is a control frame.
+func (c *Conn) WriteCompress(code Code, data []byte, payload int, compress bool) error {
+	if code.isControl() {
+		if len(data) > maxControlPayload {
+			return fmt.Errorf(`"%s" data length %d is bigger than %d`, code.String(), len(data), maxControlPayload)
+		}
 		return c.writeFrame(_Fin[1], code, data)
 	}
+	// Frames of a message must not interleave with others.
+	// And compress then send in order, or the peer can not decompress with context takeover.
+	c.mmu.Lock()
+	defer c.mmu.Unlock()
+	var rsv byte
+	if compress && c.compress != nil {
+		var err error
+		data, err = c.compress.Compress(data)
+		if err != nil {
+			return err
+		}
+		rsv = rsv1Bit
+	}
+	if payload <= 0 || len(data) <= payload {
+		return c.writeFrame(_Fin[1]|rsv, code, data)
+	}
 	switch code {
 	case CodeBinary, CodeText:
 	default:
@@ -65,7 +258,7 @@ func (c *Conn) Write(code Code, data []byte, payload int) error {
 	// If length of data bigger than c.maxSize[1] split into frames.
 	p := data
 	// First frame, fin=0, code!=0.
-	err := c.writeFrame(_Fin[0], code, p[:payload])
+	err := c.writeFrame(_Fin[0]|rsv, code, p[:payload])
 	if err != nil {
 		return err
 	}
@@ -82,23 +275,67 @@ func (c *Conn) Write(code Code, data []byte, payload int) error {
 	return c.writeFrame(_Fin[1], codeContinuation, p)
 }
 
-// Write a frame.
-func (c *Conn) writeFrame(fin byte, code Code, data []byte) error {
+// Return the negotiated subprotocol, empty means none.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Reports whether permessage-deflate was negotiated.
+func (c *Conn) CompressionNegotiated() bool {
+	return c.compress != nil
+}
+
+// Enable or disable compression of Write, default is enabled if permessage-deflate was negotiated.
+func (c *Conn) EnableWriteCompression(enable bool) {
+	if c.compress != nil {
+		c.compress.mu.Lock()
+		c.compress.enabled = enable
+		c.compress.mu.Unlock()
+	}
+}
+
+// Messages shorter than n are sent uncompressed by Write.
+func (c *Conn) SetCompressionThreshold(n int) {
+	if c.compress != nil {
+		c.compress.mu.Lock()
+		c.compress.threshold = n
+		c.compress.mu.Unlock()
+	}
+}
+
+// Write a close frame with code and reason, it does not close the connection.
+func (c *Conn) WriteClose(code CloseCode, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		// Do not split a rune, the peer fails invalid UTF-8 with 1007.
+		n := maxControlPayload - 2
+		for n > 0 && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+		reason = reason[:n]
+	}
+	var b [maxControlPayload]byte
+	binary.BigEndian.PutUint16(b[:], uint16(code))
+	n := 2 + copy(b[2:], reason)
+	return c.writeFrame(_Fin[1], CodeClose, b[:n])
+}
+
+// Write a frame, bits are fin and rsv bits.
+func (c *Conn) writeFrame(bits byte, code Code, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if code == CodeClose {
+		c.closeSent = true
+	}
+	if c.writeTimeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
 	b := encodeBufferPool.Get().(*encodeBuffer)
 	b.Reset()
-	// Encode fin and code.
-	b.Put8(fin | byte(code))
-	// Encode mask and payload length.
+	b.PutFrameHeader(bits, code, len(data), c.mask)
 	payload := len(data)
-	if payload < 126 {
-		b.Put8(c.mask | byte(payload))
-	} else if payload <= 0xffff {
-		b.Put8(c.mask | 126)
-		b.Put16(uint16(payload))
-	} else {
-		b.Put8(c.mask | 127)
-		b.Put64(uint64(payload))
-	}
 	// Encode mask key.
 	if c.mask != 0 {
 		// Append random key.
@@ -108,96 +345,233 @@ func (c *Conn) writeFrame(fin byte, code Code, data []byte) error {
 		// Append mask data.
 		dataIdx := b.len
 		b.PutBytes(data)
-		maskData(b.buf[dataIdx:], b.buf[keyIdx1:keyIdx2])
+		maskData(b.buf[dataIdx:b.len], b.buf[keyIdx1:keyIdx2])
 		// Write buffer.
-		_, err := c.conn.Write(b.buf[:b.len])
+		n, err := c.conn.Write(b.buf[:b.len])
 		encodeBufferPool.Put(b)
+		c.countWrite(n, bits, code, err)
 		return err
 	}
-	// Write header.
-	_, err := c.conn.Write(b.buf[:b.len])
-	if err != nil {
+	// Small frame, write once.
+	if payload < 126 {
+		b.PutBytes(data)
+		n, err := c.conn.Write(b.buf[:b.len])
 		encodeBufferPool.Put(b)
+		c.countWrite(n, bits, code, err)
 		return err
 	}
+	// Write header.
+	n, err := c.conn.Write(b.buf[:b.len])
 	encodeBufferPool.Put(b)
+	if err != nil {
+		c.countWrite(n, bits, code, err)
+		return err
+	}
 	// Write data.
-	_, err = c.conn.Write(data)
+	m, err := c.conn.Write(data)
+	c.countWrite(n+m, bits, code, err)
 	return err
 }
 
-// Read a complete message then call handle.
-// If message length bigger than maxLen, it return error.
-func (c *Conn) ReadLoop(maxLen int, handle func(Code, []byte) error) error {
-	var (
-		fin     byte
-		code    Code
-		mask    byte
-		length  int
-		err     error
-		header  [8]byte
-		key     [4]byte
-		payload [16]readBuffer
-		b       *readBuffer
-	)
-	for {
-		// Read header.
-		_, err = io.ReadFull(c.conn, header[:2])
+// Update Stats after writing n bytes of a frame, a data frame with fin bit ends a message.
+func (c *Conn) countWrite(n int, bits byte, code Code, err error) {
+	atomic.AddInt64(&c.stats.bytesOut, int64(n))
+	if err != nil {
+		return
+	}
+	atomic.StoreInt64(&c.stats.lastWrite, time.Now().UnixNano())
+	if bits&_Fin[1] != 0 && !code.isControl() {
+		atomic.AddInt64(&c.stats.messagesOut, 1)
+	}
+}
+
+// Write encoded frames of a code message.
+func (c *Conn) writeRaw(code Code, frames []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if c.writeTimeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	n, err := c.conn.Write(frames)
+	c.countWrite(n, _Fin[1], code, err)
+	return err
+}
+
+// Decoded frame header.
+type frameHeader struct {
+	fin    bool
+	rsv    byte
+	code   Code
+	masked bool
+	length int64
+	key    [4]byte
+}
+
+// Read and check a frame header, return *ProtocolError if the header is not acceptable.
+func (c *Conn) readHeader(h *frameHeader) error {
+	if c.readTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	var b [8]byte
+	_, err := io.ReadFull(c.r, b[:2])
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&c.stats.lastRead, time.Now().UnixNano())
+	// Decode fin, rsv and code.
+	h.fin = b[0]&_Fin[1] != 0
+	h.rsv = b[0] & (rsv1Bit | rsv2Bit | rsv3Bit)
+	h.code = Code(b[0] & 0x0f)
+	// Decode mask
+	h.masked = b[1]&_Mask[1] != 0
+	// Decode length
+	h.length = int64(b[1] & 0x7f)
+	switch h.length {
+	case 126:
+		_, err = io.ReadFull(c.r, b[:2])
 		if err != nil {
 			return err
 		}
-		// Decode fin
-		fin = header[0] & _Fin[1]
-		// Decode code
-		code = Code(header[0] & 0x0f)
-		// Decode mask
-		mask = header[1] & _Mask[1]
-		// Decode length
-		length = int(header[1] & 0x7f)
-		switch length {
-		case 126:
-			_, err = io.ReadFull(c.conn, header[:2])
-			if err != nil {
-				return err
-			}
-			length = int(binary.BigEndian.Uint16(header[:]))
-		case 127:
-			_, err = io.ReadFull(c.conn, header[:8])
-			if err != nil {
-				return err
-			}
-			length = int(binary.BigEndian.Uint64(header[:]))
+		h.length = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		_, err = io.ReadFull(c.r, b[:8])
+		if err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint64(b[:])
+		if n>>63 != 0 {
+			return c.fail(CloseProtocolError, "invalid payload length")
+		}
+		h.length = int64(n)
+	}
+	// Decode key
+	if h.masked {
+		_, err = io.ReadFull(c.r, h.key[:])
+		if err != nil {
+			return err
+		}
+	}
+	// Check.
+	if h.rsv == rsv1Bit && c.compress != nil && (h.code == CodeText || h.code == CodeBinary) {
+		// Compressed message.
+	} else if h.rsv != 0 {
+		return c.fail(CloseProtocolError, "reserved bits are set")
+	}
+	if !h.code.isValid() {
+		return c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", h.code))
+	}
+	if h.code.isControl() {
+		if !h.fin {
+			return c.fail(CloseProtocolError, "fragmented control frame")
+		}
+		if h.length > maxControlPayload {
+			return c.fail(CloseProtocolError, "control frame payload too long")
+		}
+	}
+	// Client must mask, server must not.
+	if h.masked != (c.mask == 0) {
+		if h.masked {
+			return c.fail(CloseProtocolError, "masked frame from server")
+		}
+		return c.fail(CloseProtocolError, "unmasked frame from client")
+	}
+	return nil
+}
+
+// Send a close frame with code and reason, then return *ProtocolError.
+func (c *Conn) fail(code CloseCode, reason string) error {
+	c.WriteClose(code, reason)
+	return &ProtocolError{Code: code, Reason: reason}
+}
+
+// Handle a control frame, return *CloseError when data is a close frame.
+func (c *Conn) handleControl(code Code, data []byte, handle func(Code, []byte) error) error {
+	switch code {
+	case CodePing:
+		err := c.writeFrame(_Fin[1], CodePong, data)
+		if err != nil && err != ErrCloseSent {
+			return err
 		}
-		// Decode key
-		if mask != 0 {
-			_, err = io.ReadFull(c.conn, key[:])
-			if err != nil {
-				return err
+		return handle(code, data)
+	case CodePong:
+		if c.heartbeat != nil {
+			if rtt, ok := c.heartbeat.pong(); ok {
+				atomic.StoreInt64(&c.stats.rtt, int64(rtt))
+				atomic.AddInt64(&c.stats.pongsReceived, 1)
 			}
 		}
-		// Read payload.
-		b = &payload[code]
-		idx := b.len
-		err = b.ReadN(c.conn, length)
+		return handle(code, data)
+	}
+	// Close frame.
+	e := &CloseError{Code: CloseNoStatusReceived}
+	if len(data) > 0 {
+		if len(data) < 2 {
+			return c.fail(CloseProtocolError, "invalid close payload")
+		}
+		e.Code = CloseCode(binary.BigEndian.Uint16(data))
+		if !e.Code.isValid() {
+			return c.fail(CloseProtocolError, fmt.Sprintf("invalid close code %d", e.Code))
+		}
+		if !utf8.Valid(data[2:]) {
+			return c.fail(CloseInvalidPayload, "invalid utf8 close reason")
+		}
+		e.Reason = string(data[2:])
+	}
+	err := handle(code, data)
+	if err != nil {
+		return err
+	}
+	// Answer the close handshake, the peer may have gone, ignore error.
+	if e.Code == CloseNoStatusReceived {
+		c.writeFrame(_Fin[1], CodeClose, nil)
+	} else {
+		c.writeFrame(_Fin[1], CodeClose, data[:2])
+	}
+	return e
+}
+
+// Read a complete message then call handle, compressed message is decompressed.
+// Ping frame is answered with a pong frame, close frame is answered with a close frame,
+// both are passed to handle after answering, then close frame ends the loop with *CloseError.
+// If message length bigger than maxLen(<=0 means no limit), or the peer violates the protocol,
+// it sends a close frame and return *ProtocolError.
+// If handle return error, it stops and return the error.
+// Data passed to handle is valid until handle returns.
+func (c *Conn) ReadLoop(maxLen int, handle func(Code, []byte) error) error {
+	var msg readBuffer
+	for {
+		code, r, err := c.nextReader(int64(maxLen), handle)
 		if err != nil {
 			return err
 		}
-		// Mask payload data.
-		if mask != 0 {
-			maskData(b.buf[idx:b.len], key[:])
+		msg.Reset()
+		err = msg.ReadAll(r)
+		if err != nil {
+			return err
 		}
-		// Last frame of a message
-		if fin == _Fin[1] {
-			// Call back handle.
-			handle(code, b.buf[:b.len])
-			// Reset buffer.
-			b.Reset()
+		// Call back handle.
+		err = handle(code, msg.buf[:msg.len])
+		if err != nil {
+			return err
 		}
 	}
 }
 
-// Write a CodeClose frame, then call Closer.
+// Max value of int.
+const maxInt = int64(^uint(0) >> 1)
+
+// Write a close frame(if not sent), then close the connection.
+// Messages in the send queue are dropped, heartbeat is stopped.
 func (c *Conn) Close() error {
-	c.Write(CodeClose, nil, 128)
+	if c.queue != nil {
+		c.queue.stop(ErrQueueClosed)
+	}
+	if c.heartbeat != nil {
+		c.heartbeat.stop()
+	}
+	c.WriteClose(CloseNormalClosure, "")
 	return c.conn.Close()
 }
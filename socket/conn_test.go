@@ -0,0 +1,255 @@
+package socket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Encode a client frame.
+func test_Frame(first byte, data []byte) []byte {
+	var b encodeBuffer
+	b.Put8(first)
+	if len(data) < 126 {
+		b.Put8(0x80 | byte(len(data)))
+	} else {
+		b.Put8(0x80 | 126)
+		b.Put16(uint16(len(data)))
+	}
+	key := []byte{1, 2, 3, 4}
+	b.PutBytes(key)
+	i := b.len
+	b.PutBytes(data)
+	maskData(b.buf[i:b.len], key)
+	return b.buf[:b.len]
+}
+
+type test_Message struct {
+	code Code
+	data string
+}
+
+// A server Conn and a raw client.
+type test_Peer struct {
+	t *testing.T
+	// Server side.
+	conn *Conn
+	// Messages received by server handle.
+	msgs []test_Message
+	err  chan error
+	// Client side.
+	client net.Conn
+	// Frames received by client.
+	frames chan test_Message
+}
+
+func newTestPeer(t *testing.T, maxLen int, handle func(Code, []byte) error) *test_Peer {
+	p := new(test_Peer)
+	p.t = t
+	p.err = make(chan error, 1)
+	p.frames = make(chan test_Message, 16)
+	var s net.Conn
+	s, p.client = net.Pipe()
+	p.conn = newConn(s, nil, _Mask[0])
+	go func() {
+		p.err <- p.conn.ReadLoop(maxLen, func(c Code, b []byte) error {
+			p.msgs = append(p.msgs, test_Message{c, string(b)})
+			if handle != nil {
+				return handle(c, b)
+			}
+			return nil
+		})
+		s.Close()
+	}()
+	go func() {
+		c := newConn(p.client, nil, _Mask[1])
+		var h frameHeader
+		for {
+			if c.readHeader(&h) != nil {
+				close(p.frames)
+				return
+			}
+			b := make([]byte, h.length)
+			if _, err := io.ReadFull(p.client, b); err != nil {
+				close(p.frames)
+				return
+			}
+			p.frames <- test_Message{h.code, string(b)}
+		}
+	}()
+	return p
+}
+
+func (p *test_Peer) Write(frames ...[]byte) {
+	for _, f := range frames {
+		p.client.Write(f)
+	}
+}
+
+// Wait server ReadLoop return.
+func (p *test_Peer) Wait() error {
+	err := <-p.err
+	p.client.Close()
+	return err
+}
+
+func (p *test_Peer) Frame(code Code, data string) {
+	f, ok := <-p.frames
+	if !ok || f.code != code || f.data != data {
+		p.t.Fatalf("frame %v %q, want %v %q", f.code, f.data, code, data)
+	}
+}
+
+// Expect server sent close with code, and ReadLoop returned *ProtocolError.
+func (p *test_Peer) Fail(code CloseCode) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(code))
+	f := <-p.frames
+	if f.code != CodeClose || f.data[:2] != string(b[:]) {
+		p.t.Fatalf("frame %v %q, want close %d", f.code, f.data, code)
+	}
+	var e *ProtocolError
+	err := p.Wait()
+	if !errors.As(err, &e) || e.Code != code {
+		p.t.Fatal(err)
+	}
+}
+
+func Test_Conn_ReadLoop_Control(t *testing.T) {
+	p := newTestPeer(t, 0, nil)
+	p.Write(test_Frame(0x89, []byte("ping")))
+	p.Frame(CodePong, "ping")
+	// Ping between fragments.
+	p.Write(test_Frame(0x01, []byte("he")), test_Frame(0x89, nil), test_Frame(0x80, []byte("llo")))
+	p.Frame(CodePong, "")
+	// Close handshake.
+	p.Write(test_Frame(0x88, []byte("\x03\xe8bye")))
+	p.Frame(CodeClose, "\x03\xe8")
+	var e *CloseError
+	err := p.Wait()
+	if !errors.As(err, &e) || e.Code != CloseNormalClosure || e.Reason != "bye" {
+		t.Fatal(err)
+	}
+	if len(p.msgs) != 4 || p.msgs[1] != (test_Message{CodePing, ""}) || p.msgs[2] != (test_Message{CodeText, "hello"}) {
+		t.Fatal(p.msgs)
+	}
+	// Close without status.
+	p = newTestPeer(t, 0, nil)
+	p.Write(test_Frame(0x88, nil))
+	p.Frame(CodeClose, "")
+	err = p.Wait()
+	if !errors.As(err, &e) || e.Code != CloseNoStatusReceived {
+		t.Fatal(err)
+	}
+}
+
+func Test_Conn_ReadLoop_Fail(t *testing.T) {
+	big := make([]byte, 126)
+	for _, c := range []struct {
+		frames [][]byte
+		code   CloseCode
+	}{
+		// Fragmented control frame.
+		{[][]byte{test_Frame(0x09, nil)}, CloseProtocolError},
+		// Control frame too long.
+		{[][]byte{test_Frame(0x89, big)}, CloseProtocolError},
+		// Reserved bits.
+		{[][]byte{test_Frame(0xc1, nil)}, CloseProtocolError},
+		// Unknown opcode.
+		{[][]byte{test_Frame(0x83, nil)}, CloseProtocolError},
+		// Continuation without start.
+		{[][]byte{test_Frame(0x80, nil)}, CloseProtocolError},
+		// New message during fragments.
+		{[][]byte{test_Frame(0x01, nil), test_Frame(0x81, nil)}, CloseProtocolError},
+		// Invalid close payload.
+		{[][]byte{test_Frame(0x88, []byte{3})}, CloseProtocolError},
+		{[][]byte{test_Frame(0x88, []byte{3, 0xed})}, CloseProtocolError},
+		{[][]byte{test_Frame(0x88, []byte{3, 0xe8, 0xff})}, CloseInvalidPayload},
+		// Unmasked.
+		{[][]byte{{0x81, 0}}, CloseProtocolError},
+		// Message too big, across continuation frames.
+		{[][]byte{test_Frame(0x01, big[:8]), test_Frame(0x80, big[:3])}, CloseMessageTooBig},
+		// Invalid utf8 text.
+		{[][]byte{test_Frame(0x81, []byte("a\xff"))}, CloseInvalidPayload},
+		{[][]byte{test_Frame(0x81, []byte("\xed\xa0\x80"))}, CloseInvalidPayload},
+		{[][]byte{test_Frame(0x01, []byte("\xe2")), test_Frame(0x80, []byte("\x82a"))}, CloseInvalidPayload},
+		// Incomplete at the end.
+		{[][]byte{test_Frame(0x01, []byte("\xe2\x82")), test_Frame(0x80, nil)}, CloseInvalidPayload},
+	} {
+		p := newTestPeer(t, 10, nil)
+		p.Write(c.frames...)
+		p.Fail(c.code)
+	}
+}
+
+func Test_Conn_ReadLoop_UTF8(t *testing.T) {
+	p := newTestPeer(t, 0, nil)
+	// "€" and "é" split across frames.
+	p.Write(test_Frame(0x01, []byte("\xe2")), test_Frame(0x00, []byte("\x82")), test_Frame(0x00, []byte("\xac\xc3")), test_Frame(0x80, []byte("\xa9")))
+	// Binary is not checked.
+	p.Write(test_Frame(0x82, []byte{0xff}))
+	p.Write(test_Frame(0x88, nil))
+	p.Frame(CodeClose, "")
+	p.Wait()
+	if len(p.msgs) != 3 || p.msgs[0] != (test_Message{CodeText, "€é"}) || p.msgs[1] != (test_Message{CodeBinary, "\xff"}) {
+		t.Fatal(p.msgs)
+	}
+}
+
+func Test_Conn_ReadLoop_HandleError(t *testing.T) {
+	errStop := errors.New("stop")
+	p := newTestPeer(t, 0, func(Code, []byte) error { return errStop })
+	p.Write(test_Frame(0x82, []byte("x")))
+	if err := p.Wait(); err != errStop {
+		t.Fatal(err)
+	}
+}
+
+func Test_Conn_Write(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	client := newConn(c, nil, _Mask[1])
+	go func() {
+		client.Write(CodeText, []byte("hello world"), 4)
+		client.Close()
+	}()
+	var msgs []test_Message
+	err := server.ReadLoop(0, func(c Code, b []byte) error {
+		msgs = append(msgs, test_Message{c, string(b)})
+		return nil
+	})
+	var e *CloseError
+	if !errors.As(err, &e) || e.Code != CloseNormalClosure {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0] != (test_Message{CodeText, "hello world"}) {
+		t.Fatal(msgs)
+	}
+	if client.Write(CodeText, nil, 0) != ErrCloseSent {
+		t.FailNow()
+	}
+}
+
+func Test_Conn_WriteClose_Truncate(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	client := newConn(c, nil, _Mask[1])
+	// 2 bytes a rune, 123 bytes is in the middle of a rune.
+	reason := strings.Repeat("é", 100)
+	go func() {
+		client.WriteClose(CloseNormalClosure, reason)
+		client.Close()
+	}()
+	err := server.ReadLoop(0, func(c Code, b []byte) error { return nil })
+	var e *CloseError
+	if !errors.As(err, &e) || e.Code != CloseNormalClosure {
+		t.Fatal(err)
+	}
+	if e.Reason != reason[:122] || !utf8.ValidString(e.Reason) {
+		t.Fatal(e.Reason)
+	}
+}
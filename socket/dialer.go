@@ -0,0 +1,335 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Headers set by Dialer, they can not be set by the caller.
+var dialerReservedHeader = []string{
+	"Upgrade",
+	"Connection",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Extensions",
+	"Sec-Websocket-Protocol",
+}
+
+// Dialer with proxy from environment and 45 seconds handshake timeout.
+var DefaultDialer = &Dialer{
+	Proxy:            http.ProxyFromEnvironment,
+	HandshakeTimeout: 45 * time.Second,
+}
+
+// Dialer connects to ws:// or wss:// URLs.
+type Dialer struct {
+	// Create TCP connections, nil means net.Dialer.
+	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Config of wss connections, nil means the default config.
+	// ServerName is the URL host if empty.
+	TLSConfig *tls.Config
+	// Return the proxy URL of a request, the request URL is the http:// or https:// form of the ws URL.
+	// Nil function or nil URL means no proxy. Only http proxies are supported, by CONNECT method.
+	Proxy func(*http.Request) (*url.URL, error)
+	// Max time of connecting, proxy CONNECT, TLS and websocket handshake, 0 means no limit.
+	HandshakeTimeout time.Duration
+	// Offer subprotocols in order of preference, the chosen one is Conn.Subprotocol.
+	Subprotocols []string
+	// Offer permessage-deflate, nil means do not offer.
+	Compression *CompressionOptions
+	// Max count of redirects followed, 0 means 10, <0 means do not follow.
+	// Redirect from wss:// to ws:// is not followed.
+	MaxRedirects int
+}
+
+// Same as DialContext with context.Background().
+func (d *Dialer) Dial(urlStr string, header http.Header) (*Conn, *http.Response, error) {
+	return d.DialContext(context.Background(), urlStr, header)
+}
+
+// Connect to urlStr, then handshake with header, header can be nil.
+// Header can not contain the websocket handshake headers, Dialer sets them,
+// "Host" header is used as the request host.
+// If the handshake fails, the response is returned with ErrBadHandshake if it was read,
+// its body contains at most 1KB of the response body.
+func (d *Dialer) DialContext(ctx context.Context, urlStr string, header http.Header) (*Conn, *http.Response, error) {
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+	// Keys of header may be not canonical.
+	for k := range header {
+		k = http.CanonicalHeaderKey(k)
+		for _, r := range dialerReservedHeader {
+			if k == r {
+				return nil, nil, fmt.Errorf("websocket: header %q can not be set", k)
+			}
+		}
+	}
+	maxRedirects := d.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = 10
+	}
+	u, err := parseWebSocketURL(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := 0; ; i++ {
+		conn, res, err := d.dial(ctx, u, header)
+		if err == nil || res == nil {
+			return conn, res, err
+		}
+		// Redirect.
+		switch res.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, res, err
+		}
+		if maxRedirects < 0 {
+			return nil, res, err
+		}
+		if i >= maxRedirects {
+			return nil, res, fmt.Errorf("websocket: stopped after %d redirects", maxRedirects)
+		}
+		loc, err := res.Location()
+		if err != nil {
+			return nil, res, err
+		}
+		prev := u
+		u, err = parseWebSocketURL(loc.String())
+		if err != nil {
+			return nil, res, err
+		}
+		if prev.Scheme == "wss" && u.Scheme == "ws" {
+			return nil, res, fmt.Errorf("websocket: redirect from wss to %q", u)
+		}
+		if strings.EqualFold(u.Host, prev.Host) {
+			// Location is resolved without the userinfo.
+			if u.User == nil {
+				u.User = prev.User
+			}
+		} else {
+			// Do not send credentials to another host, the same as net/http.
+			header = redirectHeader(header)
+		}
+	}
+}
+
+// Headers removed when redirecting to another host.
+var dialerSensitiveHeader = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Www-Authenticate",
+	"Host",
+}
+
+// Return a copy of header without dialerSensitiveHeader.
+func redirectHeader(header http.Header) http.Header {
+	h := make(http.Header, len(header))
+Loop:
+	for k, v := range header {
+		ck := http.CanonicalHeaderKey(k)
+		for _, s := range dialerSensitiveHeader {
+			if ck == s {
+				continue Loop
+			}
+		}
+		h[k] = v
+	}
+	return h
+}
+
+// Parse a ws, wss, http or https URL, return the ws or wss URL.
+func parseWebSocketURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("websocket: unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("websocket: URL %q has no host", s)
+	}
+	// Fragment is not allowed.
+	u.Fragment = ""
+	return u, nil
+}
+
+// Return host:port of u, port is the default port of scheme if missing.
+func urlAddr(u *url.URL, tlsPort bool) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if tlsPort {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// Connect and handshake once.
+func (d *Dialer) dial(ctx context.Context, u *url.URL, header http.Header) (*Conn, *http.Response, error) {
+	// Request.
+	httpURL := *u
+	httpURL.Scheme = "http"
+	if u.Scheme == "wss" {
+		httpURL.Scheme = "https"
+	}
+	req, err := http.NewRequest(http.MethodGet, httpURL.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			if len(v) > 0 {
+				req.Host = v[0]
+			}
+			continue
+		}
+		req.Header[k] = v
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), password)
+		req.URL.User = nil
+	}
+	// Connect.
+	netDial := d.NetDial
+	if netDial == nil {
+		var nd net.Dialer
+		netDial = nd.DialContext
+	}
+	addr := urlAddr(u, u.Scheme == "wss")
+	var proxy *url.URL
+	if d.Proxy != nil {
+		proxy, err = d.Proxy(req)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var conn net.Conn
+	if proxy != nil {
+		if proxy.Scheme != "http" {
+			return nil, nil, fmt.Errorf("websocket: unsupported proxy scheme %q", proxy.Scheme)
+		}
+		conn, err = netDial(ctx, "tcp", urlAddr(proxy, false))
+	} else {
+		conn, err = netDial(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// Interrupt the handshake when ctx is done.
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+		close(stopped)
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, res, err := d.handshake(conn, proxy, addr, u, req)
+	close(done)
+	<-stopped
+	if err != nil {
+		conn.Close()
+		// Report the reason of timeout or cancel.
+		if res == nil {
+			var ne net.Error
+			if _, ok := ctx.Deadline(); ok && errors.As(err, &ne) && ne.Timeout() {
+				// The deadline of conn is the deadline of ctx.
+				<-ctx.Done()
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+		}
+		return nil, res, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, res, nil
+}
+
+// Proxy CONNECT, TLS and websocket handshake on conn.
+func (d *Dialer) handshake(conn net.Conn, proxy *url.URL, addr string, u *url.URL, req *http.Request) (*Conn, *http.Response, error) {
+	if proxy != nil {
+		err := proxyConnect(conn, proxy, addr)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if u.Scheme == "wss" {
+		cfg := d.TLSConfig
+		if cfg == nil {
+			cfg = new(tls.Config)
+		} else {
+			cfg = cfg.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		err := tlsConn.Handshake()
+		if err != nil {
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+	return handshake(req, conn, &DialOptions{Compression: d.Compression, Subprotocols: d.Subprotocols})
+}
+
+// Establish a tunnel to addr by CONNECT method of http proxy.
+func proxyConnect(conn net.Conn, proxy *url.URL, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := proxy.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+	err := req.Write(conn)
+	if err != nil {
+		return err
+	}
+	rd := bufio.NewReader(conn)
+	res, err := http.ReadResponse(rd, req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("websocket: proxy CONNECT %s", strings.TrimSpace(res.Status))
+	}
+	// The proxy must not send data before the tunnel is used.
+	if rd.Buffered() > 0 {
+		return errors.New("websocket: proxy sent data after CONNECT response")
+	}
+	return nil
+}
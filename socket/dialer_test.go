@@ -0,0 +1,279 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Echo server, "/ws" accepts, "/redirect" redirects to "/ws", others response 403.
+func test_DialerHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/ws":
+		case "/redirect":
+			http.Redirect(res, req, "/ws?redirected=1", http.StatusFound)
+			return
+		case "/bad-accept":
+			conn, buf, _ := res.(http.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: bad\r\n\r\n")
+			buf.Flush()
+			conn.Close()
+			return
+		default:
+			http.Error(res, "forbidden", http.StatusForbidden)
+			return
+		}
+		conn, err := AcceptWithOptions(res, req, &AcceptOptions{Subprotocols: []string{"v2", "chat"}})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.ReadLoop(0, func(c Code, b []byte) error {
+			if c == CodeText {
+				return conn.Write(CodeText, []byte(req.URL.RawQuery+" "+req.Header.Get("X-Test")+" "+string(b)), 0)
+			}
+			return nil
+		})
+		conn.Close()
+	})
+}
+
+// Send "hello", return the echo.
+func test_Echo(t *testing.T, conn *Conn) string {
+	err := conn.Write(CodeText, []byte("hello"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, r, err := conn.NextReader()
+	if err != nil || code != CodeText {
+		t.Fatal(code, err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	conn.Close()
+	return string(b)
+}
+
+func Test_Dialer(t *testing.T) {
+	srv := httptest.NewServer(test_DialerHandler(t))
+	defer srv.Close()
+	u := "ws" + strings.TrimPrefix(srv.URL, "http")
+	d := &Dialer{Subprotocols: []string{"chat", "v2"}, HandshakeTimeout: time.Second}
+	header := make(http.Header)
+	header.Set("X-Test", "x")
+	conn, res, err := d.Dial(u+"/ws", header)
+	if err != nil || res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal(err)
+	}
+	if conn.Subprotocol() != "chat" {
+		t.Fatal(conn.Subprotocol())
+	}
+	if s := test_Echo(t, conn); s != " x hello" {
+		t.Fatal(s)
+	}
+	// Redirect.
+	conn, _, err = d.Dial(u+"/redirect", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := test_Echo(t, conn); s != "redirected=1  hello" {
+		t.Fatal(s)
+	}
+	_, res, err = (&Dialer{MaxRedirects: -1}).Dial(u+"/redirect", nil)
+	if !errors.Is(err, ErrBadHandshake) || res.StatusCode != http.StatusFound {
+		t.Fatal(err)
+	}
+	// Bad handshake.
+	_, res, err = d.Dial(u+"/forbidden", nil)
+	if !errors.Is(err, ErrBadHandshake) || res.StatusCode != http.StatusForbidden {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(res.Body); string(b) != "forbidden\n" {
+		t.Fatal(string(b))
+	}
+	_, _, err = d.Dial(u+"/bad-accept", nil)
+	if !errors.Is(err, ErrBadHandshake) {
+		t.Fatal(err)
+	}
+	// Invalid arguments.
+	header = make(http.Header)
+	header.Set("Sec-WebSocket-Key", "x")
+	if _, _, err = d.Dial(u+"/ws", header); err == nil {
+		t.FailNow()
+	}
+	// Not canonical keys.
+	for _, k := range []string{"Sec-WebSocket-Key", "sec-websocket-protocol", "UPGRADE"} {
+		if _, _, err = d.Dial(u+"/ws", http.Header{k: []string{"x"}}); err == nil || !strings.Contains(err.Error(), "can not be set") {
+			t.Fatal(k, err)
+		}
+	}
+	if _, _, err = d.Dial("ftp://localhost/", nil); err == nil {
+		t.FailNow()
+	}
+}
+
+func Test_Dialer_TLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(test_DialerHandler(t))
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	d := &Dialer{TLSConfig: &tls.Config{RootCAs: pool, ServerName: "example.com"}}
+	conn, _, err := d.Dial("wss"+strings.TrimPrefix(srv.URL, "https")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := test_Echo(t, conn); s != "  hello" {
+		t.Fatal(s)
+	}
+	// Unknown authority.
+	if _, _, err = new(Dialer).Dial("wss"+strings.TrimPrefix(srv.URL, "https")+"/ws", nil); err == nil {
+		t.FailNow()
+	}
+}
+
+// A http CONNECT proxy, which requires user:pass.
+func test_Proxy(t *testing.T) (*url.URL, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				req, err := http.ReadRequest(rd)
+				if err != nil {
+					return
+				}
+				if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				go io.Copy(target, rd)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "http", User: url.UserPassword("user", "pass"), Host: l.Addr().String()}, func() { l.Close() }
+}
+
+func Test_Dialer_Proxy(t *testing.T) {
+	srv := httptest.NewServer(test_DialerHandler(t))
+	defer srv.Close()
+	proxy, stop := test_Proxy(t)
+	defer stop()
+	d := &Dialer{Proxy: http.ProxyURL(proxy)}
+	conn, _, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := test_Echo(t, conn); s != "  hello" {
+		t.Fatal(s)
+	}
+	proxy.User = nil
+	if _, _, err = d.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatal(err)
+	}
+}
+
+func Test_Dialer_Redirect_Credentials(t *testing.T) {
+	// Record the request headers, then response 403.
+	requests := make(chan *http.Request, 1)
+	record := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests <- req
+		http.Error(res, "forbidden", http.StatusForbidden)
+	})
+	other := httptest.NewServer(record)
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/cross":
+			http.Redirect(res, req, other.URL+"/ws", http.StatusFound)
+		case "/same":
+			http.Redirect(res, req, "/check", http.StatusFound)
+		default:
+			record(res, req)
+		}
+	}))
+	defer srv.Close()
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer token")
+	header.Set("Cookie", "a=b")
+	header.Set("X-Test", "x")
+	header["host"] = []string{"example.com"}
+	u := "ws://user:pass@" + strings.TrimPrefix(srv.URL, "http://")
+	// Another host.
+	_, _, err := DefaultDialer.Dial(u+"/cross", header)
+	if !errors.Is(err, ErrBadHandshake) {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" || req.Header.Get("X-Test") != "x" {
+		t.Fatal(req.Header)
+	}
+	if req.Host != strings.TrimPrefix(other.URL, "http://") {
+		t.Fatal(req.Host)
+	}
+	// The same host.
+	header.Del("Authorization")
+	_, _, err = DefaultDialer.Dial(u+"/same", header)
+	if !errors.Is(err, ErrBadHandshake) {
+		t.Fatal(err)
+	}
+	req = <-requests
+	if user, pass, _ := req.BasicAuth(); user != "user" || pass != "pass" || req.Header.Get("Cookie") != "a=b" {
+		t.Fatal(req.Header)
+	}
+	if req.Host != "example.com" {
+		t.Fatal(req.Host)
+	}
+}
+
+func Test_Dialer_Timeout(t *testing.T) {
+	// Accept but never response.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	d := &Dialer{HandshakeTimeout: 50 * time.Millisecond}
+	_, _, err = d.Dial("ws://"+l.Addr().String()+"/", nil)
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,233 @@
+package socket
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Max time of sending the close frame, when heartbeat closes an unresponsive connection.
+const heartbeatCloseTimeout = time.Second
+
+// Statistics of a Conn, returned by Conn.Stats.
+type Stats struct {
+	// Bytes of frames read and written, including headers.
+	BytesIn  int64
+	BytesOut int64
+	// Count of data messages read and written.
+	MessagesIn  int64
+	MessagesOut int64
+	// Count of pings sent by heartbeat, and pongs answered them.
+	PingsSent     int64
+	PongsReceived int64
+	// Round-trip time of the last ping answered by a pong, 0 means unknown.
+	RTT time.Duration
+	// Time of reading and writing the last frame, zero means never.
+	LastRead  time.Time
+	LastWrite time.Time
+	// Time of reading the last data message, zero means never.
+	LastMessage time.Time
+}
+
+// Counters of Stats, updated atomically.
+// It is allocated alone, so that 64-bit fields are aligned.
+type connStats struct {
+	bytesIn       int64
+	bytesOut      int64
+	messagesIn    int64
+	messagesOut   int64
+	pingsSent     int64
+	pongsReceived int64
+	rtt           int64
+	// Unix nano.
+	lastRead    int64
+	lastWrite   int64
+	lastMessage int64
+}
+
+// Count bytes read from r.
+type countReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// Return unix nano t as time.Time, 0 means zero time.
+func unixNano(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
+
+// Return statistics of the connection, it is safe for concurrent use.
+func (c *Conn) Stats() Stats {
+	s := c.stats
+	return Stats{
+		BytesIn:       atomic.LoadInt64(&s.bytesIn),
+		BytesOut:      atomic.LoadInt64(&s.bytesOut),
+		MessagesIn:    atomic.LoadInt64(&s.messagesIn),
+		MessagesOut:   atomic.LoadInt64(&s.messagesOut),
+		PingsSent:     atomic.LoadInt64(&s.pingsSent),
+		PongsReceived: atomic.LoadInt64(&s.pongsReceived),
+		RTT:           time.Duration(atomic.LoadInt64(&s.rtt)),
+		LastRead:      unixNano(atomic.LoadInt64(&s.lastRead)),
+		LastWrite:     unixNano(atomic.LoadInt64(&s.lastWrite)),
+		LastMessage:   unixNano(atomic.LoadInt64(&s.lastMessage)),
+	}
+}
+
+// Options of StartHeartbeat.
+type HeartbeatOptions struct {
+	// Interval of sending ping, 0 means never.
+	PingInterval time.Duration
+	// Max time of waiting for the pong of a ping, 0 means no limit.
+	PongTimeout time.Duration
+	// Max time of waiting for a data message, pings and pongs do not count, 0 means no limit.
+	IdleTimeout time.Duration
+}
+
+// State of heartbeat.
+type heartbeat struct {
+	opt  HeartbeatOptions
+	mu   sync.Mutex
+	done chan struct{}
+	once sync.Once
+	// Time of the ping which is waiting for a pong, zero means none.
+	ping time.Time
+	// Why heartbeat closed the connection.
+	err error
+}
+
+// Stop the heartbeat goroutine.
+func (h *heartbeat) stop() {
+	h.once.Do(func() {
+		close(h.done)
+	})
+}
+
+// Handle a pong, return the rtt if it answers the ping.
+func (h *heartbeat) pong() (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ping.IsZero() {
+		return 0, false
+	}
+	rtt := time.Since(h.ping)
+	h.ping = time.Time{}
+	return rtt, true
+}
+
+// Return the error if heartbeat closed the connection.
+func (h *heartbeat) closeErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Start a goroutine, which sends pings and closes unresponsive or idle connections.
+// If no pong answers a ping in PongTimeout, or no data message is read in IdleTimeout,
+// it sends a close frame of CloseGoingAway, then closes the connection,
+// reading returns *CloseError with CloseAbnormalClosure, the reason is "pong timeout" or "idle timeout".
+// Ping payload is empty, RTT of Stats is the time between a ping and the next pong.
+// It stops when Close is called, it should be called once, before reading.
+func (c *Conn) StartHeartbeat(opt *HeartbeatOptions) {
+	if opt == nil || (opt.PingInterval <= 0 && opt.IdleTimeout <= 0) {
+		return
+	}
+	h := &heartbeat{opt: *opt, done: make(chan struct{})}
+	if atomic.LoadInt64(&c.stats.lastMessage) == 0 {
+		atomic.StoreInt64(&c.stats.lastMessage, time.Now().UnixNano())
+	}
+	c.heartbeat = h
+	go c.heartbeatLoop(h)
+}
+
+// Wait for the next event, ping, pong deadline or idle deadline, until stopped.
+func (c *Conn) heartbeatLoop(h *heartbeat) {
+	now := time.Now()
+	var nextPing time.Time
+	if h.opt.PingInterval > 0 {
+		nextPing = now.Add(h.opt.PingInterval)
+	}
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		// Find the nearest event.
+		next := nextPing
+		h.mu.Lock()
+		ping := h.ping
+		h.mu.Unlock()
+		var pongDeadline time.Time
+		if !ping.IsZero() && h.opt.PongTimeout > 0 {
+			pongDeadline = ping.Add(h.opt.PongTimeout)
+			if next.IsZero() || pongDeadline.Before(next) {
+				next = pongDeadline
+			}
+		}
+		var idleDeadline time.Time
+		if h.opt.IdleTimeout > 0 {
+			idleDeadline = unixNano(atomic.LoadInt64(&c.stats.lastMessage)).Add(h.opt.IdleTimeout)
+			if next.IsZero() || idleDeadline.Before(next) {
+				next = idleDeadline
+			}
+		}
+		timer.Reset(time.Until(next))
+		select {
+		case <-h.done:
+			return
+		case now = <-timer.C:
+		}
+		if !pongDeadline.IsZero() && !now.Before(pongDeadline) {
+			if _, ok := h.pong(); ok {
+				// Not answered.
+				c.closeUnresponsive(h, "pong timeout")
+				return
+			}
+		}
+		if !idleDeadline.IsZero() && !now.Before(idleDeadline) {
+			// A message may be read while waiting.
+			last := unixNano(atomic.LoadInt64(&c.stats.lastMessage))
+			if !now.Before(last.Add(h.opt.IdleTimeout)) {
+				c.closeUnresponsive(h, "idle timeout")
+				return
+			}
+		}
+		if !nextPing.IsZero() && !now.Before(nextPing) {
+			nextPing = now.Add(h.opt.PingInterval)
+			h.mu.Lock()
+			// Keep the time of the unanswered ping.
+			if h.ping.IsZero() {
+				h.ping = now
+			}
+			h.mu.Unlock()
+			// Count it first, the pong may be read before writing returns.
+			atomic.AddInt64(&c.stats.pingsSent, 1)
+			err := c.writeFrame(_Fin[1], CodePing, nil)
+			if err != nil {
+				if err != ErrCloseSent {
+					c.conn.Close()
+				}
+				return
+			}
+		}
+	}
+}
+
+// Send a close frame of CloseGoingAway, then close the connection,
+// reading returns *CloseError with CloseAbnormalClosure and reason.
+func (c *Conn) closeUnresponsive(h *heartbeat, reason string) {
+	h.mu.Lock()
+	h.err = &CloseError{Code: CloseAbnormalClosure, Reason: reason}
+	h.mu.Unlock()
+	// The peer may not read, do not block on writing.
+	c.SetWriteDeadline(time.Now().Add(heartbeatCloseTimeout))
+	c.WriteClose(CloseGoingAway, reason)
+	c.conn.Close()
+}
@@ -0,0 +1,114 @@
+package socket
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_Conn_Stats(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	client := newConn(c, nil, _Mask[1])
+	server.StartHeartbeat(&HeartbeatOptions{PingInterval: 10 * time.Millisecond})
+	defer server.Close()
+	go func() {
+		client.Write(CodeText, []byte("hello"), 0)
+		client.Write(CodeBinary, []byte("hello world"), 5)
+		// Answer pings.
+		client.ReadLoop(0, func(Code, []byte) error { return nil })
+	}()
+	n := 0
+	err := server.ReadLoop(0, func(code Code, b []byte) error {
+		if code == CodeText || code == CodeBinary {
+			n++
+		}
+		if n == 2 && server.Stats().PongsReceived > 0 {
+			return errors.New("stop")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "stop" {
+		t.Fatal(err)
+	}
+	st := server.Stats()
+	// Writing a ping may return after its pong is read.
+	for i := 0; i < 100 && st.LastWrite.IsZero(); i++ {
+		time.Sleep(time.Millisecond)
+		st = server.Stats()
+	}
+	// 2 bytes header + 4 bytes key + payload.
+	if st.MessagesIn != 2 || st.BytesIn < 6+5+6*3+11 {
+		t.Fatal(st)
+	}
+	if st.PingsSent < st.PongsReceived || st.RTT <= 0 || st.LastRead.IsZero() || st.LastWrite.IsZero() || st.LastMessage.IsZero() {
+		t.Fatal(st)
+	}
+	st = client.Stats()
+	for i := 0; i < 100 && st.MessagesOut < 2; i++ {
+		time.Sleep(time.Millisecond)
+		st = client.Stats()
+	}
+	if st.MessagesOut != 2 || st.BytesOut < 6+5+6*3+11 {
+		t.Fatal(st)
+	}
+}
+
+func Test_Conn_Heartbeat_PongTimeout(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	server.StartHeartbeat(&HeartbeatOptions{PingInterval: 10 * time.Millisecond, PongTimeout: 20 * time.Millisecond})
+	// Read frames, but never answer.
+	var buf bytes.Buffer
+	read := make(chan struct{})
+	go func() {
+		buf.ReadFrom(c)
+		close(read)
+	}()
+	err := server.ReadLoop(0, func(Code, []byte) error { return nil })
+	var e *CloseError
+	if !errors.As(err, &e) || e.Code != CloseAbnormalClosure || e.Reason != "pong timeout" {
+		t.Fatal(err)
+	}
+	<-read
+	if !bytes.Contains(buf.Bytes(), []byte("\x88\x0e\x03\xe9pong timeout")) {
+		t.Fatalf("%q", buf.Bytes())
+	}
+	if server.Stats().PongsReceived != 0 {
+		t.Fatal(server.Stats())
+	}
+}
+
+func Test_Conn_Heartbeat_IdleTimeout(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	client := newConn(c, nil, _Mask[1])
+	server.StartHeartbeat(&HeartbeatOptions{PingInterval: 10 * time.Millisecond, PongTimeout: 20 * time.Millisecond, IdleTimeout: 100 * time.Millisecond})
+	done := make(chan error, 1)
+	go func() {
+		// Pongs keep the connection alive, but not idle.
+		done <- client.ReadLoop(0, func(Code, []byte) error { return nil })
+	}()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Write(CodeText, []byte("hello"), 0)
+	}()
+	start := time.Now()
+	err := server.ReadLoop(0, func(Code, []byte) error { return nil })
+	var e *CloseError
+	if !errors.As(err, &e) || e.Code != CloseAbnormalClosure || e.Reason != "idle timeout" {
+		t.Fatal(err)
+	}
+	// The message delays the timeout.
+	if time.Since(start) < 150*time.Millisecond {
+		t.Fatal(time.Since(start))
+	}
+	if err = <-done; !errors.As(err, &e) || e.Code != CloseGoingAway || e.Reason != "idle timeout" {
+		t.Fatal(err)
+	}
+	if server.Stats().PongsReceived < 1 {
+		t.Fatal(server.Stats())
+	}
+}
@@ -0,0 +1,315 @@
+package socket
+
+import (
+	"sort"
+	"sync"
+)
+
+// Type of HubEvent.
+type HubEventType int
+
+const (
+	// A user comes online, its first connection is registered.
+	HubOnline HubEventType = iota
+	// A user goes offline, its last connection is unregistered.
+	HubOffline
+	// A user joins a room, by its first connection in the room.
+	HubJoin
+	// A user leaves a room, by its last connection in the room.
+	HubLeave
+)
+
+func (t HubEventType) String() string {
+	switch t {
+	case HubOnline:
+		return "online"
+	case HubOffline:
+		return "offline"
+	case HubJoin:
+		return "join"
+	default:
+		return "leave"
+	}
+}
+
+// Presence change of a Hub.
+type HubEvent struct {
+	Type HubEventType
+	User string
+	// Empty if Type is HubOnline or HubOffline.
+	Room string
+	// The connection which causes the event.
+	Conn *Conn
+}
+
+// Hub keeps connections by user and room, and broadcasts messages to them.
+// A user may have many connections, and a connection may join many rooms.
+// It is safe for concurrent use.
+//
+// Messages are written by Conn.SendPrepared if Conn.StartQueue was called,
+// otherwise by Conn.WritePrepared in the calling goroutine,
+// so that a slow connection without queue blocks the broadcast.
+type Hub struct {
+	mu    sync.RWMutex
+	conns map[*Conn]*hubConn
+	users map[string]map[*Conn]struct{}
+	rooms map[string]map[*Conn]struct{}
+	// Call by the goroutine which changes the presence, after the change is done.
+	onEvent func(*HubEvent)
+}
+
+// Registered connection.
+type hubConn struct {
+	user  string
+	rooms map[string]struct{}
+}
+
+// Create a Hub, onEvent is called on presence changes, it can be nil.
+func NewHub(onEvent func(*HubEvent)) *Hub {
+	return &Hub{
+		conns:   make(map[*Conn]*hubConn),
+		users:   make(map[string]map[*Conn]struct{}),
+		rooms:   make(map[string]map[*Conn]struct{}),
+		onEvent: onEvent,
+	}
+}
+
+// Emit events outside the lock.
+func (h *Hub) emit(events []*HubEvent) {
+	if h.onEvent == nil {
+		return
+	}
+	for _, e := range events {
+		h.onEvent(e)
+	}
+}
+
+// Register conn of user, user can be empty for anonymous connections.
+// Register a registered conn does nothing.
+func (h *Hub) Register(conn *Conn, user string) {
+	var events []*HubEvent
+	h.mu.Lock()
+	if _, ok := h.conns[conn]; !ok {
+		h.conns[conn] = &hubConn{user: user, rooms: make(map[string]struct{})}
+		if user != "" {
+			if addConn(h.users, user, conn) {
+				events = append(events, &HubEvent{Type: HubOnline, User: user, Conn: conn})
+			}
+		}
+	}
+	h.mu.Unlock()
+	h.emit(events)
+}
+
+// Unregister conn, it leaves all rooms.
+func (h *Hub) Unregister(conn *Conn) {
+	var events []*HubEvent
+	h.mu.Lock()
+	hc, ok := h.conns[conn]
+	if ok {
+		rooms := make([]string, 0, len(hc.rooms))
+		for room := range hc.rooms {
+			rooms = append(rooms, room)
+		}
+		sort.Strings(rooms)
+		for _, room := range rooms {
+			events = h.leave(conn, hc, room, events)
+		}
+		delete(h.conns, conn)
+		if hc.user != "" && removeConn(h.users, hc.user, conn) {
+			events = append(events, &HubEvent{Type: HubOffline, User: hc.user, Conn: conn})
+		}
+	}
+	h.mu.Unlock()
+	h.emit(events)
+}
+
+// Conn joins room, return false if conn is not registered.
+func (h *Hub) Join(conn *Conn, room string) bool {
+	var events []*HubEvent
+	h.mu.Lock()
+	hc, ok := h.conns[conn]
+	if ok {
+		if _, in := hc.rooms[room]; !in {
+			hc.rooms[room] = struct{}{}
+			addConn(h.rooms, room, conn)
+			if hc.user != "" && !h.userInRoom(hc.user, room, conn) {
+				events = append(events, &HubEvent{Type: HubJoin, User: hc.user, Room: room, Conn: conn})
+			}
+		}
+	}
+	h.mu.Unlock()
+	h.emit(events)
+	return ok
+}
+
+// Conn leaves room, return false if conn is not in room.
+func (h *Hub) Leave(conn *Conn, room string) bool {
+	var events []*HubEvent
+	h.mu.Lock()
+	hc, ok := h.conns[conn]
+	if ok {
+		_, ok = hc.rooms[room]
+		if ok {
+			events = h.leave(conn, hc, room, events)
+		}
+	}
+	h.mu.Unlock()
+	h.emit(events)
+	return ok
+}
+
+// Remove conn from room, must hold h.mu.
+func (h *Hub) leave(conn *Conn, hc *hubConn, room string, events []*HubEvent) []*HubEvent {
+	delete(hc.rooms, room)
+	removeConn(h.rooms, room, conn)
+	if hc.user != "" && !h.userInRoom(hc.user, room, conn) {
+		events = append(events, &HubEvent{Type: HubLeave, User: hc.user, Room: room, Conn: conn})
+	}
+	return events
+}
+
+// Reports whether user has connections in room except conn, must hold h.mu.
+func (h *Hub) userInRoom(user, room string, except *Conn) bool {
+	for c := range h.users[user] {
+		if c == except {
+			continue
+		}
+		if _, ok := h.conns[c].rooms[room]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Add conn to m[key], return true if it is the first one.
+func addConn(m map[string]map[*Conn]struct{}, key string, conn *Conn) bool {
+	s, ok := m[key]
+	if !ok {
+		s = make(map[*Conn]struct{})
+		m[key] = s
+	}
+	s[conn] = struct{}{}
+	return !ok
+}
+
+// Remove conn from m[key], return true if it was the last one.
+func removeConn(m map[string]map[*Conn]struct{}, key string, conn *Conn) bool {
+	s := m[key]
+	delete(s, conn)
+	if len(s) < 1 {
+		delete(m, key)
+		return true
+	}
+	return false
+}
+
+// Return sorted rooms which conn is in.
+func (h *Hub) Rooms(conn *Conn) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	hc, ok := h.conns[conn]
+	if !ok {
+		return nil
+	}
+	rooms := make([]string, 0, len(hc.rooms))
+	for room := range hc.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Return sorted users in room, anonymous connections are not included.
+func (h *Hub) Presence(room string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	seen := make(map[string]struct{})
+	var users []string
+	for c := range h.rooms[room] {
+		user := h.conns[c].user
+		if _, ok := seen[user]; ok || user == "" {
+			continue
+		}
+		seen[user] = struct{}{}
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Reports whether user has registered connections.
+func (h *Hub) Online(user string) bool {
+	h.mu.RLock()
+	_, ok := h.users[user]
+	h.mu.RUnlock()
+	return ok
+}
+
+// Return the count of registered connections.
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	n := len(h.conns)
+	h.mu.RUnlock()
+	return n
+}
+
+// Send m to all connections, which filter returns true(nil filter means all).
+// Return the count of connections m was sent to.
+func (h *Hub) Broadcast(m *PreparedMessage, filter func(*Conn) bool) int {
+	h.mu.RLock()
+	conns := make([]*Conn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.RUnlock()
+	return h.send(conns, m, filter)
+}
+
+// Same as Broadcast, but only connections in room.
+func (h *Hub) BroadcastRoom(room string, m *PreparedMessage, filter func(*Conn) bool) int {
+	return h.sendSet(h.rooms, room, m, filter)
+}
+
+// Same as Broadcast, but only connections of user.
+func (h *Hub) SendUser(user string, m *PreparedMessage, filter func(*Conn) bool) int {
+	return h.sendSet(h.users, user, m, filter)
+}
+
+func (h *Hub) sendSet(m map[string]map[*Conn]struct{}, key string, msg *PreparedMessage, filter func(*Conn) bool) int {
+	h.mu.RLock()
+	s := m[key]
+	conns := make([]*Conn, 0, len(s))
+	for c := range s {
+		conns = append(conns, c)
+	}
+	h.mu.RUnlock()
+	return h.send(conns, msg, filter)
+}
+
+// Send m to conns outside the lock.
+func (h *Hub) send(conns []*Conn, m *PreparedMessage, filter func(*Conn) bool) int {
+	n := 0
+	for _, c := range conns {
+		if filter != nil && !filter(c) {
+			continue
+		}
+		var err error
+		if c.queue != nil {
+			err = c.SendPrepared(m)
+		} else {
+			err = c.WritePrepared(m)
+		}
+		if err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Register conn of user, Serve it, then unregister it.
+func (h *Hub) Serve(conn *Conn, user string, handler Handler, opt *ServeOptions) error {
+	h.Register(conn, user)
+	defer h.Unregister(conn)
+	return Serve(conn, handler, opt)
+}
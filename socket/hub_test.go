@@ -0,0 +1,197 @@
+package socket
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A server side conn, and messages received by the client side.
+type test_HubConn struct {
+	conn *Conn
+	mu   sync.Mutex
+	msgs []string
+	// Signaled when a message is received.
+	recv chan struct{}
+	done chan struct{}
+}
+
+func newTestHubConn(compress *compression) *test_HubConn {
+	s, c := net.Pipe()
+	hc := &test_HubConn{conn: newConn(s, nil, _Mask[0]), recv: make(chan struct{}, 1), done: make(chan struct{})}
+	hc.conn.compress = compress
+	client := newConn(c, nil, _Mask[1])
+	if compress != nil {
+		client.compress = newCompression(new(CompressionOptions), false, false)
+	}
+	go func() {
+		client.ReadLoop(0, func(code Code, b []byte) error {
+			if code != CodeText {
+				return nil
+			}
+			hc.mu.Lock()
+			hc.msgs = append(hc.msgs, string(b))
+			hc.mu.Unlock()
+			select {
+			case hc.recv <- struct{}{}:
+			default:
+			}
+			return nil
+		})
+		c.Close()
+		close(hc.done)
+	}()
+	return hc
+}
+
+// Wait until n messages were received, or fail after 5 seconds.
+func (c *test_HubConn) Wait(t *testing.T, n int) {
+	timeout := time.NewTimer(5 * time.Second)
+	defer timeout.Stop()
+	for {
+		c.mu.Lock()
+		m := len(c.msgs)
+		c.mu.Unlock()
+		if m >= n {
+			return
+		}
+		select {
+		case <-c.recv:
+		case <-timeout.C:
+			t.Fatalf("received %d, want %d", m, n)
+		}
+	}
+}
+
+// Close and return received messages.
+func (c *test_HubConn) Messages() string {
+	c.conn.Close()
+	<-c.done
+	return strings.Join(c.msgs, ",")
+}
+
+func Test_PreparedMessage(t *testing.T) {
+	data := bytes.Repeat([]byte("prepared"), 100)
+	m, err := NewPreparedMessage(CodeText, data, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := m.encode(false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same as Write.
+	var buf bytes.Buffer
+	c := newConn(&test_RWC{Buffer: &buf}, nil, _Mask[0])
+	c.Write(CodeText, data, 300)
+	if !bytes.Equal(buf.Bytes(), frames) {
+		t.FailNow()
+	}
+	// Cached.
+	f2, _ := m.encode(false, 0)
+	if &f2[0] != &frames[0] {
+		t.FailNow()
+	}
+	if _, err = NewPreparedMessage(CodeClose, nil, 0); err == nil {
+		t.FailNow()
+	}
+}
+
+type test_RWC struct {
+	*bytes.Buffer
+}
+
+func (*test_RWC) Close() error { return nil }
+
+func Test_Hub(t *testing.T) {
+	var events []string
+	var mu sync.Mutex
+	h := NewHub(func(e *HubEvent) {
+		mu.Lock()
+		events = append(events, fmt.Sprintf("%s %s %s", e.Type, e.User, e.Room))
+		mu.Unlock()
+	})
+	a1, a2, b, anonymous := newTestHubConn(nil), newTestHubConn(nil), newTestHubConn(nil), newTestHubConn(nil)
+	h.Register(a1.conn, "a")
+	h.Register(a2.conn, "a")
+	h.Register(b.conn, "b")
+	h.Register(anonymous.conn, "")
+	b.conn.StartQueue(nil)
+	h.Join(a1.conn, "r1")
+	h.Join(a2.conn, "r1")
+	h.Join(a2.conn, "r2")
+	h.Join(b.conn, "r1")
+	h.Join(anonymous.conn, "r1")
+	if fmt.Sprint(h.Presence("r1")) != "[a b]" || fmt.Sprint(h.Rooms(a2.conn)) != "[r1 r2]" || !h.Online("a") {
+		t.Fatal(h.Presence("r1"), h.Rooms(a2.conn))
+	}
+	msg := func(s string) *PreparedMessage {
+		m, _ := NewPreparedMessage(CodeText, []byte(s), 0)
+		return m
+	}
+	if n := h.Broadcast(msg("all"), nil); n != 4 {
+		t.Fatal(n)
+	}
+	if n := h.BroadcastRoom("r2", msg("r2"), nil); n != 1 {
+		t.Fatal(n)
+	}
+	if n := h.SendUser("a", msg("a"), nil); n != 2 {
+		t.Fatal(n)
+	}
+	if n := h.BroadcastRoom("r1", msg("not b"), func(c *Conn) bool { return c != b.conn }); n != 3 {
+		t.Fatal(n)
+	}
+	h.Leave(a1.conn, "r1")
+	h.Unregister(a2.conn)
+	h.Unregister(b.conn)
+	if fmt.Sprint(h.Presence("r1")) != "[]" || !h.Online("a") || h.Online("b") || h.Len() != 2 {
+		t.Fatal(h.Presence("r1"), h.Len())
+	}
+	h.Unregister(a1.conn)
+	// Written by the queue.
+	b.Wait(t, 1)
+	for _, c := range []struct {
+		conn *test_HubConn
+		msgs string
+	}{
+		{a1, "all,a,not b"},
+		{a2, "all,r2,a,not b"},
+		{b, "all"},
+		{anonymous, "all,not b"},
+	} {
+		if m := c.conn.Messages(); m != c.msgs {
+			t.Fatal(m)
+		}
+	}
+	if strings.Join(events, ",") != "online a ,online b ,join a r1,join a r2,join b r1,leave a r1,leave a r2,leave b r1,offline b ,offline a " {
+		t.Fatal(events)
+	}
+}
+
+func Test_Hub_Compression(t *testing.T) {
+	h := NewHub(nil)
+	opt := new(CompressionOptions)
+	c1 := newTestHubConn(newCompression(opt, true, false))
+	c2 := newTestHubConn(newCompression(opt, false, false))
+	c3 := newTestHubConn(nil)
+	for _, c := range []*test_HubConn{c1, c2, c3} {
+		h.Register(c.conn, "")
+	}
+	data := strings.Repeat("compress ", 100)
+	m, _ := NewPreparedMessage(CodeText, []byte(data), 0)
+	for i := 0; i < 2; i++ {
+		h.Broadcast(m, nil)
+	}
+	if len(m.frames) != 2 {
+		t.Fatal(len(m.frames))
+	}
+	for _, c := range []*test_HubConn{c1, c2, c3} {
+		if c.Messages() != data+","+data {
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Write v as a JSON text message, it is safe for concurrent use.
+func (c *Conn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Write(CodeText, data, 0)
+}
+
+// Read next data message, then decode it as JSON into v.
+// It has the same limits as NextReader.
+func (c *Conn) ReadJSON(v interface{}) error {
+	_, r, err := c.NextReader()
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(r).Decode(v)
+}
+
+// Envelope is the JSON message convention of Dispatcher,
+// Type decides how to handle Payload.
+type Envelope struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Write an Envelope, payload is encoded as JSON, nil means no payload.
+func (c *Conn) WriteEnvelope(typ string, payload interface{}) error {
+	e := &Envelope{Type: typ}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		e.Payload = data
+	}
+	return c.WriteJSON(e)
+}
+
+// Handle the payload of an Envelope.
+type EnvelopeHandler func(conn *Conn, payload json.RawMessage) error
+
+// Dispatcher routes Envelope messages to handlers by type.
+// It is safe for concurrent use.
+type Dispatcher struct {
+	mu       sync.RWMutex
+	handlers map[string]EnvelopeHandler
+	notFound func(*Conn, *Envelope) error
+}
+
+// Create a Dispatcher.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: make(map[string]EnvelopeHandler)}
+}
+
+// Register handler of typ, nil handler removes it.
+func (d *Dispatcher) Handle(typ string, handler EnvelopeHandler) {
+	d.mu.Lock()
+	if handler == nil {
+		delete(d.handlers, typ)
+	} else {
+		d.handlers[typ] = handler
+	}
+	d.mu.Unlock()
+}
+
+// Set the handler of the types which are not registered, nil means ignore them.
+func (d *Dispatcher) NotFound(handler func(*Conn, *Envelope) error) {
+	d.mu.Lock()
+	d.notFound = handler
+	d.mu.Unlock()
+}
+
+// Decode data as an Envelope, then call the handler of its type, return the handler's error.
+// Return error if data is not an Envelope.
+func (d *Dispatcher) Dispatch(conn *Conn, data []byte) error {
+	e, err := decodeEnvelope(data)
+	if err != nil {
+		return err
+	}
+	return d.dispatch(conn, e)
+}
+
+// Decode an Envelope which has type.
+func decodeEnvelope(data []byte) (*Envelope, error) {
+	e := new(Envelope)
+	err := json.Unmarshal(data, e)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: invalid envelope: %w", err)
+	}
+	if e.Type == "" {
+		return nil, errors.New("websocket: envelope has no type")
+	}
+	return e, nil
+}
+
+func (d *Dispatcher) dispatch(conn *Conn, e *Envelope) error {
+	d.mu.RLock()
+	handler, ok := d.handlers[e.Type]
+	notFound := d.notFound
+	d.mu.RUnlock()
+	if ok {
+		return handler(conn, e.Payload)
+	}
+	if notFound != nil {
+		return notFound(conn, e)
+	}
+	return nil
+}
+
+// Read messages by conn.ReadLoop, and dispatch text messages.
+// Binary message ends the loop with a close frame of CloseUnsupportedData.
+// If a message is not an Envelope, it ends the loop with a close frame of CloseInvalidPayload.
+// Handler's error ends the loop, and it is returned.
+func (d *Dispatcher) ReadLoop(conn *Conn, maxLen int) error {
+	return conn.ReadLoop(maxLen, func(code Code, data []byte) error {
+		switch code {
+		case CodeText:
+			e, err := decodeEnvelope(data)
+			if err != nil {
+				return conn.fail(CloseInvalidPayload, "invalid envelope")
+			}
+			return d.dispatch(conn, e)
+		case CodeBinary:
+			return conn.fail(CloseUnsupportedData, "binary message is not supported")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,97 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+)
+
+func Test_Conn_JSON(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	client := newConn(c, nil, _Mask[1])
+	type message struct {
+		A int    `json:"a"`
+		B string `json:"b"`
+	}
+	go func() {
+		client.WriteJSON(&message{A: 1, B: "b"})
+		client.Write(CodeText, []byte("{"), 0)
+		client.Close()
+	}()
+	var m message
+	if err := server.ReadJSON(&m); err != nil || m.A != 1 || m.B != "b" {
+		t.Fatal(m, err)
+	}
+	if err := server.ReadJSON(&m); err == nil {
+		t.FailNow()
+	}
+	var e *CloseError
+	if err := server.ReadJSON(&m); !errors.As(err, &e) {
+		t.Fatal(err)
+	}
+	s.Close()
+}
+
+func Test_Dispatcher(t *testing.T) {
+	d := NewDispatcher()
+	var calls []string
+	d.Handle("add", func(c *Conn, payload json.RawMessage) error {
+		var n [2]int
+		err := json.Unmarshal(payload, &n)
+		if err != nil {
+			return err
+		}
+		return c.WriteEnvelope("sum", n[0]+n[1])
+	})
+	d.Handle("removed", func(*Conn, json.RawMessage) error { return nil })
+	d.Handle("removed", nil)
+	d.NotFound(func(c *Conn, e *Envelope) error {
+		calls = append(calls, e.Type)
+		return nil
+	})
+	if d.Dispatch(nil, []byte(`{"payload":1}`)) == nil || d.Dispatch(nil, []byte(`[]`)) == nil {
+		t.FailNow()
+	}
+	for _, c := range []struct {
+		msgs []string
+		code CloseCode
+		recv string
+	}{
+		{[]string{`not json`}, CloseInvalidPayload, ""},
+		{[]string{`{"type":"removed"}`, `{"type":"add","payload":[1,2]}`, `{"type":"x"}`}, CloseUnsupportedData, `{"type":"sum","payload":3}`},
+	} {
+		s, cc := net.Pipe()
+		server := newConn(s, nil, _Mask[0])
+		client := newConn(cc, nil, _Mask[1])
+		go func() {
+			for _, m := range c.msgs {
+				client.Write(CodeText, []byte(m), 0)
+			}
+			client.Write(CodeBinary, nil, 0)
+		}()
+		recv := make(chan string, 1)
+		go func() {
+			var msgs string
+			client.ReadLoop(0, func(code Code, b []byte) error {
+				if code == CodeText {
+					msgs += string(b)
+				}
+				return nil
+			})
+			recv <- msgs
+		}()
+		var pe *ProtocolError
+		if err := d.ReadLoop(server, 0); !errors.As(err, &pe) || pe.Code != c.code {
+			t.Fatal(err)
+		}
+		s.Close()
+		if m := <-recv; m != c.recv {
+			t.Fatal(m)
+		}
+	}
+	if len(calls) != 2 || calls[0] != "removed" || calls[1] != "x" {
+		t.Fatal(calls)
+	}
+}
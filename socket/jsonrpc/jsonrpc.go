@@ -0,0 +1,237 @@
+// Package jsonrpc implements JSON-RPC 2.0 over socket.Conn.
+// Both sides of a connection can call each other, so it is used the same way by servers and clients.
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Error codes defined by JSON-RPC 2.0.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Error code of a request which is not served, because the peer is serving Options.MaxConcurrency requests.
+// It is in the range of implementation-defined server errors, the request can be sent again later.
+const CodeServerBusy = -32000
+
+// Error object of a response.
+// Handlers return it to respond a custom code, other errors are responded with CodeInternalError.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %s(%d)", e.Message, e.Code)
+}
+
+var (
+	// Peer was closed.
+	ErrClosed = errors.New("jsonrpc: peer closed")
+	// Params must be encoded as a JSON array or object.
+	errParams = errors.New("jsonrpc: params must be an array or object")
+	// Types of handler.
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// A registered method.
+type method struct {
+	fn reflect.Value
+	// First argument is context.Context.
+	ctx bool
+	// Type of params argument, nil means no params.
+	params reflect.Type
+	// Return a result before error.
+	result bool
+}
+
+// Registry keeps methods which can be called by peers.
+// It is safe for concurrent use, and can be shared by many peers.
+type Registry struct {
+	mu      sync.RWMutex
+	methods map[string]*method
+}
+
+// Create an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{methods: make(map[string]*method)}
+}
+
+// Register fn as method name, it panics if fn is not one of
+//
+//	func([context.Context,] [params P]) ([R,] error)
+//
+// Params of a call are decoded into P, which is usually a struct(by-name) or a slice(by-position).
+// R is encoded as the result, methods without R respond null.
+// Use PeerFromContext to call back the peer in fn.
+func (r *Registry) Register(name string, fn interface{}) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		panic(fmt.Errorf("jsonrpc: method %q is not a function", name))
+	}
+	m := &method{fn: v}
+	in := 0
+	if t.NumIn() > in && t.In(in) == contextType {
+		m.ctx = true
+		in++
+	}
+	if t.NumIn() > in {
+		m.params = t.In(in)
+		in++
+	}
+	if t.NumIn() != in {
+		panic(fmt.Errorf("jsonrpc: method %q has too many arguments", name))
+	}
+	switch t.NumOut() {
+	case 2:
+		m.result = true
+	case 1:
+	default:
+		panic(fmt.Errorf("jsonrpc: method %q must return ([result,] error)", name))
+	}
+	if t.Out(t.NumOut()-1) != errorType {
+		panic(fmt.Errorf("jsonrpc: method %q must return error at last", name))
+	}
+	r.mu.Lock()
+	r.methods[name] = m
+	r.mu.Unlock()
+}
+
+// Remove method name.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	delete(r.methods, name)
+	r.mu.Unlock()
+}
+
+// Call method name with params, return the encoded result or *Error.
+func (r *Registry) call(ctx context.Context, name string, params json.RawMessage) (json.RawMessage, *Error) {
+	r.mu.RLock()
+	m, ok := r.methods[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, &Error{Code: CodeMethodNotFound, Message: "Method not found"}
+	}
+	var args []reflect.Value
+	if m.ctx {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+	if m.params != nil {
+		p := reflect.New(m.params)
+		if len(params) > 0 {
+			err := json.Unmarshal(params, p.Interface())
+			if err != nil {
+				return nil, &Error{Code: CodeInvalidParams, Message: "Invalid params", Data: err.Error()}
+			}
+		}
+		args = append(args, p.Elem())
+	}
+	out, err := m.invoke(args)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			return nil, e
+		}
+		return nil, &Error{Code: CodeInternalError, Message: err.Error()}
+	}
+	result, err := json.Marshal(out)
+	if err != nil {
+		return nil, &Error{Code: CodeInternalError, Message: err.Error()}
+	}
+	return result, nil
+}
+
+// Call fn, a panic is returned as an error.
+func (m *method) invoke(args []reflect.Value) (result interface{}, err error) {
+	defer func() {
+		if re := recover(); re != nil {
+			err = fmt.Errorf("panic: %v", re)
+		}
+	}()
+	out := m.fn.Call(args)
+	if e := out[len(out)-1].Interface(); e != nil {
+		return nil, e.(error)
+	}
+	if m.result {
+		result = out[0].Interface()
+	}
+	return result, nil
+}
+
+// Incoming message, a request, a notification or a response.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error"`
+}
+
+// Outgoing request or notification.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// Outgoing response.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Null id of responses which request id is unknown.
+var nullID = json.RawMessage("null")
+
+// Reports whether id is a string, a number or null.
+func validID(id json.RawMessage) bool {
+	if len(id) < 1 {
+		return false
+	}
+	switch c := id[0]; {
+	case c == '"', c == '-', c >= '0' && c <= '9':
+		return true
+	default:
+		return string(id) == "null"
+	}
+}
+
+// Reports whether params is absent, an array or an object.
+func validParams(params json.RawMessage) bool {
+	return len(params) < 1 || params[0] == '[' || params[0] == '{'
+}
+
+// Encode params, nil(or a nil slice, map, pointer) means no params.
+func encodeParams(params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	if !validParams(data) {
+		return nil, errParams
+	}
+	return data, nil
+}
@@ -0,0 +1,110 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type test_Args struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func test_Registry() *Registry {
+	r := NewRegistry()
+	r.Register("add", func(args test_Args) (int, error) {
+		return args.A + args.B, nil
+	})
+	r.Register("sum", func(ctx context.Context, args []int) (int, error) {
+		n := 0
+		for _, a := range args {
+			n += a
+		}
+		return n, nil
+	})
+	r.Register("ping", func() error {
+		return nil
+	})
+	r.Register("fail", func() (string, error) {
+		return "", errors.New("failed")
+	})
+	r.Register("custom", func() error {
+		return &Error{Code: 1, Message: "custom", Data: "data"}
+	})
+	r.Register("panic", func() error {
+		panic("oops")
+	})
+	return r
+}
+
+func Test_Registry_Register(t *testing.T) {
+	for _, fn := range []interface{}{
+		1,
+		func() {},
+		func() int { return 0 },
+		func() (int, int) { return 0, 0 },
+		func(int, int) error { return nil },
+		func(context.Context, int, int) error { return nil },
+		func() (int, error, error) { return 0, nil, nil },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T must panic", fn)
+				}
+			}()
+			NewRegistry().Register("m", fn)
+		}()
+	}
+}
+
+func Test_Registry_Call(t *testing.T) {
+	r := test_Registry()
+	for _, c := range []struct {
+		method, params, result string
+		code                   int
+	}{
+		{"add", `{"a":1,"b":2}`, `3`, 0},
+		{"sum", `[1,2,3]`, `6`, 0},
+		{"sum", ``, `0`, 0},
+		{"ping", ``, `null`, 0},
+		{"add", `[1,2]`, ``, CodeInvalidParams},
+		{"none", ``, ``, CodeMethodNotFound},
+		{"fail", ``, ``, CodeInternalError},
+		{"custom", ``, ``, 1},
+		{"panic", ``, ``, CodeInternalError},
+	} {
+		result, err := r.call(context.Background(), c.method, json.RawMessage(c.params))
+		if c.code != 0 {
+			if err == nil || err.Code != c.code {
+				t.Errorf("%s %s: %v", c.method, c.params, err)
+			}
+			continue
+		}
+		if err != nil || string(result) != c.result {
+			t.Errorf("%s %s: %s %v", c.method, c.params, result, err)
+		}
+	}
+	r.Unregister("add")
+	_, err := r.call(context.Background(), "add", nil)
+	if err == nil || err.Code != CodeMethodNotFound {
+		t.Error(err)
+	}
+}
+
+func Test_encodeParams(t *testing.T) {
+	data, err := encodeParams(nil)
+	if err != nil || data != nil {
+		t.Error(data, err)
+	}
+	data, err = encodeParams([]int{1})
+	if err != nil || string(data) != `[1]` {
+		t.Error(data, err)
+	}
+	_, err = encodeParams(1)
+	if err != errParams {
+		t.Error(err)
+	}
+}
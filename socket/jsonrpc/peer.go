@@ -0,0 +1,489 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/qq51529210/web/socket"
+)
+
+// Options of NewPeer.
+type Options struct {
+	// Max length of a message, <=0 means no limit.
+	MaxMessageLength int
+	// Max time of waiting for a response, if the context of a call has no deadline, 0 means no limit.
+	Timeout time.Duration
+	// Max number of requests served at the same time, 0 means 64, <0 means no limit.
+	// More requests are answered with CodeServerBusy, notifications are dropped,
+	// reading goes on, so methods which call the other side still get responses.
+	MaxConcurrency int
+	// Max number of requests in a batch, larger batches are Invalid Request, 0 means 100, <0 means no limit.
+	MaxBatchSize int
+}
+
+// Peer is one side of a JSON-RPC connection, it serves calls of the other side by Registry,
+// and calls the other side's methods.
+type Peer struct {
+	conn     *socket.Conn
+	registry *Registry
+	opt      Options
+	// Context of handlers, canceled when Serve returns.
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	// Id of next call.
+	id uint64
+	// Waiting calls by id.
+	pending map[string]chan *message
+	// Closed when Serve returns, err is the reason.
+	done chan struct{}
+	err  error
+	// Semaphore of serving requests, nil means no limit.
+	sem chan struct{}
+}
+
+type peerKey struct{}
+
+// Create a Peer on conn, registry can be nil if the peer only calls.
+// Serve must be called to read responses and requests.
+func NewPeer(conn *socket.Conn, registry *Registry, opt *Options) *Peer {
+	p := &Peer{
+		conn:     conn,
+		registry: registry,
+		pending:  make(map[string]chan *message),
+		done:     make(chan struct{}),
+	}
+	if p.registry == nil {
+		p.registry = NewRegistry()
+	}
+	if opt != nil {
+		p.opt = *opt
+	}
+	if p.opt.MaxConcurrency == 0 {
+		p.opt.MaxConcurrency = 64
+	}
+	if p.opt.MaxConcurrency > 0 {
+		p.sem = make(chan struct{}, p.opt.MaxConcurrency)
+	}
+	if p.opt.MaxBatchSize == 0 {
+		p.opt.MaxBatchSize = 100
+	}
+	p.ctx, p.cancel = context.WithCancel(context.WithValue(context.Background(), peerKey{}, p))
+	return p
+}
+
+// Return the Peer which serves the call, in a method's context.
+func PeerFromContext(ctx context.Context) *Peer {
+	p, _ := ctx.Value(peerKey{}).(*Peer)
+	return p
+}
+
+// Return the connection.
+func (p *Peer) Conn() *socket.Conn {
+	return p.conn
+}
+
+// Close the connection, Serve returns, and waiting calls return ErrClosed.
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
+
+// Return a channel which is closed when Serve returns.
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+// Return the error which ends Serve, after Done is closed.
+func (p *Peer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Read messages until the connection ends, requests are served in new goroutines,
+// no more than MaxConcurrency at the same time, see Options.MaxConcurrency.
+// Calls waiting for responses return ErrClosed, the connection is closed before it returns.
+func (p *Peer) Serve() error {
+	err := p.conn.ReadLoop(p.opt.MaxMessageLength, func(code socket.Code, data []byte) error {
+		if code != socket.CodeText && code != socket.CodeBinary {
+			return nil
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) > 0 && data[0] == '[' {
+			var batch []json.RawMessage
+			if json.Unmarshal(data, &batch) != nil {
+				return p.write(&response{JSONRPC: "2.0", Error: &Error{Code: CodeParseError, Message: "Parse error"}, ID: nullID})
+			}
+			if len(batch) < 1 || (p.opt.MaxBatchSize > 0 && len(batch) > p.opt.MaxBatchSize) {
+				return p.write(&response{JSONRPC: "2.0", Error: &Error{Code: CodeInvalidRequest, Message: "Invalid Request"}, ID: nullID})
+			}
+			var requests []*message
+			for _, b := range batch {
+				m, res := p.decode(b)
+				if m != nil {
+					requests = append(requests, m)
+				} else if res != nil {
+					// Invalid, answer it in the batch.
+					requests = append(requests, &message{Error: res.Error})
+				}
+			}
+			if len(requests) < 1 {
+				return nil
+			}
+			if !p.tryAcquire() {
+				var batch []*response
+				for _, m := range requests {
+					if res := p.busy(m); res != nil {
+						batch = append(batch, res)
+					}
+				}
+				if len(batch) > 0 {
+					return p.write(batch)
+				}
+				return nil
+			}
+			go func() {
+				p.serveBatch(requests)
+				p.release()
+			}()
+			return nil
+		}
+		if !json.Valid(data) {
+			return p.write(&response{JSONRPC: "2.0", Error: &Error{Code: CodeParseError, Message: "Parse error"}, ID: nullID})
+		}
+		m, res := p.decode(data)
+		if res != nil {
+			return p.write(res)
+		}
+		if m != nil {
+			if !p.tryAcquire() {
+				if res := p.busy(m); res != nil {
+					return p.write(res)
+				}
+				return nil
+			}
+			go func() {
+				if res := p.serve(m); res != nil {
+					p.write(res)
+				}
+				p.release()
+			}()
+		}
+		return nil
+	})
+	p.mu.Lock()
+	p.err = err
+	close(p.done)
+	p.mu.Unlock()
+	p.cancel()
+	p.conn.Close()
+	return err
+}
+
+// Decode a message, responses are delivered to waiting calls.
+// Return the request, or the error response of an invalid request.
+func (p *Peer) decode(data json.RawMessage) (*message, *response) {
+	m := new(message)
+	err := json.Unmarshal(data, m)
+	if err != nil || m.JSONRPC != "2.0" {
+		id := nullID
+		if validID(m.ID) {
+			id = m.ID
+		}
+		return nil, &response{JSONRPC: "2.0", Error: &Error{Code: CodeInvalidRequest, Message: "Invalid Request"}, ID: id}
+	}
+	if m.Method == "" {
+		if len(m.Result) > 0 || m.Error != nil {
+			p.deliver(m)
+			return nil, nil
+		}
+		return nil, &response{JSONRPC: "2.0", Error: &Error{Code: CodeInvalidRequest, Message: "Invalid Request"}, ID: nullID}
+	}
+	if (len(m.ID) > 0 && !validID(m.ID)) || !validParams(m.Params) {
+		return nil, &response{JSONRPC: "2.0", Error: &Error{Code: CodeInvalidRequest, Message: "Invalid Request"}, ID: nullID}
+	}
+	return m, nil
+}
+
+// Deliver a response to the waiting call.
+func (p *Peer) deliver(m *message) {
+	p.mu.Lock()
+	ch, ok := p.pending[string(m.ID)]
+	delete(p.pending, string(m.ID))
+	p.mu.Unlock()
+	if ok {
+		ch <- m
+	}
+}
+
+// Serve a request, return nil if it is a notification.
+func (p *Peer) serve(m *message) *response {
+	result, err := p.registry.call(p.ctx, m.Method, m.Params)
+	if len(m.ID) < 1 {
+		return nil
+	}
+	if err != nil {
+		return &response{JSONRPC: "2.0", Error: err, ID: m.ID}
+	}
+	return &response{JSONRPC: "2.0", Result: result, ID: m.ID}
+}
+
+// Try to get a free slot of serving without waiting.
+func (p *Peer) tryAcquire() bool {
+	if p.sem == nil {
+		return true
+	}
+	select {
+	case p.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Return the response of a request which is not served because of MaxConcurrency,
+// nil if it is a notification. A message with Error is an invalid request.
+func (p *Peer) busy(m *message) *response {
+	if m.Error != nil {
+		return &response{JSONRPC: "2.0", Error: m.Error, ID: nullID}
+	}
+	if len(m.ID) < 1 {
+		return nil
+	}
+	return &response{JSONRPC: "2.0", Error: &Error{Code: CodeServerBusy, Message: "Server busy"}, ID: m.ID}
+}
+
+// Free a slot of serving.
+func (p *Peer) release() {
+	if p.sem != nil {
+		<-p.sem
+	}
+}
+
+// Serve requests of a batch, then write responses in one message.
+// A request is served in a new goroutine if there is a free slot, otherwise in this one.
+// A message with Error is an invalid request.
+func (p *Peer) serveBatch(requests []*message) {
+	responses := make([]*response, len(requests))
+	var wg sync.WaitGroup
+	for i, m := range requests {
+		if m.Error != nil {
+			responses[i] = &response{JSONRPC: "2.0", Error: m.Error, ID: nullID}
+			continue
+		}
+		if !p.tryAcquire() {
+			responses[i] = p.serve(m)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, m *message) {
+			responses[i] = p.serve(m)
+			p.release()
+			wg.Done()
+		}(i, m)
+	}
+	wg.Wait()
+	var batch []*response
+	for _, res := range responses {
+		if res != nil {
+			batch = append(batch, res)
+		}
+	}
+	// All are notifications.
+	if len(batch) > 0 {
+		p.write(batch)
+	}
+}
+
+// Encode v then write it as a text message.
+func (p *Peer) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.conn.Write(socket.CodeText, data, 0)
+}
+
+// Register a call, return its id and the channel of the response.
+func (p *Peer) register() (json.RawMessage, chan *message, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return nil, nil, ErrClosed
+	default:
+	}
+	p.id++
+	id := json.RawMessage(strconv.FormatUint(p.id, 10))
+	ch := make(chan *message, 1)
+	p.pending[string(id)] = ch
+	return id, ch, nil
+}
+
+// Remove a waiting call.
+func (p *Peer) unregister(id json.RawMessage) {
+	p.mu.Lock()
+	delete(p.pending, string(id))
+	p.mu.Unlock()
+}
+
+// Add Options.Timeout to ctx if it has no deadline.
+func (p *Peer) timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); !ok && p.opt.Timeout > 0 {
+		return context.WithTimeout(ctx, p.opt.Timeout)
+	}
+	return ctx, func() {}
+}
+
+// Wait for the response of a call, decode the result into result(nil means discard).
+func (p *Peer) wait(ctx context.Context, id json.RawMessage, ch chan *message, result interface{}) error {
+	select {
+	case m := <-ch:
+		if m.Error != nil {
+			return m.Error
+		}
+		if result != nil {
+			return json.Unmarshal(m.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		p.unregister(id)
+		return ctx.Err()
+	case <-p.done:
+		return ErrClosed
+	}
+}
+
+// Call method of the other side with params(nil means no params), and wait for the response.
+// The result is decoded into result, nil means discard.
+// It returns *Error if the other side responds an error,
+// or the error of ctx if it is done before the response, or ErrClosed.
+func (p *Peer) Call(ctx context.Context, method string, params, result interface{}) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+	data, err := encodeParams(params)
+	if err != nil {
+		return err
+	}
+	id, ch, err := p.register()
+	if err != nil {
+		return err
+	}
+	err = p.write(&request{JSONRPC: "2.0", Method: method, Params: data, ID: id})
+	if err != nil {
+		p.unregister(id)
+		return err
+	}
+	return p.wait(ctx, id, ch, result)
+}
+
+// Call method of the other side without waiting for a response.
+func (p *Peer) Notify(method string, params interface{}) error {
+	data, err := encodeParams(params)
+	if err != nil {
+		return err
+	}
+	return p.write(&request{JSONRPC: "2.0", Method: method, Params: data})
+}
+
+// A call of Batch.
+type BatchCall struct {
+	Method string
+	Params interface{}
+	// Decode result into it, nil means discard.
+	Result interface{}
+	// Notification does not wait for a response.
+	Notify bool
+	// Error of the call, *Error if the other side responds an error.
+	Error error
+}
+
+// Send calls in one message, then wait for all responses.
+// The error of every call is set to BatchCall.Error,
+// it returns error only if the batch can not be sent.
+func (p *Peer) Batch(ctx context.Context, calls ...*BatchCall) error {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+	batch := make([]*request, 0, len(calls))
+	ids := make([]json.RawMessage, len(calls))
+	chs := make([]chan *message, len(calls))
+	defer func() {
+		for _, id := range ids {
+			if id != nil {
+				p.unregister(id)
+			}
+		}
+	}()
+	for i, c := range calls {
+		data, err := encodeParams(c.Params)
+		if err != nil {
+			return err
+		}
+		r := &request{JSONRPC: "2.0", Method: c.Method, Params: data}
+		if !c.Notify {
+			r.ID, chs[i], err = p.register()
+			if err != nil {
+				return err
+			}
+			ids[i] = r.ID
+		}
+		batch = append(batch, r)
+	}
+	err := p.write(batch)
+	if err != nil {
+		return err
+	}
+	for i, c := range calls {
+		if !c.Notify {
+			c.Error = p.wait(ctx, ids[i], chs[i], c.Result)
+		}
+	}
+	return nil
+}
+
+// Return a http.Handler, which accepts websocket connections, then Serve them with registry.
+// If connected is not nil, it is called with the new Peer in a new goroutine,
+// to call the client after the connection is established.
+// MaxMessageLength, timeouts, heartbeat and Accept of opt are used,
+// peerOpt is the Options of every Peer, its MaxMessageLength is MaxMessageLength of opt if it is 0.
+func NewHTTPHandler(registry *Registry, opt *socket.ServeOptions, peerOpt *Options, connected func(*Peer)) http.Handler {
+	h := &httpHandler{registry: registry, opt: opt, connected: connected}
+	if h.opt == nil {
+		h.opt = new(socket.ServeOptions)
+	}
+	if peerOpt != nil {
+		h.peerOpt = *peerOpt
+	}
+	if h.peerOpt.MaxMessageLength == 0 {
+		h.peerOpt.MaxMessageLength = h.opt.MaxMessageLength
+	}
+	return h
+}
+
+type httpHandler struct {
+	registry  *Registry
+	opt       *socket.ServeOptions
+	peerOpt   Options
+	connected func(*Peer)
+}
+
+func (h *httpHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	// The error response has been written.
+	conn, err := socket.AcceptWithOptions(res, req, h.opt.Accept)
+	if err != nil {
+		return
+	}
+	conn.SetReadTimeout(h.opt.ReadTimeout)
+	conn.SetWriteTimeout(h.opt.WriteTimeout)
+	conn.StartHeartbeat(h.opt.Heartbeat())
+	p := NewPeer(conn, h.registry, &h.peerOpt)
+	if h.connected != nil {
+		go h.connected(p)
+	}
+	p.Serve()
+}
@@ -0,0 +1,364 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qq51529210/web/socket"
+)
+
+// Server of test_Registry, with "callback" which calls "echo" of the client.
+func test_Server(t *testing.T, opt *Options, connected func(*Peer)) (string, func()) {
+	r := test_Registry()
+	r.Register("callback", func(ctx context.Context, args []string) (string, error) {
+		var s string
+		err := PeerFromContext(ctx).Call(ctx, "echo", args, &s)
+		return s, err
+	})
+	srv := httptest.NewServer(NewHTTPHandler(r, nil, opt, connected))
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), srv.Close
+}
+
+// Dial url, return a serving client Peer.
+func test_Client(t *testing.T, url string, r *Registry, opt *Options) *Peer {
+	conn, _, err := socket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPeer(conn, r, opt)
+	go p.Serve()
+	return p
+}
+
+func Test_Peer(t *testing.T) {
+	url, stop := test_Server(t, nil, nil)
+	defer stop()
+	r := NewRegistry()
+	r.Register("echo", func(args []string) (string, error) {
+		return strings.Join(args, " "), nil
+	})
+	p := test_Client(t, url, r, nil)
+	defer p.Close()
+	ctx := context.Background()
+	// Typed params.
+	var n int
+	err := p.Call(ctx, "add", &test_Args{A: 1, B: 2}, &n)
+	if err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	err = p.Call(ctx, "sum", []int{1, 2, 3}, &n)
+	if err != nil || n != 6 {
+		t.Fatal(n, err)
+	}
+	// Errors.
+	var e *Error
+	err = p.Call(ctx, "none", nil, nil)
+	if !errors.As(err, &e) || e.Code != CodeMethodNotFound {
+		t.Fatal(err)
+	}
+	err = p.Call(ctx, "add", []int{1, 2}, nil)
+	if !errors.As(err, &e) || e.Code != CodeInvalidParams {
+		t.Fatal(err)
+	}
+	err = p.Call(ctx, "custom", nil, nil)
+	if !errors.As(err, &e) || e.Code != 1 || e.Data != "data" {
+		t.Fatal(err)
+	}
+	// Server calls client.
+	var s string
+	err = p.Call(ctx, "callback", []string{"a", "b"}, &s)
+	if err != nil || s != "a b" {
+		t.Fatal(s, err)
+	}
+	// Notification.
+	err = p.Notify("ping", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Batch.
+	calls := []*BatchCall{
+		{Method: "add", Params: &test_Args{A: 3, B: 4}, Result: new(int)},
+		{Method: "ping", Notify: true},
+		{Method: "none"},
+		{Method: "sum", Params: []int{5}, Result: new(int)},
+	}
+	err = p.Batch(ctx, calls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls[0].Error != nil || *calls[0].Result.(*int) != 7 {
+		t.Fatal(calls[0])
+	}
+	if calls[1].Error != nil {
+		t.Fatal(calls[1])
+	}
+	if !errors.As(calls[2].Error, &e) || e.Code != CodeMethodNotFound {
+		t.Fatal(calls[2])
+	}
+	if calls[3].Error != nil || *calls[3].Result.(*int) != 5 {
+		t.Fatal(calls[3])
+	}
+}
+
+func Test_Peer_Connected(t *testing.T) {
+	called := make(chan error, 1)
+	url, stop := test_Server(t, nil, func(p *Peer) {
+		var s string
+		err := p.Call(context.Background(), "echo", []string{"hello"}, &s)
+		if err == nil && s != "hello" {
+			err = errors.New(s)
+		}
+		called <- err
+	})
+	defer stop()
+	r := NewRegistry()
+	r.Register("echo", func(args []string) (string, error) {
+		return strings.Join(args, " "), nil
+	})
+	p := test_Client(t, url, r, nil)
+	defer p.Close()
+	select {
+	case err := <-called:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+func Test_Peer_Timeout(t *testing.T) {
+	url, stop := test_Server(t, nil, nil)
+	defer stop()
+	// "echo" never returns, so does the server's "callback".
+	wait := make(chan struct{})
+	defer close(wait)
+	r := NewRegistry()
+	r.Register("echo", func() error {
+		<-wait
+		return nil
+	})
+	p := test_Client(t, url, r, &Options{Timeout: time.Millisecond * 100})
+	defer p.Close()
+	err := p.Call(context.Background(), "callback", nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	n := len(p.pending)
+	p.mu.Unlock()
+	if n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func Test_Peer_Closed(t *testing.T) {
+	url, stop := test_Server(t, nil, nil)
+	defer stop()
+	conn, _, err := socket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "echo" never returns, so does the server's "callback".
+	wait := make(chan struct{})
+	defer close(wait)
+	reg := NewRegistry()
+	reg.Register("echo", func() error {
+		<-wait
+		return nil
+	})
+	p := NewPeer(conn, reg, nil)
+	served := make(chan error, 1)
+	go func() {
+		served <- p.Serve()
+	}()
+	r := make(chan error, 1)
+	go func() {
+		r <- p.Call(context.Background(), "callback", nil, nil)
+	}()
+	time.Sleep(time.Millisecond * 50)
+	p.Close()
+	select {
+	case err = <-r:
+		if err != ErrClosed {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+	<-served
+	err = p.Call(context.Background(), "ping", nil, nil)
+	if err != ErrClosed {
+		t.Fatal(err)
+	}
+}
+
+func Test_Peer_Wire(t *testing.T) {
+	url, stop := test_Server(t, nil, nil)
+	defer stop()
+	conn, _, err := socket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, c := range []struct{ req, res string }{
+		{`{"jsonrpc":"2.0","method":"add","params":{"a":1,"b":2},"id":1}`, `{"jsonrpc":"2.0","result":3,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"sum","params":[1,2],"id":"a"}`, `{"jsonrpc":"2.0","result":3,"id":"a"}`},
+		{`{"jsonrpc":"2.0","method":"none","id":2}`, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":2}`},
+		{`{"jsonrpc":"2.0","method":"add","params":[1`, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{`{"jsonrpc":"2.0","method":1,"params":"bar"}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`{"jsonrpc":"2.0","method":"add","params":1,"id":3}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[]`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[1,2]`, `[{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}]`},
+		// Notifications are not answered, the response is the next request's.
+		{`{"jsonrpc":"2.0","method":"ping"}`, ``},
+		{`[{"jsonrpc":"2.0","method":"ping"},{"jsonrpc":"2.0","method":"ping"}]`, ``},
+		{`[{"jsonrpc":"2.0","method":"ping"},{"jsonrpc":"2.0","method":"sum","params":[4],"id":4}]`, `[{"jsonrpc":"2.0","result":4,"id":4}]`},
+	} {
+		err = conn.Write(socket.CodeText, []byte(c.req), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.res == "" {
+			continue
+		}
+		_, r, err := conn.NextReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		if string(b) != c.res {
+			t.Errorf("%s\nwant %s\ngot  %s", c.req, c.res, b)
+		}
+	}
+}
+
+func Test_Peer_Limit(t *testing.T) {
+	var running, max int32
+	release := make(chan struct{})
+	r := NewRegistry()
+	r.Register("block", func() (int, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return 1, nil
+	})
+	srv := httptest.NewServer(NewHTTPHandler(r, nil, &Options{MaxConcurrency: 2, MaxBatchSize: 3}, nil))
+	defer srv.Close()
+	conn, _, err := socket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	write := func(s string) {
+		err := conn.Write(socket.CodeText, []byte(s), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() string {
+		_, r, err := conn.NextReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		return string(b)
+	}
+	// The batch takes both slots.
+	write(`[{"jsonrpc":"2.0","method":"block","id":1},{"jsonrpc":"2.0","method":"block","id":2}]`)
+	deadline := time.Now().Add(time.Second * 5)
+	for atomic.LoadInt32(&running) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal(atomic.LoadInt32(&running))
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	// Busy, notifications are dropped.
+	write(`{"jsonrpc":"2.0","method":"block"}`)
+	write(`{"jsonrpc":"2.0","method":"block","id":3}`)
+	if s := read(); s != `{"jsonrpc":"2.0","error":{"code":-32000,"message":"Server busy"},"id":3}` {
+		t.Fatal(s)
+	}
+	write(`[{"jsonrpc":"2.0","method":"block"},{"jsonrpc":"2.0","method":"block","id":4}]`)
+	if s := read(); s != `[{"jsonrpc":"2.0","error":{"code":-32000,"message":"Server busy"},"id":4}]` {
+		t.Fatal(s)
+	}
+	close(release)
+	if s := read(); s != `[{"jsonrpc":"2.0","result":1,"id":1},{"jsonrpc":"2.0","result":1,"id":2}]` {
+		t.Fatal(s)
+	}
+	if n := atomic.LoadInt32(&max); n != 2 {
+		t.Fatal(n)
+	}
+	// Too large.
+	write(`[{"jsonrpc":"2.0","method":"block","id":1},{"jsonrpc":"2.0","method":"block","id":2},{"jsonrpc":"2.0","method":"block","id":3},{"jsonrpc":"2.0","method":"block","id":4}]`)
+	if s := read(); s != `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}` {
+		t.Fatal(s)
+	}
+	write(`[{"jsonrpc":"2.0","method":"block","id":1},{"jsonrpc":"2.0","method":"block","id":2},{"jsonrpc":"2.0","method":"block","id":3}]`)
+	if s := read(); s != `[{"jsonrpc":"2.0","result":1,"id":1},{"jsonrpc":"2.0","result":1,"id":2},{"jsonrpc":"2.0","result":1,"id":3}]` {
+		t.Fatal(s)
+	}
+}
+
+func Test_Peer_Limit_Reentrant(t *testing.T) {
+	// Every slot of the server waits for "echo" of the client.
+	url, stop := test_Server(t, &Options{MaxConcurrency: 2}, nil)
+	defer stop()
+	release := make(chan struct{})
+	r := NewRegistry()
+	r.Register("echo", func(args []string) (string, error) {
+		<-release
+		return strings.Join(args, " "), nil
+	})
+	p := test_Client(t, url, r, nil)
+	defer p.Close()
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			var s string
+			err := p.Call(context.Background(), "callback", []string{"a"}, &s)
+			if err == nil && s != "a" {
+				err = errors.New(s)
+			}
+			errs <- err
+		}()
+	}
+	// Responses are still read.
+	timer := time.NewTimer(time.Second * 5)
+	defer timer.Stop()
+	var e *Error
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if !errors.As(err, &e) || e.Code != CodeServerBusy {
+				t.Fatal(err)
+			}
+		case <-timer.C:
+			t.Fatal("deadlock")
+		}
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-timer.C:
+			t.Fatal("deadlock")
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package socket
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"sync"
+)
+
+// PreparedMessage caches the encoded frames of a message,
+// so that writing it to many connections encodes(and compresses) it only once.
+type PreparedMessage struct {
+	code    Code
+	data    []byte
+	payload int
+	mu      sync.Mutex
+	frames  map[preparedKey][]byte
+}
+
+// Key of PreparedMessage.frames.
+type preparedKey struct {
+	compressed bool
+	level      int
+}
+
+// Create a PreparedMessage, arguments are the same as Conn.Write.
+// Data must not be modified after calling.
+func NewPreparedMessage(code Code, data []byte, payload int) (*PreparedMessage, error) {
+	if code == CodeClose {
+		return nil, errors.New("websocket: close frame can not be prepared")
+	}
+	if code.isControl() && len(data) > maxControlPayload {
+		return nil, errors.New("websocket: control frame payload too long")
+	}
+	if code.isControl() || payload <= 0 {
+		payload = len(data)
+	}
+	return &PreparedMessage{
+		code:    code,
+		data:    data,
+		payload: payload,
+		frames:  make(map[preparedKey][]byte),
+	}, nil
+}
+
+// Return unmasked frames, compressed without context takeover by level if compressed.
+func (m *PreparedMessage) encode(compressed bool, level int) ([]byte, error) {
+	key := preparedKey{compressed: compressed, level: level}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f, ok := m.frames[key]; ok {
+		return f, nil
+	}
+	data := m.data
+	var rsv byte
+	if compressed {
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(data)
+		err = w.Flush()
+		if err != nil {
+			return nil, err
+		}
+		// Remove sync flush marker 0x00 0x00 0xff 0xff.
+		data = buf.Bytes()[:buf.Len()-4]
+		rsv = rsv1Bit
+	}
+	var b encodeBuffer
+	code, p := m.code, data
+	for {
+		n := len(p)
+		if n > m.payload && m.payload > 0 {
+			n = m.payload
+		}
+		bits := _Fin[0] | rsv
+		if n == len(p) {
+			bits = _Fin[1] | rsv
+		}
+		b.PutFrameHeader(bits, code, n, _Mask[0])
+		b.PutBytes(p[:n])
+		p = p[n:]
+		if len(p) < 1 {
+			break
+		}
+		// Continuation frames.
+		code, rsv = codeContinuation, 0
+	}
+	m.frames[key] = b.buf[:b.len]
+	return m.frames[key], nil
+}
+
+// Write a PreparedMessage, it is safe for concurrent use.
+// Client side connections must mask every frame with a new key,
+// they, and connections compressing with context takeover, fall back to Write.
+func (c *Conn) WritePrepared(m *PreparedMessage) error {
+	if c.mask != _Mask[0] {
+		return c.Write(m.code, m.data, m.payload)
+	}
+	compress, level := false, 0
+	if c.compress != nil && !m.code.isControl() {
+		c.compress.mu.Lock()
+		compress = c.compress.enabled && len(m.data) >= c.compress.threshold
+		c.compress.mu.Unlock()
+		if compress && !c.compress.writeNoContext {
+			return c.WriteCompress(m.code, m.data, m.payload, true)
+		}
+		level = c.compress.level
+	}
+	frames, err := m.encode(compress, level)
+	if err != nil {
+		return err
+	}
+	if !m.code.isControl() {
+		c.mmu.Lock()
+		defer c.mmu.Unlock()
+	}
+	return c.writeRaw(m.code, frames)
+}
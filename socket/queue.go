@@ -0,0 +1,167 @@
+package socket
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// Send after the queue was stopped.
+	ErrQueueClosed = errors.New("websocket: send queue closed")
+	// Message was dropped by QueueCloseSlow.
+	ErrSlowConsumer = errors.New("websocket: slow consumer")
+	// Send without StartQueue.
+	errNoQueue = errors.New("websocket: send queue not started")
+)
+
+// What Send does when the send queue is full.
+type QueuePolicy int
+
+const (
+	// Send blocks until there is room.
+	QueueBlock QueuePolicy = iota
+	// Drop the oldest queued message, then queue the new one.
+	QueueDropOldest
+	// Close the connection, Send returns ErrSlowConsumer.
+	QueueCloseSlow
+)
+
+// Options of StartQueue.
+type QueueOptions struct {
+	// Max count of queued messages, <=0 means 64.
+	Capacity int
+	Policy   QueuePolicy
+	// Split message into frames, same as Write, <=0 means never split.
+	Payload int
+}
+
+// A queued message.
+type queueMessage struct {
+	code     Code
+	data     []byte
+	prepared *PreparedMessage
+}
+
+// Outbound message queue of a Conn.
+type sendQueue struct {
+	opt  QueueOptions
+	msg  chan queueMessage
+	done chan struct{}
+	once sync.Once
+	// Reason of stopping.
+	err error
+}
+
+// Stop the queue with err.
+func (q *sendQueue) stop(err error) {
+	q.once.Do(func() {
+		q.err = err
+		close(q.done)
+	})
+}
+
+// Start a goroutine, which writes messages queued by Send in order.
+// It stops when Close is called or a write fails, a failed write closes the connection.
+// Use SetWriteTimeout to limit the time of writing a frame.
+// It should be called once, before any Send.
+func (c *Conn) StartQueue(opt *QueueOptions) {
+	q := new(sendQueue)
+	if opt != nil {
+		q.opt = *opt
+	}
+	if q.opt.Capacity <= 0 {
+		q.opt.Capacity = 64
+	}
+	q.msg = make(chan queueMessage, q.opt.Capacity)
+	q.done = make(chan struct{})
+	c.queue = q
+	go c.queueLoop(q)
+}
+
+func (c *Conn) queueLoop(q *sendQueue) {
+	for {
+		select {
+		case <-q.done:
+			return
+		case m := <-q.msg:
+			var err error
+			if m.prepared != nil {
+				err = c.WritePrepared(m.prepared)
+			} else {
+				err = c.Write(m.code, m.data, q.opt.Payload)
+			}
+			if err != nil {
+				q.stop(err)
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// Queue a message, it returns before the message is written.
+// Data must not be modified after calling.
+// When the queue is full, it acts by QueuePolicy.
+func (c *Conn) Send(code Code, data []byte) error {
+	return c.send(queueMessage{code: code, data: data})
+}
+
+// Same as Send, but queue a PreparedMessage, it is written by WritePrepared.
+func (c *Conn) SendPrepared(m *PreparedMessage) error {
+	return c.send(queueMessage{prepared: m})
+}
+
+func (c *Conn) send(m queueMessage) error {
+	q := c.queue
+	if q == nil {
+		return errNoQueue
+	}
+	select {
+	case <-q.done:
+		return q.err
+	default:
+	}
+	// Fast path.
+	select {
+	case q.msg <- m:
+		return nil
+	default:
+	}
+	// Full.
+	switch q.opt.Policy {
+	case QueueDropOldest:
+		for {
+			select {
+			case <-q.done:
+				return q.err
+			case q.msg <- m:
+				return nil
+			default:
+				select {
+				case <-q.msg:
+				default:
+				}
+			}
+		}
+	case QueueCloseSlow:
+		// The peer does not read, a close frame can not be sent either.
+		q.stop(ErrSlowConsumer)
+		c.conn.Close()
+		return ErrSlowConsumer
+	default:
+		select {
+		case <-q.done:
+			return q.err
+		case q.msg <- m:
+			return nil
+		}
+	}
+}
+
+// Count of queued messages, 0 if the queue was not started.
+func (c *Conn) Queued() int {
+	if c.queue == nil {
+		return 0
+	}
+	return len(c.queue.msg)
+}
@@ -0,0 +1,138 @@
+package socket
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Read messages from server side until close.
+func test_ReadAll(conn *Conn) []string {
+	var msgs []string
+	conn.ReadLoop(0, func(c Code, b []byte) error {
+		if c == CodeText || c == CodeBinary {
+			msgs = append(msgs, string(b))
+		}
+		return nil
+	})
+	return msgs
+}
+
+func test_WaitQueued(t *testing.T, c *Conn, n int) {
+	for i := 0; c.Queued() != n; i++ {
+		if i > 100 {
+			t.Fatalf("queued %d, want %d", c.Queued(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_Conn_Write_Concurrent(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	client := newConn(c, nil, _Mask[1])
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte('a' + i)}, 100)
+			for j := 0; j < 10; j++ {
+				client.Write(CodeBinary, data, 7)
+				client.Write(CodePong, nil, 0)
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		client.Close()
+	}()
+	msgs := test_ReadAll(server)
+	if len(msgs) != 80 {
+		t.Fatal(len(msgs))
+	}
+	for _, m := range msgs {
+		if m != string(bytes.Repeat([]byte{m[0]}, 100)) {
+			t.Fatal(m)
+		}
+	}
+}
+
+func Test_Conn_Queue(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	client := newConn(c, nil, _Mask[1])
+	client.StartQueue(&QueueOptions{Capacity: 2})
+	go func() {
+		for i := 0; i < 10; i++ {
+			client.Send(CodeText, []byte(fmt.Sprint(i)))
+		}
+		test_WaitQueued(t, client, 0)
+		// Wait the writer.
+		client.mmu.Lock()
+		client.mmu.Unlock()
+		client.Close()
+	}()
+	msgs := test_ReadAll(server)
+	if fmt.Sprint(msgs) != "[0 1 2 3 4 5 6 7 8 9]" {
+		t.Fatal(msgs)
+	}
+	if client.Send(CodeText, nil) != ErrQueueClosed {
+		t.FailNow()
+	}
+}
+
+func Test_Conn_Queue_DropOldest(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	client := newConn(c, nil, _Mask[1])
+	client.StartQueue(&QueueOptions{Capacity: 2, Policy: QueueDropOldest})
+	// Writer blocks on "0", peer is not reading.
+	client.Send(CodeText, []byte("0"))
+	test_WaitQueued(t, client, 0)
+	for i := 1; i < 5; i++ {
+		client.Send(CodeText, []byte(fmt.Sprint(i)))
+	}
+	go func() {
+		test_WaitQueued(t, client, 0)
+		client.mmu.Lock()
+		client.mmu.Unlock()
+		client.Close()
+	}()
+	msgs := test_ReadAll(server)
+	if fmt.Sprint(msgs) != "[0 3 4]" {
+		t.Fatal(msgs)
+	}
+}
+
+func Test_Conn_Queue_CloseSlow(t *testing.T) {
+	s, c := net.Pipe()
+	defer s.Close()
+	client := newConn(c, nil, _Mask[1])
+	client.StartQueue(&QueueOptions{Capacity: 1, Policy: QueueCloseSlow})
+	client.Send(CodeText, []byte("0"))
+	test_WaitQueued(t, client, 0)
+	client.Send(CodeText, []byte("1"))
+	if client.Send(CodeText, []byte("2")) != ErrSlowConsumer {
+		t.FailNow()
+	}
+	if client.Send(CodeText, []byte("3")) != ErrSlowConsumer {
+		t.FailNow()
+	}
+}
+
+func Test_Conn_WriteTimeout(t *testing.T) {
+	s, c := net.Pipe()
+	defer s.Close()
+	client := newConn(c, nil, _Mask[1])
+	client.SetWriteTimeout(10 * time.Millisecond)
+	err := client.Write(CodeText, []byte("timeout"), 0)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,94 @@
+package socket
+
+import (
+	"net/http"
+	"time"
+)
+
+// Options of Serve.
+type ServeOptions struct {
+	// Max length of a message, <=0 means no limit.
+	MaxMessageLength int
+	// Max time of waiting for a frame, 0 means no limit.
+	// With PingInterval, the peer's pong keeps the connection alive.
+	ReadTimeout time.Duration
+	// Max time of writing a frame, 0 means no limit.
+	WriteTimeout time.Duration
+	// Interval of sending keepalive ping, 0 means never.
+	PingInterval time.Duration
+	// Max time of waiting for the pong of a keepalive ping, 0 means no limit.
+	PongTimeout time.Duration
+	// Max time of waiting for a data message, 0 means no limit.
+	IdleTimeout time.Duration
+	// Options of accepting connections by NewHTTPHandler, nil means the default.
+	Accept *AcceptOptions
+}
+
+// Serve read messages from conn and dispatch them to handler, until the connection ends.
+// Data passed to handler is only valid during the call.
+// HandleClose is called once at the end, data is the close frame payload,
+// or nil if the connection ended without a close frame.
+// Conn is closed before Serve returns, the returned error is the reason why ReadLoop stopped.
+func Serve(conn *Conn, handler Handler, opt *ServeOptions) error {
+	if opt == nil {
+		opt = new(ServeOptions)
+	}
+	conn.SetReadTimeout(opt.ReadTimeout)
+	conn.SetWriteTimeout(opt.WriteTimeout)
+	conn.StartHeartbeat(opt.Heartbeat())
+	closed := false
+	err := conn.ReadLoop(opt.MaxMessageLength, func(code Code, data []byte) error {
+		switch code {
+		case CodeText:
+			handler.HandleText(conn, data)
+		case CodeBinary:
+			handler.HandleBinary(conn, data)
+		case CodePing:
+			handler.HandlePing(conn, data)
+		case CodePong:
+			handler.HandlePong(conn, data)
+		case CodeClose:
+			closed = true
+			handler.HandleClose(conn, data)
+		}
+		return nil
+	})
+	if !closed {
+		handler.HandleClose(conn, nil)
+	}
+	conn.Close()
+	return err
+}
+
+// Return HeartbeatOptions of Serve.
+func (opt *ServeOptions) Heartbeat() *HeartbeatOptions {
+	return &HeartbeatOptions{
+		PingInterval: opt.PingInterval,
+		PongTimeout:  opt.PongTimeout,
+		IdleTimeout:  opt.IdleTimeout,
+	}
+}
+
+// Return a http.Handler, which accepts websocket connections, then Serve them with handler.
+// To use it in router, call ServeHTTP(ctx.ResponseWriter, ctx.Request) in the route's HandleFunc.
+// The request which is not acceptable gets a 4xx response.
+func NewHTTPHandler(handler Handler, opt *ServeOptions) http.Handler {
+	if opt == nil {
+		opt = new(ServeOptions)
+	}
+	return &httpHandler{handler: handler, opt: opt}
+}
+
+type httpHandler struct {
+	handler Handler
+	opt     *ServeOptions
+}
+
+func (h *httpHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	// The error response has been written.
+	conn, err := AcceptWithOptions(res, req, h.opt.Accept)
+	if err != nil {
+		return
+	}
+	Serve(conn, h.handler, h.opt)
+}
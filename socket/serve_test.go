@@ -0,0 +1,158 @@
+package socket
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Handshake with a test server without Dial.
+func test_Handshake(t *testing.T, url string, header http.Header) *Conn {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", GenSecWebSocketKey())
+	conn, err := net.Dial("tcp", req.URL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = req.Write(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd := bufio.NewReader(conn)
+	res, err := http.ReadResponse(rd, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal(res.Status)
+	}
+	c := newConn(conn, rd, _Mask[1])
+	c.compress, err = dialCompression(res.Header, new(CompressionOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Record calls.
+type testHandler struct {
+	calls chan test_Message
+}
+
+func (h *testHandler) HandleText(c *Conn, b []byte) {
+	h.calls <- test_Message{CodeText, string(b)}
+	c.Write(CodeText, b, 0)
+}
+
+func (h *testHandler) HandleBinary(c *Conn, b []byte) {
+	h.calls <- test_Message{CodeBinary, string(b)}
+}
+
+func (h *testHandler) HandleClose(c *Conn, b []byte) {
+	if b == nil {
+		h.calls <- test_Message{CodeClose, "<nil>"}
+		return
+	}
+	h.calls <- test_Message{CodeClose, string(b)}
+}
+
+func (h *testHandler) HandlePing(c *Conn, b []byte) {
+	h.calls <- test_Message{CodePing, string(b)}
+}
+
+func (h *testHandler) HandlePong(c *Conn, b []byte) {
+	h.calls <- test_Message{CodePong, string(b)}
+}
+
+// Expect next call, keepalive pongs are skipped.
+func (h *testHandler) Call(t *testing.T, code Code, data string) {
+	for {
+		select {
+		case m := <-h.calls:
+			if m.code == CodePong && code != CodePong {
+				continue
+			}
+			if m.code != code || m.data != data {
+				t.Fatalf("call %v %q, want %v %q", m.code, m.data, code, data)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatalf("call %v %q timeout", code, data)
+		}
+	}
+}
+
+func Test_Serve(t *testing.T) {
+	h := &testHandler{calls: make(chan test_Message, 16)}
+	ser := httptest.NewServer(NewHTTPHandler(h, &ServeOptions{PingInterval: 20 * time.Millisecond}))
+	defer ser.Close()
+	conn := test_Handshake(t, ser.URL, nil)
+	done := make(chan error, 1)
+	var echo []string
+	go func() {
+		done <- conn.ReadLoop(0, func(c Code, b []byte) error {
+			if c == CodeText {
+				echo = append(echo, string(b))
+			}
+			return nil
+		})
+	}()
+	conn.Write(CodeText, []byte("text"), 0)
+	h.Call(t, CodeText, "text")
+	conn.Write(CodeBinary, []byte("binary"), 0)
+	h.Call(t, CodeBinary, "binary")
+	conn.Write(CodePing, []byte("ping"), 0)
+	h.Call(t, CodePing, "ping")
+	// Keepalive ping, pong by client ReadLoop.
+	h.Call(t, CodePong, "")
+	conn.WriteClose(CloseGoingAway, "")
+	for m := range h.calls {
+		if m.code == CodeClose {
+			if m.data != "\x03\xe9" {
+				t.Fatal(m.data)
+			}
+			break
+		}
+	}
+	var e *CloseError
+	if err := <-done; !errors.As(err, &e) || e.Code != CloseGoingAway {
+		t.Fatal(err)
+	}
+	if len(echo) != 1 || echo[0] != "text" {
+		t.Fatal(echo)
+	}
+	conn.Close()
+	// Connection ends without close frame.
+	conn = test_Handshake(t, ser.URL, nil)
+	conn.conn.Close()
+	for m := range h.calls {
+		if m.code == CodeClose {
+			if m.data != "<nil>" {
+				t.Fatal(m.data)
+			}
+			break
+		}
+	}
+}
+
+func Test_Serve_ReadTimeout(t *testing.T) {
+	h := &testHandler{calls: make(chan test_Message, 16)}
+	ser := httptest.NewServer(NewHTTPHandler(h, &ServeOptions{ReadTimeout: 20 * time.Millisecond}))
+	defer ser.Close()
+	conn := test_Handshake(t, ser.URL, nil)
+	defer conn.Close()
+	h.Call(t, CodeClose, "<nil>")
+}
@@ -0,0 +1,373 @@
+package socket
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// Payload length of frames written by NextWriter.
+const writerFramePayload = 4096
+
+var errWriterClosed = errors.New("websocket: message writer closed")
+
+// Set max message length of NextReader, <=0 means no limit.
+// It does not affect ReadLoop.
+func (c *Conn) SetReadLimit(n int64) {
+	c.readLimit = n
+}
+
+// Return the code and a reader of next data message, frames are read as the reader is read,
+// so that a message of any length can be read with constant memory.
+// Compressed message is decompressed by the reader, text message is checked for UTF-8 encoding.
+// Ping frame is answered with a pong frame, close frame is answered with a close frame,
+// then NextReader or the reader returns *CloseError.
+// If the peer violates the protocol, or message length bigger than SetReadLimit,
+// it sends a close frame and return *ProtocolError.
+// The unread part of the previous message is discarded.
+// Errors are sticky, once an error is returned, all calls return it.
+// It must be called by one goroutine, and not be used with ReadLoop at the same time.
+func (c *Conn) NextReader() (Code, io.Reader, error) {
+	return c.nextReader(c.readLimit, nil)
+}
+
+// Return next message reader, handle is called on control frames, nil means ignore.
+func (c *Conn) nextReader(limit int64, handle func(Code, []byte) error) (Code, io.Reader, error) {
+	if c.reader != nil {
+		_, err := io.Copy(ioutil.Discard, c.reader)
+		c.reader = nil
+		if err != nil && c.readErr == nil {
+			c.readErr = err
+		}
+	}
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	if handle == nil {
+		handle = func(Code, []byte) error { return nil }
+	}
+	r := &messageReader{c: c, limit: limit, handle: handle}
+	err := r.nextFrame(true)
+	if err != nil {
+		return 0, nil, err
+	}
+	atomic.AddInt64(&c.stats.messagesIn, 1)
+	atomic.StoreInt64(&c.stats.lastMessage, time.Now().UnixNano())
+	c.reader = r
+	if r.h.rsv&rsv1Bit != 0 {
+		c.reader = &decompressReader{c: c, r: c.compress.reader(r), limit: limit}
+	}
+	if r.h.code == CodeText {
+		c.reader = &textReader{c: c, r: c.reader}
+	}
+	return r.h.code, c.reader, nil
+}
+
+// Read the payload of a message frame by frame.
+type messageReader struct {
+	c *Conn
+	// Header of current data frame.
+	h frameHeader
+	// Unread payload length of current frame.
+	remain int64
+	// Read payload length of current frame, use for unmasking.
+	pos int64
+	// Payload length of the message.
+	total  int64
+	limit  int64
+	handle func(Code, []byte) error
+}
+
+// Set c.readErr and return it.
+// If heartbeat closed the connection, its error is used instead.
+func (r *messageReader) setErr(err error) error {
+	if r.c.heartbeat != nil {
+		if e := r.c.heartbeat.closeErr(); e != nil {
+			err = e
+		}
+	}
+	r.c.readErr = err
+	return err
+}
+
+// Read frames until a data frame, control frames are handled.
+// The first data frame of a message must not be a continuation frame, the others must be.
+func (r *messageReader) nextFrame(first bool) error {
+	c := r.c
+	var ctl [maxControlPayload]byte
+	for {
+		err := c.readHeader(&r.h)
+		if err != nil {
+			return r.setErr(err)
+		}
+		if !r.h.code.isControl() {
+			break
+		}
+		// Control frame may be injected in the middle of a fragmented message.
+		data := ctl[:r.h.length]
+		_, err = io.ReadFull(c.r, data)
+		if err != nil {
+			return r.setErr(err)
+		}
+		if r.h.masked {
+			maskData(data, r.h.key[:])
+		}
+		err = c.handleControl(r.h.code, data, r.handle)
+		if err != nil {
+			return r.setErr(err)
+		}
+	}
+	if first && r.h.code == codeContinuation {
+		return r.setErr(c.fail(CloseProtocolError, "unexpected continuation frame"))
+	}
+	if !first && r.h.code != codeContinuation {
+		return r.setErr(c.fail(CloseProtocolError, "expected continuation frame"))
+	}
+	if (r.limit > 0 && r.total+r.h.length > r.limit) || r.h.length > maxInt-r.total {
+		return r.setErr(c.fail(CloseMessageTooBig, "message too big"))
+	}
+	r.remain = r.h.length
+	r.pos = 0
+	return nil
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	if r.c.readErr != nil {
+		return 0, r.c.readErr
+	}
+	for r.remain == 0 {
+		if r.h.fin {
+			return 0, io.EOF
+		}
+		err := r.nextFrame(false)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > r.remain {
+		p = p[:r.remain]
+	}
+	n, err := r.c.r.Read(p)
+	if r.h.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= r.h.key[(r.pos+int64(i))&3]
+		}
+	}
+	r.pos += int64(n)
+	r.remain -= int64(n)
+	r.total += int64(n)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, r.setErr(err)
+	}
+	return n, nil
+}
+
+// Decompress a compressed message.
+type decompressReader struct {
+	c *Conn
+	// Flate reader, nil after io.EOF.
+	r     io.ReadCloser
+	total int64
+	limit int64
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	if r.r == nil {
+		return 0, io.EOF
+	}
+	n, err := r.r.Read(p)
+	r.c.compress.keep(p[:n])
+	r.total += int64(n)
+	if r.limit > 0 && r.total > r.limit {
+		r.c.readErr = r.c.fail(CloseMessageTooBig, "message too big")
+		return 0, r.c.readErr
+	}
+	if err == io.EOF {
+		flateReaderPool.Put(r.r)
+		r.r = nil
+		return n, err
+	}
+	if err != nil {
+		// Error of reading frames, or invalid compressed data.
+		if r.c.readErr == nil {
+			r.c.readErr = r.c.fail(CloseInvalidPayload, "invalid compressed data")
+		}
+		return n, r.c.readErr
+	}
+	return n, nil
+}
+
+// Check UTF-8 encoding of a text message, incrementally.
+type textReader struct {
+	c *Conn
+	r io.Reader
+	// Incomplete rune at the end of the last read.
+	pending [utf8.UTFMax]byte
+	n       int
+}
+
+func (r *textReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if !r.check(p[:n]) || (err == io.EOF && r.n > 0) {
+		r.c.readErr = r.c.fail(CloseInvalidPayload, "invalid utf8 text")
+		return 0, r.c.readErr
+	}
+	return n, err
+}
+
+// Check b, which follows the pending bytes, keep the incomplete rune at the end.
+func (r *textReader) check(b []byte) bool {
+	// Complete the pending rune.
+	for r.n > 0 && len(b) > 0 {
+		r.pending[r.n] = b[0]
+		r.n++
+		b = b[1:]
+		if utf8.FullRune(r.pending[:r.n]) {
+			if c, size := utf8.DecodeRune(r.pending[:r.n]); c == utf8.RuneError && size <= 1 {
+				return false
+			}
+			r.n = 0
+		}
+	}
+	if r.n > 0 {
+		return true
+	}
+	// Find the incomplete rune at the end.
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				r.n = copy(r.pending[:], b[i:])
+				b = b[:i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(b)
+}
+
+// Return a writer of a code(text or binary) message, data is written in frames as the writer is written,
+// so that a message of any length can be written with constant memory.
+// The message is compressed if permessage-deflate was negotiated and enabled, threshold is ignored.
+// Close must be called to write the last frame,
+// other data messages are blocked until then, control frames are not.
+func (c *Conn) NextWriter(code Code) (io.WriteCloser, error) {
+	if code != CodeText && code != CodeBinary {
+		return nil, fmt.Errorf(`could not write "%s" data in a message writer`, code.String())
+	}
+	c.mmu.Lock()
+	w := &messageWriter{c: c, code: code}
+	if c.compress != nil {
+		c.compress.mu.Lock()
+		compress := c.compress.enabled
+		c.compress.mu.Unlock()
+		if compress {
+			c.compress.wbuf.Reset()
+			fw, err := c.compress.writer()
+			if err != nil {
+				c.mmu.Unlock()
+				return nil, err
+			}
+			w.fw = fw
+			w.rsv = rsv1Bit
+		}
+	}
+	return w, nil
+}
+
+// Write a message frame by frame, holds Conn.mmu until closed.
+type messageWriter struct {
+	c *Conn
+	// Code and rsv of next frame.
+	code Code
+	rsv  byte
+	// Not nil if compressed, it writes to Conn.compress.wbuf.
+	fw *flate.Writer
+	// Buffer of uncompressed frame.
+	buf []byte
+	err error
+}
+
+// Write a frame, next frame is a continuation frame.
+func (w *messageWriter) writeFrame(fin bool, data []byte) error {
+	bits := _Fin[0]
+	if fin {
+		bits = _Fin[1]
+	}
+	err := w.c.writeFrame(bits|w.rsv, w.code, data)
+	if err != nil {
+		w.err = err
+	}
+	w.code, w.rsv = codeContinuation, 0
+	return err
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.fw != nil {
+		_, err := w.fw.Write(p)
+		if err != nil {
+			w.err = err
+			return 0, err
+		}
+		// Keep 4 bytes, the sync flush marker is removed by Close.
+		b := &w.c.compress.wbuf
+		for b.Len() >= writerFramePayload+4 {
+			err = w.writeFrame(false, b.Next(writerFramePayload))
+			if err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if w.buf == nil {
+		w.buf = make([]byte, 0, writerFramePayload)
+	}
+	n := len(p)
+	for len(p) > 0 {
+		// Write a full frame only if there is more data, so that Close always has data to write.
+		if len(w.buf) == cap(w.buf) {
+			err := w.writeFrame(false, w.buf)
+			if err != nil {
+				return 0, err
+			}
+			w.buf = w.buf[:0]
+		}
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Write the last frame, then unblock other messages.
+func (w *messageWriter) Close() error {
+	if w.err == errWriterClosed {
+		return w.err
+	}
+	defer w.c.mmu.Unlock()
+	err := w.err
+	if err == nil {
+		if w.fw != nil {
+			err = w.fw.Flush()
+			if err == nil {
+				// Remove sync flush marker 0x00 0x00 0xff 0xff.
+				b := w.c.compress.wbuf.Bytes()
+				err = w.writeFrame(true, b[:len(b)-4])
+			}
+		} else {
+			err = w.writeFrame(true, w.buf)
+		}
+	}
+	w.err = errWriterClosed
+	return err
+}
@@ -0,0 +1,137 @@
+package socket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func Test_Conn_NextReader(t *testing.T) {
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	frames := make(chan Code, 16)
+	go func() {
+		client := newConn(c, nil, _Mask[1])
+		client.ReadLoop(0, func(code Code, b []byte) error {
+			frames <- code
+			return nil
+		})
+	}()
+	go func() {
+		for _, f := range [][]byte{
+			// Ping between fragments.
+			test_Frame(0x01, []byte("hello")), test_Frame(0x89, nil), test_Frame(0x80, []byte(" world")),
+			// Partly read.
+			test_Frame(0x02, []byte("discard")), test_Frame(0x80, []byte("ed")),
+			test_Frame(0x82, []byte("next")),
+			// Too big.
+			test_Frame(0x02, []byte("12345")), test_Frame(0x80, []byte("123456")),
+		} {
+			c.Write(f)
+		}
+	}()
+	code, r, err := server.NextReader()
+	if err != nil || code != CodeText {
+		t.Fatal(code, err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil || string(b) != "hello world" || <-frames != CodePong {
+		t.Fatal(string(b), err)
+	}
+	_, r, _ = server.NextReader()
+	b = make([]byte, 2)
+	io.ReadFull(r, b)
+	code, r, err = server.NextReader()
+	if err != nil || code != CodeBinary {
+		t.Fatal(code, err)
+	}
+	if b, _ = ioutil.ReadAll(r); string(b) != "next" {
+		t.Fatal(string(b))
+	}
+	server.SetReadLimit(10)
+	_, r, err = server.NextReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(r)
+	var e *ProtocolError
+	if !errors.As(err, &e) || e.Code != CloseMessageTooBig || <-frames != CodeClose {
+		t.Fatal(err)
+	}
+	// Sticky.
+	if _, _, err = server.NextReader(); !errors.As(err, &e) {
+		t.Fatal(err)
+	}
+	s.Close()
+}
+
+func Test_Conn_NextWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	write := func(client *Conn) {
+		for i := 0; i < 2; i++ {
+			w, err := client.NextWriter(CodeBinary)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for p := data; len(p) > 0; p = p[3000:] {
+				if len(p) < 3000 {
+					w.Write(p)
+					break
+				}
+				w.Write(p[:3000])
+			}
+			w.Close()
+			if _, err = w.Write(data); err != errWriterClosed {
+				t.Error(err)
+			}
+		}
+		client.Close()
+	}
+	// Frames.
+	s, c := net.Pipe()
+	server := newConn(s, nil, _Mask[0])
+	go write(newConn(c, nil, _Mask[1]))
+	var h frameHeader
+	n := 0
+	for server.readHeader(&h) == nil && h.code != CodeClose {
+		io.CopyN(ioutil.Discard, s, h.length)
+		if (n%3 == 0) != (h.code == CodeBinary) || (n%3 == 2) != h.fin || h.length > writerFramePayload {
+			t.Fatal(n, h)
+		}
+		n++
+	}
+	if n != 6 {
+		t.Fatal(n)
+	}
+	s.Close()
+	// Compressed, with context takeover.
+	for _, noContext := range []bool{false, true} {
+		s, c = net.Pipe()
+		server = newConn(s, nil, _Mask[0])
+		server.compress = newCompression(new(CompressionOptions), false, noContext)
+		client := newConn(c, nil, _Mask[1])
+		client.compress = newCompression(new(CompressionOptions), noContext, false)
+		go write(client)
+		for i := 0; i < 2; i++ {
+			code, r, err := server.NextReader()
+			if err != nil || code != CodeBinary {
+				t.Fatal(code, err)
+			}
+			if b, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(b, data) {
+				t.Fatal(err)
+			}
+		}
+		var e *CloseError
+		if _, _, err := server.NextReader(); !errors.As(err, &e) {
+			t.Fatal(err)
+		}
+		s.Close()
+	}
+	if _, err := server.NextWriter(CodePing); err == nil {
+		t.FailNow()
+	}
+}
@@ -3,27 +3,30 @@ package socket
 import (
 	"bufio"
 	"bytes"
+	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"io/ioutil"
 	"net/http"
-	"time"
+	"net/url"
+	"strings"
 )
 
+// The handshake request or response is not acceptable.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
 var (
 	serverRequiredHeader = map[string]string{
-		"Upgrade":               "websocket",
-		"Connection":            "Upgrade",
-		"Sec-WebSocket-Version": "13",
+		"Upgrade":    "websocket",
+		"Connection": "Upgrade",
 	}
 	clientRequiredHeader = map[string]string{
 		"Upgrade":    "websocket",
 		"Connection": "Upgrade",
 	}
-	random = rand.New(rand.NewSource(time.Now().UnixNano()))
 )
 
 // Implement this interface to handle message.
@@ -35,77 +38,275 @@ type Handler interface {
 	HandlePong(*Conn, []byte)
 }
 
+// Options of AcceptWithOptions.
+type AcceptOptions struct {
+	// Enable permessage-deflate if the client offers it, nil means disable.
+	Compression *CompressionOptions
+	// Supported subprotocols, the first one offered by the client in client's order is chosen.
+	// If none is supported, the connection is accepted without subprotocol.
+	Subprotocols []string
+	// Return false to reject the request's "Origin", nil means same origin(see SameOrigin).
+	CheckOrigin func(*http.Request) bool
+	// Extra response headers, websocket handshake headers can not be overwritten.
+	Header http.Header
+}
+
+// HandshakeError is returned by Accept when the request is rejected,
+// the error response has been written.
+type HandshakeError struct {
+	Status int
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket: %s(%d)", e.Reason, e.Status)
+}
+
+// Reports whether target is ErrBadHandshake.
+func (e *HandshakeError) Is(target error) bool {
+	return target == ErrBadHandshake
+}
+
+// Reports whether the request has no "Origin", or the host of "Origin" is the request host.
+// It is the default of AcceptOptions.CheckOrigin.
+func SameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+// Return an AcceptOptions.CheckOrigin, which allows requests without "Origin",
+// or with an "Origin" in origins(like "https://example.com"), case-insensitively.
+// "*" allows any origin.
+func AllowOrigins(origins ...string) func(*http.Request) bool {
+	return func(req *http.Request) bool {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, s := range origins {
+			if s == "*" || strings.EqualFold(s, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 // Server side connection.
 func Accept(res http.ResponseWriter, req *http.Request) (*Conn, error) {
-	// Check required headers
+	return AcceptWithOptions(res, req, nil)
+}
+
+// Write an error response, then return *HandshakeError.
+func rejectHandshake(res http.ResponseWriter, status int, reason string) error {
+	http.Error(res, http.StatusText(status)+", "+reason, status)
+	return &HandshakeError{Status: status, Reason: reason}
+}
+
+// Server side connection with options, nil opt is the same as Accept.
+// If the request is not acceptable, it writes a 4xx response and returns *HandshakeError.
+func AcceptWithOptions(res http.ResponseWriter, req *http.Request, opt *AcceptOptions) (*Conn, error) {
+	if opt == nil {
+		opt = new(AcceptOptions)
+	}
+	// Check request.
+	if req.Method != http.MethodGet {
+		res.Header().Set("Allow", http.MethodGet)
+		return nil, rejectHandshake(res, http.StatusMethodNotAllowed, "method is not GET")
+	}
 	err := checkRequiredHeader(req.Header, serverRequiredHeader)
 	if err != nil {
-		return nil, err
+		return nil, rejectHandshake(res, http.StatusBadRequest, err.Error())
+	}
+	if req.Header.Get("Sec-Websocket-Version") != "13" {
+		res.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, rejectHandshake(res, http.StatusUpgradeRequired, "unsupported websocket version")
 	}
-	// Check header
 	key := req.Header.Get("Sec-Websocket-Key")
-	if key == "" {
-		return nil, fmt.Errorf(`header "Sec-Websocket-Key" is required`)
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return nil, rejectHandshake(res, http.StatusBadRequest, `invalid "Sec-Websocket-Key"`)
+	}
+	checkOrigin := opt.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = SameOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, rejectHandshake(res, http.StatusForbidden, "origin is not allowed")
+	}
+	h, ok := res.(http.Hijacker)
+	if !ok {
+		return nil, rejectHandshake(res, http.StatusInternalServerError, "response can not be hijacked")
 	}
 	// Set response headers.
-	res.Header().Set("Upgrade", "websocket")
-	res.Header().Set("Connection", "Upgrade")
-	res.Header().Set("Sec-WebSocket-Accept", GenSecWebSocketAccept(key))
-	// Set status code.
-	res.WriteHeader(http.StatusSwitchingProtocols)
-	// Hijack net.Conn
-	h, o := res.(http.Hijacker)
-	if !o {
-		return nil, errors.New("hijacker response conn fail")
+	header := res.Header()
+	for k, v := range opt.Header {
+		header[k] = v
+	}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", GenSecWebSocketAccept(key))
+	header.Del("Sec-WebSocket-Extensions")
+	header.Del("Sec-WebSocket-Protocol")
+	// Subprotocol.
+	protocol := selectSubprotocol(req.Header, opt.Subprotocols)
+	if protocol != "" {
+		header.Set("Sec-WebSocket-Protocol", protocol)
 	}
+	// Extensions.
+	var compress *compression
+	if opt.Compression != nil {
+		var ext *extension
+		ext, compress = acceptCompression(req.Header, opt.Compression)
+		if ext != nil {
+			header.Set("Sec-WebSocket-Extensions", ext.String())
+		}
+	}
+	// Hijack net.Conn before writing the status, a wrapped writer may not support it.
 	conn, buf, err := h.Hijack()
 	if nil != err {
-		return nil, err
+		header.Del("Upgrade")
+		header.Del("Connection")
+		header.Del("Sec-WebSocket-Accept")
+		header.Del("Sec-WebSocket-Extensions")
+		header.Del("Sec-WebSocket-Protocol")
+		return nil, rejectHandshake(res, http.StatusInternalServerError, "response can not be hijacked")
 	}
 	// 响应给客户端
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(buf)
+	buf.WriteString("\r\n")
 	err = buf.Flush()
 	if nil != err {
+		conn.Close()
 		return nil, err
 	}
 	// Conn
-	return &Conn{conn: conn, mask: _Mask[0]}, nil
+	c := newConn(conn, buf.Reader, _Mask[0])
+	c.compress = compress
+	c.subprotocol = protocol
+	return c, nil
+}
+
+// Return the first subprotocol of the request, which is supported.
+func selectSubprotocol(header http.Header, supported []string) string {
+	for _, v := range header.Values("Sec-Websocket-Protocol") {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if containsString(supported, s) {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// Options of DialWithOptions.
+type DialOptions struct {
+	// Offer permessage-deflate, nil means do not offer.
+	Compression *CompressionOptions
+	// Offer subprotocols in order of preference, the chosen one is Conn.Subprotocol.
+	Subprotocols []string
 }
 
 // Client side connection.
 func Dial(req *http.Request, conn io.ReadWriteCloser) (*Conn, error) {
+	return DialWithOptions(req, conn, nil)
+}
+
+// Client side connection with options, nil opt is the same as Dial.
+// Use Dialer to connect a ws:// or wss:// URL.
+func DialWithOptions(req *http.Request, conn io.ReadWriteCloser, opt *DialOptions) (*Conn, error) {
+	c, _, err := handshake(req, conn, opt)
+	return c, err
+}
+
+// Write the handshake request, then read and check the response.
+// Return the response if it was read, even if the handshake failed.
+func handshake(req *http.Request, conn io.ReadWriteCloser, opt *DialOptions) (*Conn, *http.Response, error) {
+	if opt == nil {
+		opt = new(DialOptions)
+	}
 	// Set required header
 	secWebSocketKey := GenSecWebSocketKey()
 	req.Header.Set("Connection", "Upgrade")
 	req.Header.Set("Upgrade", "websocket")
 	req.Header.Set("Sec-WebSocket-Version", "13")
 	req.Header.Set("Sec-WebSocket-Key", secWebSocketKey)
+	if opt.Compression != nil {
+		req.Header.Set("Sec-WebSocket-Extensions", offerCompression(opt.Compression).String())
+	} else {
+		req.Header.Del("Sec-WebSocket-Extensions")
+	}
+	if len(opt.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(opt.Subprotocols, ", "))
+	} else {
+		req.Header.Del("Sec-WebSocket-Protocol")
+	}
 	// Write http request.
 	err := req.Write(conn)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// Read http response.
 	rd := bufio.NewReader(conn)
 	res, err := http.ReadResponse(rd, req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// Check status code.
 	if http.StatusSwitchingProtocols != res.StatusCode {
-		return nil, fmt.Errorf("status code %d", res.StatusCode)
+		// Keep a part of body, conn is going to be closed.
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return nil, res, fmt.Errorf("%w: status code %d", ErrBadHandshake, res.StatusCode)
 	}
 	// Check required header.
 	err = checkRequiredHeader(res.Header, clientRequiredHeader)
 	if err != nil {
-		return nil, err
+		return nil, res, fmt.Errorf("%w: %s", ErrBadHandshake, err.Error())
 	}
 	// Check "Sec-WebSocket-Accept" value.
-	key := req.Header.Get("Sec-Websocket-Accept")
+	key := res.Header.Get("Sec-Websocket-Accept")
 	if key != GenSecWebSocketAccept(secWebSocketKey) {
-		return nil, fmt.Errorf(`invalid "Sec-Websocket-Accept" value %s`, key)
+		return nil, res, fmt.Errorf(`%w: invalid "Sec-Websocket-Accept" value %q`, ErrBadHandshake, key)
+	}
+	// Subprotocol.
+	protocol := res.Header.Get("Sec-Websocket-Protocol")
+	if protocol != "" && !containsString(opt.Subprotocols, protocol) {
+		return nil, res, fmt.Errorf("%w: subprotocol %q was not offered", ErrBadHandshake, protocol)
+	}
+	// Extensions.
+	var compress *compression
+	if opt.Compression != nil {
+		compress, err = dialCompression(res.Header, opt.Compression)
+		if err != nil {
+			return nil, res, fmt.Errorf("%w: %s", ErrBadHandshake, err.Error())
+		}
+	} else if len(res.Header.Values("Sec-Websocket-Extensions")) > 0 {
+		return nil, res, fmt.Errorf("%w: server responded extensions which were not offered", ErrBadHandshake)
 	}
 	// Conn
-	return &Conn{conn: conn, mask: _Mask[1]}, nil
+	c := newConn(conn, rd, _Mask[1])
+	c.compress = compress
+	c.subprotocol = protocol
+	return c, res, nil
+}
+
+// Reports whether ss contains s.
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
 func GenSecWebSocketAccept(webSocketKey string) string {
@@ -119,19 +320,28 @@ func GenSecWebSocketAccept(webSocketKey string) string {
 
 func GenSecWebSocketKey() string {
 	var b [16]byte
-	random.Read(b[:])
+	rand.Read(b[:])
 	return base64.StdEncoding.EncodeToString(b[:])
 }
 
-func checkRequiredHeader(request http.Header, required map[string]string) error {
+// Check that every header in required contains the value as a token, case-insensitively.
+func checkRequiredHeader(header http.Header, required map[string]string) error {
 	for k, v := range required {
-		vs := request.Values(k)
-		for i := 0; i < len(vs); i++ {
-			if vs[i] == v {
-				return nil
-			}
+		if !headerContainsToken(header, k, v) {
+			return fmt.Errorf(`header "%s: %s" is required`, k, v)
 		}
-		return fmt.Errorf(`header "%s: %s" is required`, k, v)
 	}
 	return nil
 }
+
+// Reports whether the comma separated values of header key contain token, case-insensitively.
+func headerContainsToken(header http.Header, key, token string) bool {
+	for _, v := range header.Values(key) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
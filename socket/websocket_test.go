@@ -1,9 +1,13 @@
 package socket
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -110,3 +114,124 @@ func Test_websocket(t *testing.T) {
 	})
 	ser.ListenAndServe()
 }
+
+func Test_Accept_Reject(t *testing.T) {
+	valid := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "keep-alive, Upgrade")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", GenSecWebSocketKey())
+		return req
+	}
+	for _, c := range []struct {
+		modify func(*http.Request)
+		opt    *AcceptOptions
+		status int
+	}{
+		{func(r *http.Request) { r.Method = http.MethodPost }, nil, http.StatusMethodNotAllowed},
+		{func(r *http.Request) { r.Header.Del("Upgrade") }, nil, http.StatusBadRequest},
+		{func(r *http.Request) { r.Header.Set("Connection", "keep-alive") }, nil, http.StatusBadRequest},
+		{func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }, nil, http.StatusUpgradeRequired},
+		{func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "short") }, nil, http.StatusBadRequest},
+		{func(r *http.Request) { r.Header.Set("Origin", "https://evil.com") }, nil, http.StatusForbidden},
+		{func(r *http.Request) { r.Header.Set("Origin", "https://evil.com") }, &AcceptOptions{CheckOrigin: AllowOrigins("https://good.com")}, http.StatusForbidden},
+		// Valid, but httptest.ResponseRecorder is not a http.Hijacker.
+		{func(r *http.Request) { r.Header.Set("Origin", "http://EXAMPLE.com") }, nil, http.StatusInternalServerError},
+		{func(r *http.Request) { r.Header.Set("Origin", "https://good.com") }, &AcceptOptions{CheckOrigin: AllowOrigins("https://good.com")}, http.StatusInternalServerError},
+	} {
+		req := valid()
+		c.modify(req)
+		res := httptest.NewRecorder()
+		_, err := AcceptWithOptions(res, req, c.opt)
+		var e *HandshakeError
+		if !errors.As(err, &e) || !errors.Is(err, ErrBadHandshake) || e.Status != c.status || res.Code != c.status {
+			t.Fatal(err, res.Code, c.status)
+		}
+		if (res.Header().Get("Sec-WebSocket-Version") == "13") != (c.status == http.StatusUpgradeRequired) {
+			t.Fatal(res.Header())
+		}
+	}
+	// A wrapper which is a http.Hijacker, but the writer it wraps is not.
+	res := &test_NoHijack{httptest.NewRecorder()}
+	_, err := AcceptWithOptions(res, valid(), nil)
+	var e *HandshakeError
+	if !errors.As(err, &e) || e.Status != http.StatusInternalServerError || res.Code != http.StatusInternalServerError {
+		t.Fatal(err, res.Code)
+	}
+	if res.Header().Get("Upgrade") != "" || res.Header().Get("Sec-WebSocket-Accept") != "" {
+		t.Fatal(res.Header())
+	}
+}
+
+// Hijack is not supported.
+type test_NoHijack struct {
+	*httptest.ResponseRecorder
+}
+
+func (r *test_NoHijack) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+func Test_Accept_Subprotocol(t *testing.T) {
+	protocols := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		conn, err := AcceptWithOptions(res, req, &AcceptOptions{
+			Subprotocols: []string{"v1", "v2"},
+			Header:       http.Header{"X-Test": []string{"x"}, "Upgrade": []string{"other"}},
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		protocols <- conn.Subprotocol()
+		conn.Close()
+	}))
+	defer srv.Close()
+	for _, c := range []struct {
+		offer, protocol string
+	}{
+		{"", ""},
+		{"v3", ""},
+		{"v2, v1", "v2"},
+		{"v3, v1", "v1"},
+	} {
+		header := make(http.Header)
+		if c.offer != "" {
+			header.Set("Sec-WebSocket-Protocol", c.offer)
+		}
+		res, err := test_Dial(srv.URL, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := <-protocols; p != c.protocol || res.Header.Get("Sec-WebSocket-Protocol") != c.protocol {
+			t.Fatal(c.offer, p)
+		}
+		if res.Header.Get("X-Test") != "x" || res.Header.Get("Upgrade") != "websocket" {
+			t.Fatal(res.Header)
+		}
+	}
+}
+
+// Send a handshake request, return the response.
+func test_Dial(url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", GenSecWebSocketKey())
+	conn, err := net.Dial("tcp", req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = req.Write(conn)
+	if err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(conn), req)
+}